@@ -21,15 +21,21 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm"
 	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm/filters"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm/preemption"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm/priorities"
 	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/bridgecache"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/k8s/binder"
 	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/k8s/cache"
 	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/k8s/client"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/metrics"
 	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/server"
 	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/services"
 	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/vsphere"
+	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
 )
@@ -39,20 +45,72 @@ type Config struct {
 	// Debug mode
 	Debug bool
 
+	// DebugExplain serves the filter explain endpoint under debug/
+	DebugExplain bool
+
+	// FailOpen lets filter requests pass all nodes before caches are ready
+	FailOpen bool
+
 	// Port is the port to listen
 	Port int
 
+	// HealthPort is the plain HTTP port of /healthz, /readyz and /metrics,
+	// they are served on Port if it is 0
+	HealthPort int
+
+	// URLPrefix is the URL prefix the extender API is served under
+	URLPrefix string
+
+	// TLS is the TLS configuration, plain HTTP is served if CertFile is empty
+	TLS server.TLSOptions
+
 	// ClusterName is the name of the cluster where all the affinity rules are set
 	ClusterName string
+
+	// VsphereConfig is the file listing the vCenters and clusters, it
+	// overrides ClusterName and the -url flag
+	VsphereConfig string
+
+	// CredentialsDir is the directory with the vCenter credentials files
+	CredentialsDir string
+
+	// CredentialsSecret is the Secret "<namespace>/<name>" with the vCenter
+	// credentials
+	CredentialsSecret string
+
+	// NodeMatchers are the strategies matching nodes with VMs, in order
+	NodeMatchers string
 }
 
 var config Config
 
 func init() {
 	flag.IntVar(&config.Port, "port", 12346, "the port the extender listens on")
+	flag.IntVar(&config.HealthPort, "health-port", 12347,
+		"the plain HTTP port of /healthz, /readyz and /metrics, 0 serves them on -port")
+	flag.StringVar(&config.URLPrefix, "url-prefix", "/scheduler",
+		"the URL prefix the extender API is served under")
+	flag.StringVar(&config.TLS.CertFile, "tls-cert-file", "",
+		"the certificate file to serve HTTPS, plain HTTP is served if it is empty")
+	flag.StringVar(&config.TLS.KeyFile, "tls-key-file", "",
+		"the private key file of -tls-cert-file")
+	flag.StringVar(&config.TLS.ClientCAFile, "tls-client-ca-file", "",
+		"the CA file to verify client certificates, client certificates are not required if it is empty")
 	flag.BoolVar(&config.Debug, "debug", false, "debug mode")
+	flag.BoolVar(&config.DebugExplain, "debug-explain", false,
+		"serve the debug/explain endpoint explaining filter decisions")
+	flag.BoolVar(&config.FailOpen, "fail-open", false,
+		"pass all nodes in filter requests before caches are ready, instead of refusing them")
 	flag.StringVar(&config.ClusterName, "cluster", "cluster1",
 		"vSphere cluster name to setup affinity/anti-affinity rules")
+	flag.StringVar(&config.VsphereConfig, "vsphere-config", "",
+		"the file listing the vCenters and clusters of the node VMs, overriding -url and -cluster")
+	flag.StringVar(&config.CredentialsDir, "credentials-dir", "",
+		"the directory with the files username and password to log in to vCenter, such as a mounted Secret")
+	flag.StringVar(&config.CredentialsSecret, "credentials-secret", "",
+		"the Secret <namespace>/<name> with the keys username and password to log in to vCenter")
+	flag.StringVar(&config.NodeMatchers, "node-matchers", strings.Join(bridgecache.DefaultMatchers, ","),
+		"the comma separated strategies matching nodes with VMs, tried in order: providerID, uuid, ip, hostname")
 
 	flag.Parse()
 
@@ -70,22 +128,136 @@ func main() {
 	cache := k8scache.New(k8sClient)
 
 	// Init vsphere Client
-	vsclient := vsphere.NewCachedClient(config.ClusterName)
+	var vsclient vsphere.Vsphere
+	if config.VsphereConfig == "" {
+		credentials, err := vsphere.NewCredentials(config.CredentialsDir, config.CredentialsSecret,
+			k8sClient.CoreV1())
+		if err != nil {
+			log.Fatal(err)
+		}
+		vsclient = vsphere.NewCachedClient(config.ClusterName, credentials)
+	} else {
+		vsconfig, err := vsphere.LoadConfig(config.VsphereConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if vsclient, err = vsphere.NewMultiClient(vsconfig, k8sClient.CoreV1()); err != nil {
+			log.Fatal(err)
+		}
+	}
 	defer vsclient.Logout()
 
 	// Init bcache
-	bcache := bridgecache.NewCache(cache.NodeInformer(), vsclient)
+	matchers, err := bridgecache.NewMatchers(strings.Split(config.NodeMatchers, ","))
+	if err != nil {
+		log.Fatal(err)
+	}
+	bcache := bridgecache.NewCache(cache.NodeInformer(), vsclient, matchers)
+
+	// The pods being bound are listed before the informer sees them bound
+	pods := k8scache.NewAssumedPods(cache)
+	cache.PodInformer().AddEventHandler(k8scache.CreateHandler(pods))
+
+	// Setup topologies besides physical host
+	topologies := algorithm.Topologies{
+		filters.DatastoreTopologyKey: bridgecache.NewDatastoreTopology(bcache, vsclient),
+		filters.HostGroupTopologyKey: bridgecache.NewHostGroupTopology(bcache, vsclient, cache),
+	}
 
 	// Setup Filters
-	var filter algorithm.Filters
-	filter = append(filter, filters.NewPodAffinity(cache, cache))
-	filter = append(filter, filters.NewPodAntiAffinity(cache, cache))
+	hostStatus := bridgecache.NewHostStatus(vsclient)
+	newFilter := func(hostCache algorithm.HostCache) algorithm.Filter {
+		var filter algorithm.Filters
+		filter = append(filter, filters.NewHostStatus(hostCache, hostStatus))
+		filter = append(filter, filters.NewPodAffinity(pods, cache, hostCache, topologies))
+		filter = append(filter, filters.NewPodAntiAffinity(pods, cache, hostCache, topologies))
+		filter = append(filter, filters.NewTopologySpread(pods, hostCache))
+		return filter
+	}
+
+	// Setup Prioritizers
+	newPrioritizer := func(hostCache algorithm.HostCache) algorithm.Prioritizer {
+		var prioritizer algorithm.Prioritizers
+		prioritizer = append(prioritizer, priorities.NewHostSpreading(pods, cache, hostCache))
+		prioritizer = append(prioritizer, priorities.NewPreferredPodAffinity(pods, cache, hostCache))
+		prioritizer = append(prioritizer, priorities.NewTopologySpread(pods, hostCache))
+		return prioritizer
+	}
+
+	// Setup Preemptor
+	preemptor := preemption.NewHostPreemption(pods, cache, cache, topologies,
+		filters.NewPodAffinity, filters.NewPodAntiAffinity)
+
+	// Setup health checks
+	health := server.NewHealth(
+		server.Check{Name: "kubernetes", Ready: cache.HasSynced},
+		server.Check{Name: "vsphere", Ready: vsclient.HasSynced},
+		server.Check{Name: "vsphere-login", Ready: func() bool { return vsclient.LoginError() == nil },
+			Reason: func() string { return fmt.Sprint(vsclient.LoginError()) }},
+	)
 
 	// Setup handler
-	var handler http.Handler = &server.SchedExtenderHandler{
-		Filter: filter,
+	extender := &server.SchedExtenderHandler{
+		Filter:      newFilter(cache),
+		Prioritizer: newPrioritizer(cache),
+		Binder:      binder.New(k8sClient, cache, filters.NewPodAntiAffinity(pods, cache, cache, topologies), pods),
+		Preemptor:   preemptor,
+		NodeListFilter: func(nodes []v1.Node) algorithm.Filter {
+			return newFilter(k8scache.NewNodeListHostCache(nodes, cache))
+		},
+		NodeListPrioritizer: func(nodes []v1.Node) algorithm.Prioritizer {
+			return newPrioritizer(k8scache.NewNodeListHostCache(nodes, cache))
+		},
+		Ready:    health.Ready,
+		FailOpen: config.FailOpen,
 	}
 
+	// Setup router, the unversioned routes are kept for compatibility
+	router := server.NewRouter(config.URLPrefix)
+	router.Register("v1", extender.Routes()...)
+	router.Register("", extender.Routes()...)
+	if config.DebugExplain {
+		router.Register("debug", (&server.ExplainHandler{
+			Filter:     extender.Filter,
+			PodGetter:  cache,
+			NodeLister: cache,
+			HostCache:  cache,
+		}).Routes()...)
+	}
+
+	// Health and metrics are served on their own plain HTTP port, so that
+	// kubelet probes and scrapers need no client certificate
+	healthRouter := router
+	if config.HealthPort != 0 {
+		healthRouter = server.NewRouter("")
+	}
+	for _, route := range health.Routes() {
+		healthRouter.Handle(route)
+	}
+	healthRouter.Handle(server.Route{
+		Method:  http.MethodGet,
+		Path:    "/metrics",
+		Handler: metrics.DefaultRegistry.Handler().ServeHTTP,
+	})
+
+	metrics.NewGaugeFunc(metrics.DefaultRegistry, "vsphere_plugin_cache_pods",
+		"Number of pods in the informer cache.", func() float64 {
+			return float64(len(cache.PodInformer().GetStore().ListKeys()))
+		})
+	metrics.NewGaugeFunc(metrics.DefaultRegistry, "vsphere_plugin_vsphere_up",
+		"Whether the plugin is logged in to vCenter and watching it.", func() float64 {
+			if vsclient.LoginError() != nil || !vsclient.HasSynced() {
+				return 0
+			}
+			return 1
+		})
+	metrics.NewGaugeFunc(metrics.DefaultRegistry, "vsphere_plugin_cache_nodes",
+		"Number of nodes in the informer cache.", func() float64 {
+			return float64(len(cache.NodeInformer().GetStore().ListKeys()))
+		})
+
+	var handler http.Handler = router
+
 	// Add logging for debug mode
 	if config.Debug {
 		handler = server.LoggingDecorator(handler)
@@ -97,17 +269,35 @@ func main() {
 	// go nodeLabeller.Run(wait.NeverStop)
 
 	// Start DRSRuler
-	ruler := services.NewDRSRuler(cache.PodInformer(), bcache, cache, vsclient)
+	ruler := services.NewDRSRuler(cache.PodInformer(), bcache, cache, cache, vsclient)
 	go ruler.Run(wait.NeverStop)
 
 	go cache.Run(wait.NeverStop)
 
+	if config.HealthPort != 0 {
+		go func() {
+			log.Printf("start health server on :%d", config.HealthPort)
+			err := http.ListenAndServe(fmt.Sprintf(":%d", config.HealthPort), healthRouter)
+			log.Fatalf("[ERROR] health server exits: %s", err)
+		}()
+	}
+
 	// Start scheduler extender
 	s := &http.Server{
 		Addr:    fmt.Sprintf(":%d", config.Port),
 		Handler: handler,
 	}
 
-	log.Printf("start kubernetes scheduler extender on :%d", config.Port)
-	s.ListenAndServe()
+	if config.TLS.CertFile == "" {
+		log.Printf("start kubernetes scheduler extender on :%d", config.Port)
+		err = s.ListenAndServe()
+	} else {
+		if s.TLSConfig, err = server.NewTLSConfig(config.TLS); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("start kubernetes scheduler extender on :%d with TLS", config.Port)
+		err = s.ListenAndServeTLS("", "")
+	}
+
+	log.Fatalf("[ERROR] kubernetes scheduler extender exits: %s", err)
 }
@@ -0,0 +1,118 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package algorithm
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/api/core/v1"
+)
+
+// Explainer is implemented by Filters that can tell which existing pods take
+// part in filtering nodes for a pod.
+type Explainer interface {
+	// Name returns the name of the Filter
+	Name() string
+
+	// MatchedPods returns the existing pods matching the terms of pod, or
+	// whose terms match pod.
+	MatchedPods(pod *v1.Pod) ([]*v1.Pod, error)
+}
+
+// FilterExplanation records the decision of a Filter in a chain. Error is the
+// error of the Filter, MatchedPodsError the one of listing its matched pods,
+// which leaves the decision valid.
+type FilterExplanation struct {
+	Name             string      `json:"name"`
+	Before           []string    `json:"before"`
+	After            []string    `json:"after"`
+	FailedNodes      FailedNodes `json:"failedNodes,omitempty"`
+	MatchedPods      []string    `json:"matchedPods,omitempty"`
+	MatchedPodsError string      `json:"matchedPodsError,omitempty"`
+	Error            string      `json:"error,omitempty"`
+}
+
+// Explain filters nodes for pod like filter.Filter does, and records the
+// decision of every Filter in the chain. It stops at the first error.
+func Explain(filter Filter, pod *v1.Pod, nodes []string) []FilterExplanation {
+	result := []FilterExplanation{}
+	Walk(filter, pod, nodes, func(filter Filter, before, after []string, failed FailedNodes, err error) {
+		explanation := FilterExplanation{
+			Name:        FilterName(filter),
+			Before:      before,
+			After:       after,
+			FailedNodes: failed,
+		}
+		if err != nil {
+			explanation.Error = err.Error()
+		}
+
+		if explainer, ok := filter.(Explainer); ok {
+			pods, err := explainer.MatchedPods(pod)
+			if err != nil {
+				explanation.MatchedPodsError = err.Error()
+			}
+			for _, p := range pods {
+				explanation.MatchedPods = append(explanation.MatchedPods,
+					fmt.Sprintf("%s/%s on %s", p.Namespace, p.Name, p.Spec.NodeName))
+			}
+		}
+
+		result = append(result, explanation)
+	})
+	return result
+}
+
+// Walk filters nodes for pod like filter.Filter does, and calls visit with the
+// decision of every Filter in the chain, Filters are walked into. It stops at
+// the first error.
+func Walk(filter Filter, pod *v1.Pod, nodes []string,
+	visit func(filter Filter, before, after []string, failed FailedNodes, err error)) ([]string, FailedNodes, error) {
+	failedNodes := FailedNodes{}
+	nodes, err := walk(filter, pod, nodes, failedNodes, visit)
+	return nodes, failedNodes, err
+}
+
+func walk(filter Filter, pod *v1.Pod, nodes []string, failedNodes FailedNodes,
+	visit func(filter Filter, before, after []string, failed FailedNodes, err error)) ([]string, error) {
+	if filters, ok := filter.(Filters); ok {
+		for _, f := range filters {
+			var err error
+			if nodes, err = walk(f, pod, nodes, failedNodes, visit); err != nil {
+				return nodes, err
+			}
+		}
+		return nodes, nil
+	}
+
+	after, failed, err := filter.Filter(pod, nodes)
+	for node, reason := range failed {
+		failedNodes[node] = reason
+	}
+	visit(filter, nodes, after, failed, err)
+	return after, err
+}
+
+// FilterName returns the name of an Explainer, or the type name of other
+// Filters.
+func FilterName(filter Filter) string {
+	if explainer, ok := filter.(Explainer); ok {
+		return explainer.Name()
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", filter), "*")
+}
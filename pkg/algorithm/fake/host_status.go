@@ -0,0 +1,25 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake
+
+// HostStatus keeps the reasons why hosts are unavailable
+type HostStatus map[string]string
+
+// Unavailable returns why a given host is unavailable
+func (s HostStatus) Unavailable(host string) string {
+	return s[host]
+}
@@ -0,0 +1,39 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake
+
+import (
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/selector"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// NamespaceLister is a fake NamespaceLister which maps the namespace names to
+// their labels
+type NamespaceLister map[string]map[string]string
+
+var _ selector.NamespaceLister = NamespaceLister{}
+
+// ListNamespaces implements selector.NamespaceLister
+func (l NamespaceLister) ListNamespaces(s labels.Selector) ([]string, error) {
+	result := []string{}
+	for name, set := range l {
+		if s.Matches(labels.Set(set)) {
+			result = append(result, name)
+		}
+	}
+	return result, nil
+}
@@ -36,3 +36,18 @@ func (c NodeCache) GetNodes(host string) []string {
 
 	return result
 }
+
+// ListHosts returns all the hosts running nodes
+func (c NodeCache) ListHosts() []string {
+	result := []string{}
+	seen := make(map[string]struct{})
+
+	for _, host := range c {
+		if _, ok := seen[host]; !ok && host != "" {
+			seen[host] = struct{}{}
+			result = append(result, host)
+		}
+	}
+
+	return result
+}
@@ -20,7 +20,6 @@ import (
 	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm"
 	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/selector"
 	"k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/labels"
 )
 
 type podLister struct {
@@ -32,12 +31,28 @@ func NewPodLister(pods []*v1.Pod) algorithm.PodLister {
 	return &podLister{pods}
 }
 
-func (l *podLister) ListPod(selector selector.Selector) ([]*v1.Pod, error) {
+func (l *podLister) ListPod(s selector.Selector) ([]*v1.Pod, error) {
 	result := []*v1.Pod{}
 	for _, pod := range l.pods {
-		if selector.Matches(labels.Set(pod.GetLabels())) {
+		if s.Matches(selector.PodLabels(pod)) {
 			result = append(result, pod)
 		}
 	}
 	return result, nil
 }
+
+func (l *podLister) ListAntiAffinityPods(topologyKey string) ([]*v1.Pod, error) {
+	result := []*v1.Pod{}
+	for _, pod := range l.pods {
+		if pod.Spec.NodeName == "" {
+			continue
+		}
+		for _, key := range algorithm.AntiAffinityTopologyKeys(pod) {
+			if key == topologyKey {
+				result = append(result, pod)
+				break
+			}
+		}
+	}
+	return result, nil
+}
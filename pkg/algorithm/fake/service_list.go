@@ -0,0 +1,41 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake
+
+import (
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm"
+	"k8s.io/api/core/v1"
+)
+
+type serviceLister struct {
+	services []*v1.Service
+}
+
+// NewServiceLister creates a fake ServiceLister
+func NewServiceLister(services []*v1.Service) algorithm.ServiceLister {
+	return &serviceLister{services}
+}
+
+func (l *serviceLister) ListService(namespace string) ([]*v1.Service, error) {
+	result := []*v1.Service{}
+	for _, service := range l.services {
+		if service.Namespace == namespace {
+			result = append(result, service)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,41 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake
+
+// Topology keeps node to domains mapping
+type Topology map[string][]string
+
+// GetDomains returns the domains of a given node
+func (t Topology) GetDomains(node string) []string {
+	return t[node]
+}
+
+// GetNodes returns all the nodes in a given domain
+func (t Topology) GetNodes(domain string) []string {
+	result := []string{}
+
+	for node, domains := range t {
+		for _, d := range domains {
+			if d == domain {
+				result = append(result, node)
+				break
+			}
+		}
+	}
+
+	return result
+}
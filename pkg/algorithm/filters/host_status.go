@@ -0,0 +1,75 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filters
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm"
+	"k8s.io/api/core/v1"
+)
+
+type hostStatus struct {
+	hostCache  algorithm.HostCache
+	hostStatus algorithm.HostStatus
+}
+
+// NewHostStatus creates a filter removing the nodes on physical hosts that
+// should not get new pods, e.g. hosts in or entering maintenance mode, whose
+// VMs are about to be evacuated, or hosts that are not responding.
+func NewHostStatus(hostCache algorithm.HostCache, status algorithm.HostStatus) algorithm.Filter {
+	return &hostStatus{
+		hostCache:  hostCache,
+		hostStatus: status,
+	}
+}
+
+func (h *hostStatus) Filter(pod *v1.Pod, nodes []string) ([]string, algorithm.FailedNodes, error) {
+	log.Printf("apply hostStatus filter node: %s", nodes)
+
+	filtered := []string{}
+	failed := algorithm.FailedNodes{}
+	for _, node := range nodes {
+		host := h.hostCache.GetHost(node)
+		if host == "" {
+			// nothing known about the host
+			filtered = append(filtered, node)
+			continue
+		}
+
+		if reason := h.hostStatus.Unavailable(host); reason != "" {
+			failed[node] = fmt.Sprintf("host %s is %s", host, reason)
+			continue
+		}
+		filtered = append(filtered, node)
+	}
+
+	log.Printf("applied hostStatus filter node: %s", filtered)
+
+	return filtered, failed, nil
+}
+
+// Name implements algorithm.Explainer
+func (h *hostStatus) Name() string {
+	return "hostStatus"
+}
+
+// MatchedPods implements algorithm.Explainer
+func (h *hostStatus) MatchedPods(pod *v1.Pod) ([]*v1.Pod, error) {
+	return nil, nil
+}
@@ -0,0 +1,62 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filters
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm/fake"
+	"k8s.io/api/core/v1"
+)
+
+func TestHostStatus(t *testing.T) {
+	filter := NewHostStatus(
+		fake.NodeCache(map[string]string{
+			"node1": "host1",
+			"node2": "host1",
+			"node3": "host2",
+			"node4": "host3",
+			"node6": "host4",
+		}),
+		fake.HostStatus{
+			"host1": "entering maintenance mode",
+			"host3": "notResponding",
+			"host4": "poweredOff",
+		},
+	)
+
+	result, failed, err := filter.Filter(&v1.Pod{}, []string{"node1", "node2", "node3", "node4", "node5", "node6"})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if expect := []string{"node3", "node5"}; !reflect.DeepEqual(result, expect) {
+		t.Errorf("expect %v; got %v", expect, result)
+	}
+
+	expect := algorithm.FailedNodes{
+		"node1": "host host1 is entering maintenance mode",
+		"node2": "host host1 is entering maintenance mode",
+		"node4": "host host3 is notResponding",
+		"node6": "host host4 is poweredOff",
+	}
+	if !reflect.DeepEqual(failed, expect) {
+		t.Errorf("expect failed nodes %v; got %v", expect, failed)
+	}
+}
@@ -17,13 +17,13 @@ limitations under the License.
 package filters
 
 import (
+	"fmt"
 	"log"
 
 	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm"
 	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/constants"
 	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/selector"
 	"k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // HostTopologyKey is the TopologyKey used to indicate the scope of Affinity
@@ -31,82 +31,136 @@ import (
 const HostTopologyKey = constants.HostLabel
 
 type podAffinity struct {
-	podLister algorithm.PodLister
-	hostCache algorithm.HostCache
+	podLister       algorithm.PodLister
+	namespaceLister selector.NamespaceLister
+	hostCache       algorithm.HostCache
+	topologies      algorithm.Topologies
 }
 
-// NewPodAffinity creates a pod anti-affinity filter
-func NewPodAffinity(podLister algorithm.PodLister, hostCache algorithm.HostCache) algorithm.Filter {
+// NewPodAffinity creates a pod affinity filter. Terms on physical host scope
+// are resolved by hostCache, the others by topologies.
+func NewPodAffinity(podLister algorithm.PodLister, namespaceLister selector.NamespaceLister,
+	hostCache algorithm.HostCache, topologies algorithm.Topologies) algorithm.Filter {
 	return &podAffinity{
-		podLister: podLister,
-		hostCache: hostCache,
+		podLister:       podLister,
+		namespaceLister: namespaceLister,
+		hostCache:       hostCache,
+		topologies:      topologies,
 	}
 }
 
-func (p *podAffinity) Filter(pod *v1.Pod, nodes []string) ([]string, error) {
+func (p *podAffinity) Filter(pod *v1.Pod, nodes []string) ([]string, algorithm.FailedNodes, error) {
 	log.Printf("apply podAffinity filter node: %s", nodes)
 	if pod.Spec.Affinity == nil ||
 		pod.Spec.Affinity.PodAffinity == nil ||
 		pod.Spec.Affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
-		return nodes, nil
+		return nodes, algorithm.FailedNodes{}, nil
 	}
 
 	filtered := []string{}
+	failed := algorithm.FailedNodes{}
 	affinities := pod.Spec.Affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution
 
-	// Get pod selector
-	var selectors selector.And
-	for _, af := range affinities {
-		if af.TopologyKey != HostTopologyKey {
+	// Every term must be satisfied on its own, possibly by different pods,
+	// so collect the valid nodes of each term separately.
+	var termIndex []int
+	var termTopology []algorithm.Topology
+	var validNodes []map[string]struct{}
+	matchesSelf := true
+	matchingPodExists := false
+	for i, af := range affinities {
+		topology := topologyFor(af.TopologyKey, p.hostCache, p.topologies)
+		if topology == nil {
 			continue
 		}
 
-		selector, err := metav1.LabelSelectorAsSelector(af.LabelSelector)
+		s, err := selector.ForTerm(pod, selector.AffinityTerm, i, &affinities[i], p.namespaceLister)
 		if err != nil {
-			return filtered, err
+			return filtered, failed, err
 		}
 
-		selectors = append(selectors, selector)
+		// Select all matched pods
+		pods, err := p.podLister.ListPod(s)
+		if err != nil {
+			return filtered, failed, err
+		}
+
+		nodeMap := nodesInDomainsOf(pods, topology)
+		if len(nodeMap) > 0 {
+			matchingPodExists = true
+		}
+		if !s.Matches(selector.PodLabels(pod)) {
+			matchesSelf = false
+		}
+
+		termIndex = append(termIndex, i)
+		termTopology = append(termTopology, topology)
+		validNodes = append(validNodes, nodeMap)
 	}
 
-	// Select all matched pods
-	pods, err := p.podLister.ListPod(selectors)
-	if err != nil {
-		return filtered, err
+	if len(termIndex) == 0 {
+		// The terms on other topologies are left to the default scheduler
+		log.Printf("applied podAffinity filter node: %s (no term on handled topologies)", nodes)
+		return nodes, failed, nil
 	}
 
-	if len(pods) == 0 {
-		// no matching pods means no matching nodes
-		return filtered, nil
+	if !matchingPodExists && matchesSelf {
+		// Like Kubernetes, let the first pod of a group of pods affine to
+		// each other go anywhere, otherwise none of them could be scheduled.
+		log.Printf("applied podAffinity filter node: %s (first pod matching its own terms)", nodes)
+		return nodes, failed, nil
 	}
 
-	// Find valid hosts
-	nodeMap := make(map[string]struct{})
-	for _, pod := range pods {
-		if node := pod.Spec.NodeName; node != "" {
-			nodeMap[node] = struct{}{}
+	// no matching pods means no matching nodes
+	for _, node := range nodes {
+		ok := true
+		for i, nodeMap := range validNodes {
+			if _, ok = nodeMap[node]; !ok {
+				term := termIndex[i]
+				failed[node] = fmt.Sprintf("%s runs no pod matching affinity term %d",
+					describeNode(affinities[term].TopologyKey, termTopology[i], node), term)
+				break
+			}
+		}
+		if ok {
+			filtered = append(filtered, node)
 		}
 	}
 
-	hostMap := make(map[string]struct{})
-	for node := range nodeMap {
-		h := p.hostCache.GetHost(node)
-		hostMap[h] = struct{}{}
+	log.Printf("applied podAffinity filter node: %s", filtered)
+
+	return filtered, failed, nil
+}
+
+// Name implements algorithm.Explainer
+func (p *podAffinity) Name() string {
+	return "podAffinity"
+}
+
+// MatchedPods implements algorithm.Explainer
+func (p *podAffinity) MatchedPods(pod *v1.Pod) ([]*v1.Pod, error) {
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.PodAffinity == nil {
+		return nil, nil
 	}
 
-	for host := range hostMap {
-		for _, node := range p.hostCache.GetNodes(host) {
-			nodeMap[node] = struct{}{}
+	var result []*v1.Pod
+	affinities := pod.Spec.Affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	for i, af := range affinities {
+		if topologyFor(af.TopologyKey, p.hostCache, p.topologies) == nil {
+			continue
 		}
-	}
 
-	for _, node := range nodes {
-		if _, ok := nodeMap[node]; ok {
-			filtered = append(filtered, node)
+		s, err := selector.ForTerm(pod, selector.AffinityTerm, i, &affinities[i], p.namespaceLister)
+		if err != nil {
+			return result, err
 		}
-	}
 
-	log.Printf("applied podAffinity filter node: %s", filtered)
+		pods, err := p.podLister.ListPod(s)
+		if err != nil {
+			return result, err
+		}
+		result = appendAssigned(result, pods...)
+	}
 
-	return filtered, nil
+	return result, nil
 }
@@ -125,6 +125,146 @@ func TestPodAffinity(t *testing.T) {
 			nodes:  []string{"node1", "node2", "node3"},
 			expect: []string{"node1", "node2"},
 		},
+		{
+			desc: "pod affinity; multiple terms matched by different pods on one host.",
+			pod: &v1.Pod{
+				Spec: v1.PodSpec{
+					Affinity: &v1.Affinity{
+						PodAffinity: &v1.PodAffinity{
+							RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{
+								{
+									LabelSelector: &metav1.LabelSelector{
+										MatchLabels: map[string]string{"app": "db"},
+									},
+									Namespaces:  []string{},
+									TopologyKey: HostTopologyKey,
+								},
+								{
+									LabelSelector: &metav1.LabelSelector{
+										MatchLabels: map[string]string{"app": "cache"},
+									},
+									Namespaces:  []string{},
+									TopologyKey: HostTopologyKey,
+								},
+							},
+						},
+					},
+				},
+			},
+			pods: []*v1.Pod{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Labels: map[string]string{"app": "db"},
+					},
+					Spec: v1.PodSpec{
+						NodeName: "node1",
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Labels: map[string]string{"app": "cache"},
+					},
+					Spec: v1.PodSpec{
+						NodeName: "node2",
+					},
+				},
+			},
+			nodeToHost: map[string]string{
+				"node1": "host1",
+				"node2": "host1",
+				"node3": "host2",
+			},
+			nodes:  []string{"node1", "node2", "node3"},
+			expect: []string{"node1", "node2"},
+		},
+		{
+			desc: "pod affinity; multiple terms matched by pods on different hosts.",
+			pod: &v1.Pod{
+				Spec: v1.PodSpec{
+					Affinity: &v1.Affinity{
+						PodAffinity: &v1.PodAffinity{
+							RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{
+								{
+									LabelSelector: &metav1.LabelSelector{
+										MatchLabels: map[string]string{"app": "db"},
+									},
+									Namespaces:  []string{},
+									TopologyKey: HostTopologyKey,
+								},
+								{
+									LabelSelector: &metav1.LabelSelector{
+										MatchLabels: map[string]string{"app": "cache"},
+									},
+									Namespaces:  []string{},
+									TopologyKey: HostTopologyKey,
+								},
+							},
+						},
+					},
+				},
+			},
+			pods: []*v1.Pod{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Labels: map[string]string{"app": "db"},
+					},
+					Spec: v1.PodSpec{
+						NodeName: "node1",
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Labels: map[string]string{"app": "cache"},
+					},
+					Spec: v1.PodSpec{
+						NodeName: "node3",
+					},
+				},
+			},
+			nodeToHost: map[string]string{
+				"node1": "host1",
+				"node2": "host1",
+				"node3": "host2",
+			},
+			nodes:  []string{"node1", "node2", "node3"},
+			expect: []string{},
+		},
+		{
+			desc: "pod affinity; no terms on handled topologies; nodes left to the default scheduler.",
+			pod: &v1.Pod{
+				Spec: v1.PodSpec{
+					Affinity: &v1.Affinity{
+						PodAffinity: &v1.PodAffinity{
+							RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{
+								{
+									LabelSelector: &metav1.LabelSelector{
+										MatchLabels: map[string]string{"key": "value"},
+									},
+									TopologyKey: "failure-domain.beta.kubernetes.io/zone",
+								},
+							},
+						},
+					},
+				},
+			},
+			pods: []*v1.Pod{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Labels: map[string]string{"key": "other"},
+					},
+					Spec: v1.PodSpec{
+						NodeName: "node1",
+					},
+				},
+			},
+			nodeToHost: map[string]string{
+				"node1": "host1",
+				"node2": "host1",
+				"node3": "host2",
+			},
+			nodes:  []string{"node1", "node2", "node3"},
+			expect: []string{"node1", "node2", "node3"},
+		},
 	}
 
 	for _, test := range tests {
@@ -132,7 +272,91 @@ func TestPodAffinity(t *testing.T) {
 			podLister: fake.NewPodLister(test.pods),
 			hostCache: fake.NodeCache(test.nodeToHost),
 		}
-		result, err := filter.Filter(test.pod, test.nodes)
+		result, _, err := filter.Filter(test.pod, test.nodes)
+		if err != nil {
+			t.Error(err)
+		}
+		if !reflect.DeepEqual(result, test.expect) {
+			t.Errorf("[%s] expect %s; got %s", test.desc, test.expect, result)
+		}
+	}
+}
+
+func TestPodAffinityFirstReplica(t *testing.T) {
+	newPod := func(labels map[string]string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "web-0",
+				Labels: labels,
+			},
+			Spec: v1.PodSpec{
+				Affinity: &v1.Affinity{
+					PodAffinity: &v1.PodAffinity{
+						RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{
+							{
+								LabelSelector: &metav1.LabelSelector{
+									MatchLabels: map[string]string{"app": "web"},
+								},
+								TopologyKey: HostTopologyKey,
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		desc   string
+		pod    *v1.Pod
+		pods   []*v1.Pod
+		expect []string
+	}{
+		{
+			desc:   "first replica matching its own term",
+			pod:    newPod(map[string]string{"app": "web"}),
+			expect: []string{"node1", "node2"},
+		},
+		{
+			desc: "first replica matching its own term; itself pending",
+			pod:  newPod(map[string]string{"app": "web"}),
+			pods: []*v1.Pod{
+				newPod(map[string]string{"app": "web"}),
+			},
+			expect: []string{"node1", "node2"},
+		},
+		{
+			desc:   "pod not matching its own term",
+			pod:    newPod(map[string]string{"app": "db"}),
+			expect: []string{},
+		},
+		{
+			desc: "second replica",
+			pod:  newPod(map[string]string{"app": "web"}),
+			pods: []*v1.Pod{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:   "web-1",
+						Labels: map[string]string{"app": "web"},
+					},
+					Spec: v1.PodSpec{
+						NodeName: "node2",
+					},
+				},
+			},
+			expect: []string{"node2"},
+		},
+	}
+
+	for _, test := range tests {
+		filter := &podAffinity{
+			podLister: fake.NewPodLister(test.pods),
+			hostCache: fake.NodeCache(map[string]string{
+				"node1": "host1",
+				"node2": "host2",
+			}),
+		}
+		result, _, err := filter.Filter(test.pod, []string{"node1", "node2"})
 		if err != nil {
 			t.Error(err)
 		}
@@ -17,91 +17,211 @@ limitations under the License.
 package filters
 
 import (
+	"fmt"
 	"log"
+	"sort"
 
 	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm"
 	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/selector"
 	"k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type podAntiAffinity struct {
-	podLister algorithm.PodLister
-	hostCache algorithm.HostCache
+	podLister       algorithm.PodLister
+	namespaceLister selector.NamespaceLister
+	hostCache       algorithm.HostCache
+	topologies      algorithm.Topologies
 }
 
-// NewPodAntiAffinity creates a pod anti-affinity filter
-func NewPodAntiAffinity(podLister algorithm.PodLister, hostCache algorithm.HostCache) algorithm.Filter {
+// NewPodAntiAffinity creates a pod anti-affinity filter. Terms on physical
+// host scope are resolved by hostCache, the others by topologies.
+func NewPodAntiAffinity(podLister algorithm.PodLister, namespaceLister selector.NamespaceLister,
+	hostCache algorithm.HostCache, topologies algorithm.Topologies) algorithm.Filter {
 	return &podAntiAffinity{
-		podLister: podLister,
-		hostCache: hostCache,
+		podLister:       podLister,
+		namespaceLister: namespaceLister,
+		hostCache:       hostCache,
+		topologies:      topologies,
 	}
 }
 
-func (p *podAntiAffinity) Filter(pod *v1.Pod, nodes []string) ([]string, error) {
+func (p *podAntiAffinity) Filter(pod *v1.Pod, nodes []string) ([]string, algorithm.FailedNodes, error) {
 	log.Printf("apply podAntiAffinity filter node: %s", nodes)
-	if pod.Spec.Affinity == nil ||
-		pod.Spec.Affinity.PodAntiAffinity == nil ||
-		pod.Spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
-		return nodes, nil
-	}
 
 	filtered := []string{}
-	affinities := pod.Spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution
-
-	// Get pod selector
-	var selectors selector.Or
-	for _, af := range affinities {
-		if af.TopologyKey != HostTopologyKey {
+	failed := algorithm.FailedNodes{}
+	affinities := algorithm.AntiAffinityTerms(pod)
+
+	// Find invalid nodes, with the reason. Terms are evaluated one by one so
+	// that the reason names the term the existing pod violates.
+	reasons := make(map[string]string)
+	for i, af := range affinities {
+		topology := topologyFor(af.TopologyKey, p.hostCache, p.topologies)
+		if topology == nil {
 			continue
 		}
 
-		selector, err := metav1.LabelSelectorAsSelector(af.LabelSelector)
+		s, err := selector.ForTerm(pod, selector.AntiAffinityTerm, i, &affinities[i], p.namespaceLister)
 		if err != nil {
-			return filtered, err
+			return filtered, failed, err
 		}
 
-		selectors = append(selectors, selector)
-	}
+		// Select all matched pods
+		pods, err := p.podLister.ListPod(s)
+		if err != nil {
+			return filtered, failed, err
+		}
+
+		for _, pod := range pods {
+			node := pod.Spec.NodeName
+			if node == "" {
+				continue
+			}
 
-	// Select all matched pods
-	pods, err := p.podLister.ListPod(selectors)
-	if err != nil {
-		return filtered, err
+			reject(af.TopologyKey, topology, node, reasons, func(where string) string {
+				return fmt.Sprintf("%s already runs pod %s matching anti-affinity term %d",
+					where, pod.Name, i)
+			})
+		}
 	}
 
-	if len(pods) == 0 {
-		// no matching pods means no nodes are filtered
-		return nodes, nil
+	// Anti-affinity is symmetric, the existing pods' terms protect their
+	// domains from pod as well.
+	if err := p.rejectByExistingPods(pod, reasons); err != nil {
+		return filtered, failed, err
 	}
 
-	// Find valid hosts
-	nodeMap := make(map[string]struct{})
-	for _, pod := range pods {
-		if node := pod.Spec.NodeName; node != "" {
-			nodeMap[node] = struct{}{}
+	for _, node := range nodes {
+		if reason, ok := reasons[node]; ok {
+			failed[node] = reason
+		} else {
+			filtered = append(filtered, node)
 		}
 	}
 
-	hostMap := make(map[string]struct{})
-	for node := range nodeMap {
-		h := p.hostCache.GetHost(node)
-		hostMap[h] = struct{}{}
+	log.Printf("applied podAntiAffinity filter node: %s", filtered)
+
+	return filtered, failed, nil
+}
+
+// rejectByExistingPods records a reason for every node sharing a domain with
+// a pod whose anti-affinity terms match pod.
+func (p *podAntiAffinity) rejectByExistingPods(pod *v1.Pod, reasons map[string]string) error {
+	return p.forEachExistingMatch(pod, func(key string, topology algorithm.Topology, existing *v1.Pod, term int) {
+		reject(key, topology, existing.Spec.NodeName, reasons, func(where string) string {
+			return fmt.Sprintf("%s runs pod %s whose anti-affinity term %d matches the pod",
+				where, existing.Name, term)
+		})
+	})
+}
+
+// forEachExistingMatch calls fn with every existing pod, and the first of its
+// anti-affinity terms that matches pod.
+func (p *podAntiAffinity) forEachExistingMatch(pod *v1.Pod,
+	fn func(key string, topology algorithm.Topology, existing *v1.Pod, term int)) error {
+	topologyKeys := []string{}
+	for key := range p.topologies {
+		if key != HostTopologyKey {
+			topologyKeys = append(topologyKeys, key)
+		}
 	}
+	sort.Strings(topologyKeys)
+	topologyKeys = append([]string{HostTopologyKey}, topologyKeys...)
+
+	podLabels := selector.PodLabels(pod)
+	for _, key := range topologyKeys {
+		topology := topologyFor(key, p.hostCache, p.topologies)
 
-	for host := range hostMap {
-		for _, node := range p.hostCache.GetNodes(host) {
-			nodeMap[node] = struct{}{}
+		existingPods, err := p.podLister.ListAntiAffinityPods(key)
+		if err != nil {
+			return err
+		}
+
+		for _, existing := range existingPods {
+			if pod.UID != "" && existing.UID == pod.UID {
+				continue
+			}
+
+			terms := algorithm.AntiAffinityTerms(existing)
+			for i := range terms {
+				if terms[i].TopologyKey != key {
+					continue
+				}
+
+				s, err := selector.ForTerm(existing, selector.AntiAffinityTerm, i, &terms[i], p.namespaceLister)
+				if err != nil {
+					log.Printf("[WARNING] invalid anti-affinity term of pod %s/%s: %s",
+						existing.Namespace, existing.Name, err)
+					continue
+				}
+
+				if s.Matches(podLabels) {
+					fn(key, topology, existing, i)
+					break
+				}
+			}
 		}
 	}
 
-	for _, node := range nodes {
-		if _, ok := nodeMap[node]; !ok {
-			filtered = append(filtered, node)
+	return nil
+}
+
+// Name implements algorithm.Explainer
+func (p *podAntiAffinity) Name() string {
+	return "podAntiAffinity"
+}
+
+// MatchedPods implements algorithm.Explainer
+func (p *podAntiAffinity) MatchedPods(pod *v1.Pod) ([]*v1.Pod, error) {
+	var result []*v1.Pod
+
+	affinities := algorithm.AntiAffinityTerms(pod)
+	for i, af := range affinities {
+		if topologyFor(af.TopologyKey, p.hostCache, p.topologies) == nil {
+			continue
 		}
+
+		s, err := selector.ForTerm(pod, selector.AntiAffinityTerm, i, &affinities[i], p.namespaceLister)
+		if err != nil {
+			return result, err
+		}
+
+		pods, err := p.podLister.ListPod(s)
+		if err != nil {
+			return result, err
+		}
+		result = appendAssigned(result, pods...)
 	}
 
-	log.Printf("applied podAntiAffinity filter node: %s", filtered)
+	err := p.forEachExistingMatch(pod, func(key string, topology algorithm.Topology, existing *v1.Pod, term int) {
+		result = appendAssigned(result, existing)
+	})
+
+	return result, err
+}
+
+// reject records a reason for node and the other nodes sharing a domain of
+// topology with it, unless they are already rejected. The reason is built
+// from the description of the shared domain.
+func reject(topologyKey string, topology algorithm.Topology, node string,
+	reasons map[string]string, reason func(where string) string) {
+	domains := topology.GetDomains(node)
+	if len(domains) == 0 {
+		if _, ok := reasons[node]; !ok {
+			reasons[node] = reason("node " + node)
+		}
+		return
+	}
 
-	return filtered, nil
+	for _, domain := range domains {
+		where := describeDomain(topologyKey, domain)
+		if _, ok := reasons[node]; !ok {
+			reasons[node] = reason(where)
+		}
+		for _, n := range topology.GetNodes(domain) {
+			if _, ok := reasons[n]; !ok {
+				reasons[n] = reason(where)
+			}
+		}
+	}
 }
@@ -20,6 +20,7 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm"
 	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm/fake"
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -170,6 +171,86 @@ func TestPodAntiAffinity(t *testing.T) {
 			nodes:  []string{"node1", "node2"},
 			expect: []string{"node1", "node2"},
 		},
+		{
+			desc: "pod anti-affinity; matching pod in another namespace",
+			pod: &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "team-a",
+				},
+				Spec: v1.PodSpec{
+					Affinity: &v1.Affinity{
+						PodAntiAffinity: &v1.PodAntiAffinity{
+							RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{
+								{
+									LabelSelector: &metav1.LabelSelector{
+										MatchLabels: map[string]string{"app": "web"},
+									},
+									Namespaces:  nil,
+									TopologyKey: HostTopologyKey,
+								},
+							},
+						},
+					},
+				},
+			},
+			pods: []*v1.Pod{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Namespace: "team-b",
+						Labels:    map[string]string{"app": "web"},
+					},
+					Spec: v1.PodSpec{
+						NodeName: "node1",
+					},
+				},
+			},
+			nodeToHost: map[string]string{
+				"node1": "host1",
+				"node2": "host2",
+			},
+			nodes:  []string{"node1", "node2"},
+			expect: []string{"node1", "node2"},
+		},
+		{
+			desc: "pod anti-affinity; matching pod in listed namespace",
+			pod: &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "team-a",
+				},
+				Spec: v1.PodSpec{
+					Affinity: &v1.Affinity{
+						PodAntiAffinity: &v1.PodAntiAffinity{
+							RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{
+								{
+									LabelSelector: &metav1.LabelSelector{
+										MatchLabels: map[string]string{"app": "web"},
+									},
+									Namespaces:  []string{"team-b"},
+									TopologyKey: HostTopologyKey,
+								},
+							},
+						},
+					},
+				},
+			},
+			pods: []*v1.Pod{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Namespace: "team-b",
+						Labels:    map[string]string{"app": "web"},
+					},
+					Spec: v1.PodSpec{
+						NodeName: "node1",
+					},
+				},
+			},
+			nodeToHost: map[string]string{
+				"node1": "host1",
+				"node2": "host2",
+			},
+			nodes:  []string{"node1", "node2"},
+			expect: []string{"node2"},
+		},
 	}
 
 	for _, test := range tests {
@@ -177,12 +258,240 @@ func TestPodAntiAffinity(t *testing.T) {
 			podLister: fake.NewPodLister(test.pods),
 			hostCache: fake.NodeCache(test.nodeToHost),
 		}
-		result, err := filter.Filter(test.pod, test.nodes)
+		result, _, err := filter.Filter(test.pod, test.nodes)
+		if err != nil {
+			t.Error(err)
+		}
+		if !reflect.DeepEqual(result, test.expect) {
+			t.Errorf("[%s] expect %s; got %s", test.desc, test.expect, result)
+		}
+	}
+}
+
+func TestPodAntiAffinityFailedNodes(t *testing.T) {
+	pod := &v1.Pod{
+		Spec: v1.PodSpec{
+			Affinity: &v1.Affinity{
+				PodAntiAffinity: &v1.PodAntiAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{
+						{
+							LabelSelector: &metav1.LabelSelector{
+								MatchLabels: map[string]string{"key": "value"},
+							},
+							TopologyKey: "kubernetes.io/hostname",
+						},
+						{
+							LabelSelector: &metav1.LabelSelector{
+								MatchLabels: map[string]string{"key": "value"},
+							},
+							TopologyKey: HostTopologyKey,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	pods := []*v1.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "etcd-1",
+				Labels: map[string]string{"key": "value"},
+			},
+			Spec: v1.PodSpec{
+				NodeName: "node1",
+			},
+		},
+	}
+
+	filter := &podAntiAffinity{
+		podLister: fake.NewPodLister(pods),
+		hostCache: fake.NodeCache(map[string]string{
+			"node1": "esx-03",
+			"node2": "esx-03",
+			"node3": "esx-04",
+		}),
+	}
+
+	result, failed, err := filter.Filter(pod, []string{"node1", "node2", "node3"})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if expect := []string{"node3"}; !reflect.DeepEqual(result, expect) {
+		t.Errorf("expect %s; got %s", expect, result)
+	}
+
+	expectFailed := algorithm.FailedNodes{
+		"node1": "host esx-03 already runs pod etcd-1 matching anti-affinity term 1",
+		"node2": "host esx-03 already runs pod etcd-1 matching anti-affinity term 1",
+	}
+	if !reflect.DeepEqual(failed, expectFailed) {
+		t.Errorf("expect failed nodes %v; got %v", expectFailed, failed)
+	}
+}
+
+func TestPodAntiAffinitySymmetric(t *testing.T) {
+	existing := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "a-0",
+			Labels: map[string]string{"app": "a"},
+		},
+		Spec: v1.PodSpec{
+			NodeName: "node1",
+			Affinity: &v1.Affinity{
+				PodAntiAffinity: &v1.PodAntiAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{
+						{
+							LabelSelector: &metav1.LabelSelector{
+								MatchLabels: map[string]string{"app": "b"},
+							},
+							TopologyKey: "kubernetes.io/hostname",
+						},
+						{
+							LabelSelector: &metav1.LabelSelector{
+								MatchLabels: map[string]string{"app": "b"},
+							},
+							TopologyKey: HostTopologyKey,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		desc   string
+		pod    *v1.Pod
+		expect []string
+	}{
+		{
+			desc: "pod matching existing pod's term",
+			pod: &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{"app": "b"},
+				},
+			},
+			expect: []string{"node3"},
+		},
+		{
+			desc: "pod not matching existing pod's term",
+			pod: &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{"app": "c"},
+				},
+			},
+			expect: []string{"node1", "node2", "node3"},
+		},
+		{
+			desc: "pod matching existing pod's term in another namespace",
+			pod: &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "other",
+					Labels:    map[string]string{"app": "b"},
+				},
+			},
+			expect: []string{"node1", "node2", "node3"},
+		},
+	}
+
+	for _, test := range tests {
+		filter := &podAntiAffinity{
+			podLister: fake.NewPodLister([]*v1.Pod{existing}),
+			hostCache: fake.NodeCache(map[string]string{
+				"node1": "esx-01",
+				"node2": "esx-01",
+				"node3": "esx-02",
+			}),
+		}
+		result, failed, err := filter.Filter(test.pod, []string{"node1", "node2", "node3"})
 		if err != nil {
 			t.Error(err)
 		}
 		if !reflect.DeepEqual(result, test.expect) {
 			t.Errorf("[%s] expect %s; got %s", test.desc, test.expect, result)
 		}
+		if len(failed) > 0 {
+			expect := "host esx-01 runs pod a-0 whose anti-affinity term 1 matches the pod"
+			if failed["node2"] != expect {
+				t.Errorf("[%s] expect reason %q; got %q", test.desc, expect, failed["node2"])
+			}
+		}
+	}
+}
+
+func TestPodAntiAffinityDatastore(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{"app": "db"},
+		},
+		Spec: v1.PodSpec{
+			Affinity: &v1.Affinity{
+				PodAntiAffinity: &v1.PodAntiAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{
+						{
+							LabelSelector: &metav1.LabelSelector{
+								MatchLabels: map[string]string{"app": "db"},
+							},
+							TopologyKey: DatastoreTopologyKey,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	pods := []*v1.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "db-0",
+				Labels: map[string]string{"app": "db"},
+			},
+			Spec: v1.PodSpec{
+				NodeName: "node1",
+			},
+		},
+	}
+
+	// node1 and node3 share ds-2, although they run on different hosts
+	nodeToHost := map[string]string{
+		"node1": "esx-01",
+		"node2": "esx-01",
+		"node3": "esx-02",
+	}
+	nodeToDatastores := map[string][]string{
+		"node1": {"ds-1", "ds-2"},
+		"node2": {"ds-3"},
+		"node3": {"ds-2"},
+	}
+
+	filter := NewPodAntiAffinity(fake.NewPodLister(pods), nil, fake.NodeCache(nodeToHost),
+		algorithm.Topologies{DatastoreTopologyKey: fake.Topology(nodeToDatastores)})
+
+	result, failed, err := filter.Filter(pod, []string{"node1", "node2", "node3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []string{"node2"}
+	if !reflect.DeepEqual(result, expect) {
+		t.Errorf("expect %s; got %s", expect, result)
+	}
+
+	reason := "datastore ds-2 already runs pod db-0 matching anti-affinity term 0"
+	if failed["node3"] != reason {
+		t.Errorf("expect reason %q; got %q", reason, failed["node3"])
+	}
+
+	// without the datastore topology the term is ignored
+	filter = NewPodAntiAffinity(fake.NewPodLister(pods), nil, fake.NodeCache(nodeToHost), nil)
+	result, _, err = filter.Filter(pod, []string{"node1", "node2", "node3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect = []string{"node1", "node2", "node3"}
+	if !reflect.DeepEqual(result, expect) {
+		t.Errorf("expect %s; got %s", expect, result)
 	}
 }
@@ -0,0 +1,114 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filters
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/constants"
+	"k8s.io/api/core/v1"
+)
+
+// DatastoreTopologyKey is the TopologyKey used to indicate the scope of
+// Affinity and AntiAffinity rule is the datastores of node VMs.
+const DatastoreTopologyKey = constants.DatastoreLabel
+
+// HostGroupTopologyKey is the TopologyKey used to indicate the scope of
+// Affinity and AntiAffinity rule is the DRS host groups of physical hosts.
+const HostGroupTopologyKey = constants.HostGroupLabel
+
+// topologyFor returns the Topology of topologyKey, or nil if the filters don't
+// handle the topology key.
+func topologyFor(topologyKey string, hostCache algorithm.HostCache, topologies algorithm.Topologies) algorithm.Topology {
+	if topologyKey == HostTopologyKey {
+		return algorithm.HostTopology(hostCache)
+	}
+	return topologies[topologyKey]
+}
+
+// nodesInDomainsOf returns the nodes sharing a domain of topology with any of
+// pods.
+func nodesInDomainsOf(pods []*v1.Pod, topology algorithm.Topology) map[string]struct{} {
+	nodeMap := make(map[string]struct{})
+	for _, pod := range pods {
+		if node := pod.Spec.NodeName; node != "" {
+			nodeMap[node] = struct{}{}
+		}
+	}
+
+	domainMap := make(map[string]struct{})
+	for node := range nodeMap {
+		for _, domain := range topology.GetDomains(node) {
+			domainMap[domain] = struct{}{}
+		}
+	}
+
+	for domain := range domainMap {
+		for _, node := range topology.GetNodes(domain) {
+			nodeMap[node] = struct{}{}
+		}
+	}
+
+	return nodeMap
+}
+
+// describeDomain names a domain of topologyKey in a failure reason
+func describeDomain(topologyKey, domain string) string {
+	switch topologyKey {
+	case HostTopologyKey:
+		return "host " + domain
+	case DatastoreTopologyKey:
+		return "datastore " + domain
+	case HostGroupTopologyKey:
+		return "host group " + domain
+	default:
+		return fmt.Sprintf("%s=%s", topologyKey, domain)
+	}
+}
+
+// describeNode names the domains of node in a failure reason, or the node
+// itself if its domains are unknown.
+func describeNode(topologyKey string, topology algorithm.Topology, node string) string {
+	if domains := topology.GetDomains(node); len(domains) > 0 {
+		return describeDomain(topologyKey, strings.Join(domains, ","))
+	}
+	return "node " + node
+}
+
+// appendAssigned appends the pods assigned to a node to result, unless they
+// are already in it.
+func appendAssigned(result []*v1.Pod, pods ...*v1.Pod) []*v1.Pod {
+	for _, pod := range pods {
+		if pod.Spec.NodeName == "" {
+			continue
+		}
+
+		found := false
+		for _, p := range result {
+			if p == pod {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, pod)
+		}
+	}
+	return result
+}
@@ -0,0 +1,137 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filters
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm"
+	"k8s.io/api/core/v1"
+)
+
+type topologySpread struct {
+	podLister algorithm.PodLister
+	hostCache algorithm.HostCache
+}
+
+// NewTopologySpread creates a filter enforcing the DoNotSchedule topology
+// spread constraints on physical host scope: placing the pod must not make
+// its host run more than maxSkew matching pods above the least loaded host
+// known to hostCache.
+func NewTopologySpread(podLister algorithm.PodLister, hostCache algorithm.HostCache) algorithm.Filter {
+	return &topologySpread{
+		podLister: podLister,
+		hostCache: hostCache,
+	}
+}
+
+func (t *topologySpread) Filter(pod *v1.Pod, nodes []string) ([]string, algorithm.FailedNodes, error) {
+	log.Printf("apply topologySpread filter node: %s", nodes)
+
+	filtered := []string{}
+	failed := algorithm.FailedNodes{}
+
+	constraints, err := algorithm.HostSpreadConstraints(pod, algorithm.DoNotSchedule)
+	if err != nil {
+		return filtered, failed, err
+	}
+	if len(constraints) == 0 {
+		return nodes, failed, nil
+	}
+
+	for i := range constraints {
+		c := &constraints[i]
+		counts, matchesSelf, err := algorithm.CountPodsPerHost(t.podLister, t.hostCache, pod, c)
+		if err != nil {
+			return filtered, failed, err
+		}
+
+		self := 0
+		if matchesSelf {
+			self = 1
+		}
+
+		// Like Kubernetes, the skew is relative to the least loaded host of
+		// the cluster, not only of the given nodes
+		minCount := -1
+		for _, host := range t.hostCache.ListHosts() {
+			if count := counts[host]; minCount < 0 || count < minCount {
+				minCount = count
+			}
+		}
+		if minCount < 0 {
+			minCount = 0
+		}
+
+		for _, node := range nodes {
+			if _, ok := failed[node]; ok {
+				continue
+			}
+
+			host := t.hostCache.GetHost(node)
+			if host == "" {
+				failed[node] = fmt.Sprintf("node %s has no host for topology spread constraint %d", node, i)
+				continue
+			}
+
+			if skew := counts[host] + self - minCount; skew > int(c.MaxSkew) {
+				failed[node] = fmt.Sprintf("host %s would run %d matching pods, exceeding maxSkew %d of topology spread constraint %d",
+					host, counts[host]+self, c.MaxSkew, i)
+			}
+		}
+	}
+
+	for _, node := range nodes {
+		if _, ok := failed[node]; !ok {
+			filtered = append(filtered, node)
+		}
+	}
+
+	log.Printf("applied topologySpread filter node: %s", filtered)
+
+	return filtered, failed, nil
+}
+
+// Name implements algorithm.Explainer
+func (t *topologySpread) Name() string {
+	return "topologySpread"
+}
+
+// MatchedPods implements algorithm.Explainer
+func (t *topologySpread) MatchedPods(pod *v1.Pod) ([]*v1.Pod, error) {
+	constraints, err := algorithm.HostSpreadConstraints(pod, algorithm.DoNotSchedule)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*v1.Pod
+	for i := range constraints {
+		s, err := constraints[i].PodSelector(pod)
+		if err != nil {
+			return result, err
+		}
+
+		pods, err := t.podLister.ListPod(s)
+		if err != nil {
+			return result, err
+		}
+		result = appendAssigned(result, pods...)
+	}
+
+	return result, nil
+}
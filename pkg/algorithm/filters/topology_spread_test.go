@@ -0,0 +1,156 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filters
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm/fake"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/constants"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func spreadPod(name, node, constraints string) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{"app": "web"},
+		},
+		Spec: v1.PodSpec{
+			NodeName: node,
+		},
+	}
+	if constraints != "" {
+		pod.Annotations = map[string]string{constants.TopologySpreadAnnotation: constraints}
+	}
+	return pod
+}
+
+func TestTopologySpread(t *testing.T) {
+	nodeToHost := map[string]string{
+		"node1": "host1",
+		"node2": "host1",
+		"node3": "host2",
+		"node4": "host3",
+	}
+
+	tests := []struct {
+		desc        string
+		constraints string
+		pods        []*v1.Pod
+		nodes       []string
+		expect      []string
+		expectErr   bool
+	}{
+		{
+			desc:   "no constraints; all nodes pass.",
+			pods:   []*v1.Pod{spreadPod("p1", "node1", "")},
+			nodes:  []string{"node1", "node3"},
+			expect: []string{"node1", "node3"},
+		},
+		{
+			desc:        "maxSkew 1; hosts above the least loaded host are filtered out.",
+			constraints: `[{"maxSkew": 1, "topologyKey": "alpha.cna.vmware.com/host", "whenUnsatisfiable": "DoNotSchedule", "labelSelector": {"matchLabels": {"app": "web"}}}]`,
+			pods: []*v1.Pod{
+				spreadPod("p1", "node1", ""),
+				spreadPod("p2", "node3", ""),
+			},
+			nodes:  []string{"node1", "node2", "node3", "node4"},
+			expect: []string{"node4"},
+		},
+		{
+			desc:        "maxSkew 2; one more pod per host is allowed.",
+			constraints: `[{"maxSkew": 2, "topologyKey": "alpha.cna.vmware.com/host", "whenUnsatisfiable": "DoNotSchedule", "labelSelector": {"matchLabels": {"app": "web"}}}]`,
+			pods: []*v1.Pod{
+				spreadPod("p1", "node1", ""),
+				spreadPod("p2", "node2", ""),
+			},
+			nodes:  []string{"node1", "node3", "node4"},
+			expect: []string{"node3", "node4"},
+		},
+		{
+			desc:        "the hosts of other nodes count for the least loaded host.",
+			constraints: `[{"maxSkew": 1, "topologyKey": "alpha.cna.vmware.com/host", "whenUnsatisfiable": "DoNotSchedule", "labelSelector": {"matchLabels": {"app": "web"}}}]`,
+			pods: []*v1.Pod{
+				spreadPod("p1", "node1", ""),
+				spreadPod("p2", "node3", ""),
+			},
+			nodes:  []string{"node1", "node3"},
+			expect: []string{},
+		},
+		{
+			desc:        "ScheduleAnyway and other topology keys are ignored.",
+			constraints: `[{"maxSkew": 1, "topologyKey": "alpha.cna.vmware.com/host", "whenUnsatisfiable": "ScheduleAnyway", "labelSelector": {"matchLabels": {"app": "web"}}}, {"maxSkew": 1, "topologyKey": "kubernetes.io/hostname", "whenUnsatisfiable": "DoNotSchedule", "labelSelector": {"matchLabels": {"app": "web"}}}]`,
+			pods: []*v1.Pod{
+				spreadPod("p1", "node1", ""),
+			},
+			nodes:  []string{"node1", "node3"},
+			expect: []string{"node1", "node3"},
+		},
+		{
+			desc:        "node without host is filtered out.",
+			constraints: `[{"maxSkew": 1, "topologyKey": "alpha.cna.vmware.com/host", "whenUnsatisfiable": "DoNotSchedule", "labelSelector": {"matchLabels": {"app": "web"}}}]`,
+			nodes:       []string{"node1", "node5"},
+			expect:      []string{"node1"},
+		},
+		{
+			desc:        "invalid annotation.",
+			constraints: `{"maxSkew": 1}`,
+			nodes:       []string{"node1"},
+			expect:      []string{},
+			expectErr:   true,
+		},
+	}
+
+	for _, test := range tests {
+		filter := NewTopologySpread(fake.NewPodLister(test.pods), fake.NodeCache(nodeToHost))
+		result, failed, err := filter.Filter(spreadPod("pod", "", test.constraints), test.nodes)
+		if (err != nil) != test.expectErr {
+			t.Errorf("[%s] unexpected error: %v", test.desc, err)
+		}
+		if !reflect.DeepEqual(result, test.expect) {
+			t.Errorf("[%s] expect %v; got %v", test.desc, test.expect, result)
+		}
+		if !test.expectErr && len(failed)+len(result) != len(test.nodes) {
+			t.Errorf("[%s] expect a reason for each filtered out node; got %v", test.desc, failed)
+		}
+	}
+}
+
+func TestTopologySpreadExplain(t *testing.T) {
+	constraints := `[{"maxSkew": 1, "topologyKey": "alpha.cna.vmware.com/host", "whenUnsatisfiable": "DoNotSchedule", "labelSelector": {"matchLabels": {"app": "web"}}}]`
+	filter := NewTopologySpread(fake.NewPodLister([]*v1.Pod{spreadPod("p1", "node1", "")}),
+		fake.NodeCache(map[string]string{"node1": "host1", "node3": "host2"}))
+
+	explanations := algorithm.Explain(filter, spreadPod("pod", "", constraints), []string{"node1", "node3"})
+	if len(explanations) != 1 {
+		t.Fatalf("expect 1 explanation; got %v", explanations)
+	}
+
+	e := explanations[0]
+	if e.Name != "topologySpread" || !reflect.DeepEqual(e.After, []string{"node3"}) ||
+		!reflect.DeepEqual(e.MatchedPods, []string{"default/p1 on node1"}) {
+		t.Errorf("unexpected explanation %+v", e)
+	}
+	if reason := e.FailedNodes["node1"]; reason != "host host1 would run 2 matching pods, exceeding maxSkew 1 of topology spread constraint 0" {
+		t.Errorf("unexpected reason %q", reason)
+	}
+}
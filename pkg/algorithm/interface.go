@@ -19,17 +19,56 @@ package algorithm
 import (
 	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/selector"
 	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api/v1"
 )
 
+// MaxPriority is the highest score a Prioritizer gives to a node.
+const MaxPriority = 10
+
 // Filter filters nodes based on pod's spec, it returns valid nodes that the
-// pod is okay to run on.
+// pod is okay to run on, and the reasons why the other nodes are filtered out.
 type Filter interface {
-	Filter(pod *v1.Pod, nodes []string) ([]string, error)
+	Filter(pod *v1.Pod, nodes []string) ([]string, FailedNodes, error)
+}
+
+// FailedNodes maps the filtered out node names to the reasons
+type FailedNodes map[string]string
+
+// Prioritizer scores nodes based on pod's spec, higher score is better. Scores
+// range from 0 to MaxPriority.
+type Prioritizer interface {
+	Prioritize(pod *v1.Pod, nodes []string) (schedulerapi.HostPriorityList, error)
 }
 
+// Preemptor checks the victims chosen by scheduler preemption. It returns the
+// nodes, with their victims, where evicting the victims makes pod schedulable.
+type Preemptor interface {
+	Preempt(pod *v1.Pod, nodeToVictims map[string][]types.UID) (map[string][]types.UID, error)
+}
+
+// FilterFactory creates a Filter from a PodLister, a NamespaceLister, a
+// HostCache and the Topologies besides physical host
+type FilterFactory func(PodLister, selector.NamespaceLister, HostCache, Topologies) Filter
+
 // PodLister list pods
 type PodLister interface {
 	ListPod(selector.Selector) ([]*v1.Pod, error)
+
+	// ListAntiAffinityPods lists the pods assigned to a node that have
+	// required anti-affinity terms with the given topology key.
+	ListAntiAffinityPods(topologyKey string) ([]*v1.Pod, error)
+}
+
+// PodGetter gets a pod by its namespace and name
+type PodGetter interface {
+	// GetPod returns nil if the pod is not found
+	GetPod(namespace, name string) (*v1.Pod, error)
+}
+
+// ServiceLister list services
+type ServiceLister interface {
+	ListService(namespace string) ([]*v1.Service, error)
 }
 
 // HostCache keeps the node to host relationship, and supports host and node
@@ -40,20 +79,80 @@ type HostCache interface {
 
 	// GetNodes returns all the nodes running on a given host
 	GetNodes(host string) []string
+
+	// ListHosts returns all the hosts running nodes
+	ListHosts() []string
+}
+
+// HostStatus reports the state of physical hosts
+type HostStatus interface {
+	// Unavailable returns why no new pod should be placed on a given host, or
+	// an empty string if it is fine. The reason reads after "host X is", e.g.
+	// "in maintenance mode" or "poweredOff".
+	Unavailable(host string) string
+}
+
+// Topology maps nodes to the domains of a topology key, such as the
+// datastores the node VMs are stored on. Unlike physical host, a node can be
+// in several domains of a Topology.
+type Topology interface {
+	// GetDomains returns the domains of a given node
+	GetDomains(node string) []string
+
+	// GetNodes returns all the nodes in a given domain
+	GetNodes(domain string) []string
 }
 
+// Topologies maps topology keys to their Topology
+type Topologies map[string]Topology
+
 // Filters is an ordered list of Filters
 type Filters []Filter
 
 // Filter implements interface Filter
-func (filters Filters) Filter(pod *v1.Pod, nodes []string) ([]string, error) {
-	var err error
+func (filters Filters) Filter(pod *v1.Pod, nodes []string) ([]string, FailedNodes, error) {
+	failedNodes := FailedNodes{}
 	for _, filter := range filters {
-		nodes, err = filter.Filter(pod, nodes)
+		var failed FailedNodes
+		var err error
+		nodes, failed, err = filter.Filter(pod, nodes)
+		for node, reason := range failed {
+			failedNodes[node] = reason
+		}
+		if err != nil {
+			return nodes, failedNodes, err
+		}
+	}
+
+	return nodes, failedNodes, nil
+}
+
+// Prioritizers is a list of Prioritizers whose scores are averaged, rounded to
+// the nearest integer so that a point of a single prioritizer is not lost
+type Prioritizers []Prioritizer
+
+// Prioritize implements interface Prioritizer
+func (prioritizers Prioritizers) Prioritize(pod *v1.Pod, nodes []string) (schedulerapi.HostPriorityList, error) {
+	scores := make(map[string]int, len(nodes))
+	for _, prioritizer := range prioritizers {
+		list, err := prioritizer.Prioritize(pod, nodes)
 		if err != nil {
-			return nodes, err
+			return nil, err
+		}
+
+		for _, hp := range list {
+			scores[hp.Host] += hp.Score
+		}
+	}
+
+	result := make(schedulerapi.HostPriorityList, 0, len(nodes))
+	for _, node := range nodes {
+		score := 0
+		if n := len(prioritizers); n > 0 {
+			score = (2*scores[node] + n) / (2 * n)
 		}
+		result = append(result, schedulerapi.HostPriority{Host: node, Score: score})
 	}
 
-	return nodes, err
+	return result, nil
 }
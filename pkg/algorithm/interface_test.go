@@ -0,0 +1,84 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package algorithm
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api/v1"
+)
+
+// scores is a Prioritizer giving fixed scores
+type scores map[string]int
+
+func (s scores) Prioritize(pod *v1.Pod, nodes []string) (schedulerapi.HostPriorityList, error) {
+	result := schedulerapi.HostPriorityList{}
+	for _, node := range nodes {
+		result = append(result, schedulerapi.HostPriority{Host: node, Score: s[node]})
+	}
+	return result, nil
+}
+
+func TestPrioritizers(t *testing.T) {
+	tests := []struct {
+		desc         string
+		prioritizers Prioritizers
+		expect       schedulerapi.HostPriorityList
+	}{
+		{
+			desc: "no prioritizer; all nodes get 0.",
+			expect: schedulerapi.HostPriorityList{
+				{Host: "node1", Score: 0},
+				{Host: "node2", Score: 0},
+			},
+		},
+		{
+			desc: "a point of a single prioritizer keeps the ranking.",
+			prioritizers: Prioritizers{
+				scores{"node1": 10, "node2": 10},
+				scores{"node1": 9, "node2": 8},
+			},
+			expect: schedulerapi.HostPriorityList{
+				{Host: "node1", Score: 10},
+				{Host: "node2", Score: 9},
+			},
+		},
+		{
+			desc: "half points round up.",
+			prioritizers: Prioritizers{
+				scores{"node1": 5, "node2": 4},
+				scores{"node1": 0, "node2": 0},
+			},
+			expect: schedulerapi.HostPriorityList{
+				{Host: "node1", Score: 3},
+				{Host: "node2", Score: 2},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		result, err := test.prioritizers.Prioritize(&v1.Pod{}, []string{"node1", "node2"})
+		if err != nil {
+			t.Error(err)
+		}
+		if !reflect.DeepEqual(result, test.expect) {
+			t.Errorf("[%s] expect %v; got %v", test.desc, test.expect, result)
+		}
+	}
+}
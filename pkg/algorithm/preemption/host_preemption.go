@@ -0,0 +1,112 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package preemption
+
+import (
+	"log"
+
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/selector"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type hostPreemption struct {
+	podLister       algorithm.PodLister
+	namespaceLister selector.NamespaceLister
+	hostCache       algorithm.HostCache
+	topologies      algorithm.Topologies
+	factories       []algorithm.FilterFactory
+}
+
+// NewHostPreemption creates a Preemptor which drops the nodes where evicting
+// the victims still leaves the pod filtered out, e.g. because a pod on a
+// sibling VM of the same physical host keeps violating anti-affinity.
+func NewHostPreemption(podLister algorithm.PodLister, namespaceLister selector.NamespaceLister,
+	hostCache algorithm.HostCache, topologies algorithm.Topologies,
+	factories ...algorithm.FilterFactory) algorithm.Preemptor {
+	return &hostPreemption{
+		podLister:       podLister,
+		namespaceLister: namespaceLister,
+		hostCache:       hostCache,
+		topologies:      topologies,
+		factories:       factories,
+	}
+}
+
+func (p *hostPreemption) Preempt(pod *v1.Pod, nodeToVictims map[string][]types.UID) (map[string][]types.UID, error) {
+	result := make(map[string][]types.UID)
+
+	for node, victims := range nodeToVictims {
+		// Filter the node as if the victims were already gone
+		lister := &excludingLister{
+			podLister: p.podLister,
+			excluded:  make(map[types.UID]struct{}),
+		}
+		for _, uid := range victims {
+			lister.excluded[uid] = struct{}{}
+		}
+
+		var filter algorithm.Filters
+		for _, factory := range p.factories {
+			filter = append(filter, factory(lister, p.namespaceLister, p.hostCache, p.topologies))
+		}
+
+		nodes, failed, err := filter.Filter(pod, []string{node})
+		if err != nil {
+			return nil, err
+		}
+
+		if len(nodes) == 0 {
+			log.Printf("preemption: drop node %s, evicting %s does not make room: %s",
+				node, victims, failed[node])
+			continue
+		}
+
+		result[node] = victims
+	}
+
+	return result, nil
+}
+
+// excludingLister is a PodLister that hides the excluded pods
+type excludingLister struct {
+	podLister algorithm.PodLister
+	excluded  map[types.UID]struct{}
+}
+
+func (l *excludingLister) ListPod(selector selector.Selector) ([]*v1.Pod, error) {
+	return l.exclude(l.podLister.ListPod(selector))
+}
+
+func (l *excludingLister) ListAntiAffinityPods(topologyKey string) ([]*v1.Pod, error) {
+	return l.exclude(l.podLister.ListAntiAffinityPods(topologyKey))
+}
+
+func (l *excludingLister) exclude(pods []*v1.Pod, err error) ([]*v1.Pod, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*v1.Pod{}
+	for _, pod := range pods {
+		if _, ok := l.excluded[pod.UID]; !ok {
+			result = append(result, pod)
+		}
+	}
+	return result, nil
+}
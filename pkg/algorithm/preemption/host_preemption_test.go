@@ -0,0 +1,103 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package preemption
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm/fake"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm/filters"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/constants"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestHostPreemption(t *testing.T) {
+	pod := &v1.Pod{
+		Spec: v1.PodSpec{
+			Affinity: &v1.Affinity{
+				PodAntiAffinity: &v1.PodAntiAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{
+						{
+							LabelSelector: &metav1.LabelSelector{
+								MatchLabels: map[string]string{"key": "value"},
+							},
+							TopologyKey: constants.HostLabel,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	pods := []*v1.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				UID:    types.UID("uid-pod1"),
+				Labels: map[string]string{"key": "value"},
+			},
+			Spec: v1.PodSpec{
+				NodeName: "node1",
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				UID:    types.UID("uid-pod2"),
+				Labels: map[string]string{"key": "value"},
+			},
+			Spec: v1.PodSpec{
+				NodeName: "node2",
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				UID:    types.UID("uid-pod3"),
+				Labels: map[string]string{"key": "value"},
+			},
+			Spec: v1.PodSpec{
+				NodeName: "node3",
+			},
+		},
+	}
+
+	nodeToHost := map[string]string{
+		"node1": "host1",
+		"node2": "host1",
+		"node3": "host2",
+	}
+
+	preemptor := NewHostPreemption(fake.NewPodLister(pods), nil, fake.NodeCache(nodeToHost), nil,
+		filters.NewPodAffinity, filters.NewPodAntiAffinity)
+
+	result, err := preemptor.Preempt(pod, map[string][]types.UID{
+		"node1": {"uid-pod1"},
+		"node3": {"uid-pod3"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// node1 is dropped since pod2 on node2 still runs on host1
+	expect := map[string][]types.UID{
+		"node3": {"uid-pod3"},
+	}
+	if !reflect.DeepEqual(result, expect) {
+		t.Errorf("expect %v; got %v", expect, result)
+	}
+}
@@ -0,0 +1,172 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package priorities
+
+import (
+	"log"
+
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/selector"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api/v1"
+)
+
+// instanceLabels differ between the pods of a controller, like the pod name of
+// a StatefulSet, or between its revisions, the others are shared by the pods
+// created from the same template.
+var instanceLabels = []string{
+	appsv1.StatefulSetPodNameLabel,
+	appsv1.ControllerRevisionHashLabelKey,
+	"pod-template-generation",
+}
+
+type hostSpreading struct {
+	podLister     algorithm.PodLister
+	serviceLister algorithm.ServiceLister
+	hostCache     algorithm.HostCache
+}
+
+// NewHostSpreading creates a prioritizer that spreads pods of the same
+// Service, ReplicaSet or StatefulSet across physical hosts.
+func NewHostSpreading(podLister algorithm.PodLister, serviceLister algorithm.ServiceLister,
+	hostCache algorithm.HostCache) algorithm.Prioritizer {
+	return &hostSpreading{
+		podLister:     podLister,
+		serviceLister: serviceLister,
+		hostCache:     hostCache,
+	}
+}
+
+func (p *hostSpreading) Prioritize(pod *v1.Pod, nodes []string) (schedulerapi.HostPriorityList, error) {
+	log.Printf("apply hostSpreading priority node: %s", nodes)
+
+	peers, err := p.listPeers(pod)
+	if err != nil {
+		return nil, err
+	}
+
+	// Count peer pods per physical host
+	hostCount := make(map[string]int)
+	for _, peer := range peers {
+		if node := peer.Spec.NodeName; node != "" {
+			hostCount[hostOf(p.hostCache, node)]++
+		}
+	}
+
+	maxCount := 0
+	for _, node := range nodes {
+		if count := hostCount[hostOf(p.hostCache, node)]; count > maxCount {
+			maxCount = count
+		}
+	}
+
+	result := make(schedulerapi.HostPriorityList, 0, len(nodes))
+	for _, node := range nodes {
+		score := algorithm.MaxPriority
+		if maxCount > 0 {
+			score = algorithm.MaxPriority * (maxCount - hostCount[hostOf(p.hostCache, node)]) / maxCount
+		}
+		result = append(result, schedulerapi.HostPriority{Host: node, Score: score})
+	}
+
+	log.Printf("applied hostSpreading priority: %v", result)
+
+	return result, nil
+}
+
+// listPeers returns the pods in the same namespace that either share the
+// controller (ReplicaSet, StatefulSet, etc.) of pod, or are selected by a
+// Service that also selects pod.
+func (p *hostSpreading) listPeers(pod *v1.Pod) ([]*v1.Pod, error) {
+	services, err := p.serviceLister.ListService(pod.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	var selectors selector.Or
+	for _, service := range services {
+		if len(service.Spec.Selector) == 0 {
+			continue
+		}
+
+		s := labels.SelectorFromSet(service.Spec.Selector)
+		if s.Matches(labels.Set(pod.GetLabels())) {
+			selectors = append(selectors, s)
+		}
+	}
+
+	controllerRef := metav1.GetControllerOf(pod)
+	if controllerRef == nil && len(selectors) == 0 {
+		return []*v1.Pod{}, nil
+	}
+
+	// Pods sharing the controller are listed by the labels of the template,
+	// and the controller is checked below.
+	candidates := selectors
+	if controllerRef != nil {
+		candidates = append(candidates, labels.SelectorFromSet(templateLabels(pod)))
+	}
+
+	pods, err := p.podLister.ListPod(selector.And{selector.InNamespaces(pod.Namespace), candidates})
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*v1.Pod{}
+	for _, candidate := range pods {
+		if candidate.Namespace != pod.Namespace ||
+			(pod.UID != "" && candidate.UID == pod.UID) ||
+			candidate.DeletionTimestamp != nil {
+			continue
+		}
+
+		if len(selectors) > 0 && selectors.Matches(labels.Set(candidate.GetLabels())) {
+			result = append(result, candidate)
+		} else if controllerRef != nil {
+			if ref := metav1.GetControllerOf(candidate); ref != nil && ref.UID == controllerRef.UID {
+				result = append(result, candidate)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// templateLabels returns the labels of pod that the other pods of its
+// controller have too
+func templateLabels(pod *v1.Pod) labels.Set {
+	result := labels.Set{}
+	for key, value := range pod.Labels {
+		result[key] = value
+	}
+	for _, key := range instanceLabels {
+		delete(result, key)
+	}
+	return result
+}
+
+// hostOf returns the physical host of node. A node without host information
+// is treated as a host on its own.
+func hostOf(hostCache algorithm.HostCache, node string) string {
+	if host := hostCache.GetHost(node); host != "" {
+		return host
+	}
+	return "node/" + node
+}
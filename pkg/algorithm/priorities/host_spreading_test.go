@@ -0,0 +1,256 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package priorities
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm/fake"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api/v1"
+)
+
+func TestHostSpreading(t *testing.T) {
+	isController := true
+	ownerRef := []metav1.OwnerReference{
+		{
+			Kind:       "ReplicaSet",
+			Name:       "rs1",
+			UID:        types.UID("uid-rs1"),
+			Controller: &isController,
+		},
+	}
+	statefulSetRef := []metav1.OwnerReference{
+		{
+			Kind:       "StatefulSet",
+			Name:       "db",
+			UID:        types.UID("uid-db"),
+			Controller: &isController,
+		},
+	}
+
+	tests := []struct {
+		desc       string
+		pod        *v1.Pod
+		pods       []*v1.Pod
+		services   []*v1.Service
+		nodeToHost map[string]string
+		nodes      []string
+		expect     schedulerapi.HostPriorityList
+	}{
+		{
+			desc: "no service or controller; all nodes get max score.",
+			pod: &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "default",
+					Labels:    map[string]string{"app": "web"},
+				},
+			},
+			pods: []*v1.Pod{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Namespace: "default",
+						Labels:    map[string]string{"app": "web"},
+					},
+					Spec: v1.PodSpec{
+						NodeName: "node1",
+					},
+				},
+			},
+			nodeToHost: map[string]string{
+				"node1": "host1",
+				"node2": "host2",
+			},
+			nodes: []string{"node1", "node2"},
+			expect: schedulerapi.HostPriorityList{
+				{Host: "node1", Score: 10},
+				{Host: "node2", Score: 10},
+			},
+		},
+		{
+			desc: "service; replica on another node of the same host.",
+			pod: &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "default",
+					Labels:    map[string]string{"app": "web"},
+				},
+			},
+			pods: []*v1.Pod{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Namespace: "default",
+						Labels:    map[string]string{"app": "web"},
+					},
+					Spec: v1.PodSpec{
+						NodeName: "node1",
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Namespace: "other",
+						Labels:    map[string]string{"app": "web"},
+					},
+					Spec: v1.PodSpec{
+						NodeName: "node3",
+					},
+				},
+			},
+			services: []*v1.Service{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Namespace: "default",
+					},
+					Spec: v1.ServiceSpec{
+						Selector: map[string]string{"app": "web"},
+					},
+				},
+			},
+			nodeToHost: map[string]string{
+				"node1": "host1",
+				"node2": "host1",
+				"node3": "host2",
+			},
+			nodes: []string{"node1", "node2", "node3"},
+			expect: schedulerapi.HostPriorityList{
+				{Host: "node1", Score: 0},
+				{Host: "node2", Score: 0},
+				{Host: "node3", Score: 10},
+			},
+		},
+		{
+			desc: "replicaset; replicas on different hosts.",
+			pod: &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:       "default",
+					OwnerReferences: ownerRef,
+				},
+			},
+			pods: []*v1.Pod{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Namespace:       "default",
+						OwnerReferences: ownerRef,
+					},
+					Spec: v1.PodSpec{
+						NodeName: "node1",
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Namespace:       "default",
+						OwnerReferences: ownerRef,
+					},
+					Spec: v1.PodSpec{
+						NodeName: "node2",
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Namespace:       "default",
+						OwnerReferences: ownerRef,
+					},
+					Spec: v1.PodSpec{
+						NodeName: "node3",
+					},
+				},
+			},
+			nodeToHost: map[string]string{
+				"node1": "host1",
+				"node2": "host1",
+				"node3": "host2",
+				"node4": "host3",
+			},
+			nodes: []string{"node1", "node3", "node4"},
+			expect: schedulerapi.HostPriorityList{
+				{Host: "node1", Score: 0},
+				{Host: "node3", Score: 5},
+				{Host: "node4", Score: 10},
+			},
+		},
+		{
+			desc: "statefulset; peers found by the labels of the template.",
+			pod: &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:       "default",
+					Labels:          map[string]string{"app": "db", "statefulset.kubernetes.io/pod-name": "db-2"},
+					OwnerReferences: statefulSetRef,
+				},
+			},
+			pods: []*v1.Pod{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Namespace:       "default",
+						Labels:          map[string]string{"app": "db", "statefulset.kubernetes.io/pod-name": "db-0"},
+						OwnerReferences: statefulSetRef,
+					},
+					Spec: v1.PodSpec{
+						NodeName: "node1",
+					},
+				},
+				{
+					// another controller
+					ObjectMeta: metav1.ObjectMeta{
+						Namespace:       "default",
+						Labels:          map[string]string{"app": "db"},
+						OwnerReferences: ownerRef,
+					},
+					Spec: v1.PodSpec{
+						NodeName: "node3",
+					},
+				},
+				{
+					// another namespace
+					ObjectMeta: metav1.ObjectMeta{
+						Namespace:       "other",
+						Labels:          map[string]string{"app": "db", "statefulset.kubernetes.io/pod-name": "db-1"},
+						OwnerReferences: statefulSetRef,
+					},
+					Spec: v1.PodSpec{
+						NodeName: "node3",
+					},
+				},
+			},
+			nodeToHost: map[string]string{
+				"node1": "host1",
+				"node3": "host2",
+			},
+			nodes: []string{"node1", "node3"},
+			expect: schedulerapi.HostPriorityList{
+				{Host: "node1", Score: 0},
+				{Host: "node3", Score: 10},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		prioritizer := &hostSpreading{
+			podLister:     fake.NewPodLister(test.pods),
+			serviceLister: fake.NewServiceLister(test.services),
+			hostCache:     fake.NodeCache(test.nodeToHost),
+		}
+		result, err := prioritizer.Prioritize(test.pod, test.nodes)
+		if err != nil {
+			t.Error(err)
+		}
+		if !reflect.DeepEqual(result, test.expect) {
+			t.Errorf("[%s] expect %v; got %v", test.desc, test.expect, result)
+		}
+	}
+}
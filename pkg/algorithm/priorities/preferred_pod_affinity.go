@@ -0,0 +1,123 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package priorities
+
+import (
+	"log"
+
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/constants"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/selector"
+	"k8s.io/api/core/v1"
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api/v1"
+)
+
+type preferredPodAffinity struct {
+	podLister       algorithm.PodLister
+	namespaceLister selector.NamespaceLister
+	hostCache       algorithm.HostCache
+}
+
+// NewPreferredPodAffinity creates a prioritizer that honors the weighted
+// preferred pod affinity and anti-affinity terms on physical host scope.
+func NewPreferredPodAffinity(podLister algorithm.PodLister, namespaceLister selector.NamespaceLister,
+	hostCache algorithm.HostCache) algorithm.Prioritizer {
+	return &preferredPodAffinity{
+		podLister:       podLister,
+		namespaceLister: namespaceLister,
+		hostCache:       hostCache,
+	}
+}
+
+func (p *preferredPodAffinity) Prioritize(pod *v1.Pod, nodes []string) (schedulerapi.HostPriorityList, error) {
+	log.Printf("apply preferredPodAffinity priority node: %s", nodes)
+
+	hostScore := make(map[string]int)
+	if affinity := pod.Spec.Affinity; affinity != nil {
+		if affinity.PodAffinity != nil {
+			terms := affinity.PodAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+			if err := p.addScores(pod, selector.PreferredAffinityTerm, terms, 1, hostScore); err != nil {
+				return nil, err
+			}
+		}
+		if affinity.PodAntiAffinity != nil {
+			terms := affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+			if err := p.addScores(pod, selector.PreferredAntiAffinityTerm, terms, -1, hostScore); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Normalize the scores into [0, MaxPriority]
+	var minScore, maxScore int
+	for i, node := range nodes {
+		score := hostScore[hostOf(p.hostCache, node)]
+		if i == 0 || score < minScore {
+			minScore = score
+		}
+		if i == 0 || score > maxScore {
+			maxScore = score
+		}
+	}
+
+	result := make(schedulerapi.HostPriorityList, 0, len(nodes))
+	for _, node := range nodes {
+		score := algorithm.MaxPriority
+		if maxScore > minScore {
+			score = algorithm.MaxPriority * (hostScore[hostOf(p.hostCache, node)] - minScore) / (maxScore - minScore)
+		}
+		result = append(result, schedulerapi.HostPriority{Host: node, Score: score})
+	}
+
+	log.Printf("applied preferredPodAffinity priority: %v", result)
+
+	return result, nil
+}
+
+// addScores adds sign*weight of each term of kind to the hosts running a pod
+// that matches the term.
+func (p *preferredPodAffinity) addScores(pod *v1.Pod, kind selector.TermKind, terms []v1.WeightedPodAffinityTerm, sign int,
+	hostScore map[string]int) error {
+	for i, term := range terms {
+		if term.PodAffinityTerm.TopologyKey != constants.HostLabel {
+			continue
+		}
+
+		s, err := selector.ForTerm(pod, kind, i, &terms[i].PodAffinityTerm, p.namespaceLister)
+		if err != nil {
+			return err
+		}
+
+		pods, err := p.podLister.ListPod(s)
+		if err != nil {
+			return err
+		}
+
+		hosts := make(map[string]struct{})
+		for _, matched := range pods {
+			if node := matched.Spec.NodeName; node != "" {
+				hosts[hostOf(p.hostCache, node)] = struct{}{}
+			}
+		}
+
+		for host := range hosts {
+			hostScore[host] += sign * int(term.Weight)
+		}
+	}
+
+	return nil
+}
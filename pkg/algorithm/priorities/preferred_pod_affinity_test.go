@@ -0,0 +1,157 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package priorities
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm/fake"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/constants"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api/v1"
+)
+
+func TestPreferredPodAffinity(t *testing.T) {
+	pods := []*v1.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Labels: map[string]string{"key": "value"},
+			},
+			Spec: v1.PodSpec{
+				NodeName: "node1",
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Labels: map[string]string{"key": "value2"},
+			},
+			Spec: v1.PodSpec{
+				NodeName: "node3",
+			},
+		},
+	}
+	nodeToHost := map[string]string{
+		"node1": "host1",
+		"node2": "host1",
+		"node3": "host2",
+		"node4": "host3",
+	}
+
+	tests := []struct {
+		desc     string
+		affinity *v1.Affinity
+		expect   schedulerapi.HostPriorityList
+	}{
+		{
+			desc:     "no affinity; all nodes get max score.",
+			affinity: nil,
+			expect: schedulerapi.HostPriorityList{
+				{Host: "node1", Score: 10},
+				{Host: "node2", Score: 10},
+				{Host: "node3", Score: 10},
+				{Host: "node4", Score: 10},
+			},
+		},
+		{
+			desc: "preferred pod affinity; nodes on same host get max score.",
+			affinity: &v1.Affinity{
+				PodAffinity: &v1.PodAffinity{
+					PreferredDuringSchedulingIgnoredDuringExecution: []v1.WeightedPodAffinityTerm{
+						{
+							Weight: 50,
+							PodAffinityTerm: v1.PodAffinityTerm{
+								LabelSelector: &metav1.LabelSelector{
+									MatchLabels: map[string]string{"key": "value"},
+								},
+								TopologyKey: constants.HostLabel,
+							},
+						},
+					},
+				},
+			},
+			expect: schedulerapi.HostPriorityList{
+				{Host: "node1", Score: 10},
+				{Host: "node2", Score: 10},
+				{Host: "node3", Score: 0},
+				{Host: "node4", Score: 0},
+			},
+		},
+		{
+			desc: "preferred pod anti-affinity; weighted terms.",
+			affinity: &v1.Affinity{
+				PodAntiAffinity: &v1.PodAntiAffinity{
+					PreferredDuringSchedulingIgnoredDuringExecution: []v1.WeightedPodAffinityTerm{
+						{
+							Weight: 100,
+							PodAffinityTerm: v1.PodAffinityTerm{
+								LabelSelector: &metav1.LabelSelector{
+									MatchLabels: map[string]string{"key": "value"},
+								},
+								TopologyKey: constants.HostLabel,
+							},
+						},
+						{
+							Weight: 50,
+							PodAffinityTerm: v1.PodAffinityTerm{
+								LabelSelector: &metav1.LabelSelector{
+									MatchLabels: map[string]string{"key": "value2"},
+								},
+								TopologyKey: constants.HostLabel,
+							},
+						},
+						{
+							Weight: 100,
+							PodAffinityTerm: v1.PodAffinityTerm{
+								LabelSelector: &metav1.LabelSelector{
+									MatchLabels: map[string]string{"key": "value2"},
+								},
+								TopologyKey: "kubernetes.io/hostname",
+							},
+						},
+					},
+				},
+			},
+			expect: schedulerapi.HostPriorityList{
+				{Host: "node1", Score: 0},
+				{Host: "node2", Score: 0},
+				{Host: "node3", Score: 5},
+				{Host: "node4", Score: 10},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		prioritizer := &preferredPodAffinity{
+			podLister: fake.NewPodLister(pods),
+			hostCache: fake.NodeCache(nodeToHost),
+		}
+		pod := &v1.Pod{
+			Spec: v1.PodSpec{
+				Affinity: test.affinity,
+			},
+		}
+		result, err := prioritizer.Prioritize(pod, []string{"node1", "node2", "node3", "node4"})
+		if err != nil {
+			t.Error(err)
+		}
+		if !reflect.DeepEqual(result, test.expect) {
+			t.Errorf("[%s] expect %v; got %v", test.desc, test.expect, result)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package priorities
+
+import (
+	"log"
+
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm"
+	"k8s.io/api/core/v1"
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api/v1"
+)
+
+type topologySpread struct {
+	podLister algorithm.PodLister
+	hostCache algorithm.HostCache
+}
+
+// NewTopologySpread creates a prioritizer for the ScheduleAnyway topology
+// spread constraints on physical host scope. Nodes on hosts running fewer
+// matching pods score higher, nodes without host information score lowest.
+func NewTopologySpread(podLister algorithm.PodLister, hostCache algorithm.HostCache) algorithm.Prioritizer {
+	return &topologySpread{
+		podLister: podLister,
+		hostCache: hostCache,
+	}
+}
+
+func (t *topologySpread) Prioritize(pod *v1.Pod, nodes []string) (schedulerapi.HostPriorityList, error) {
+	log.Printf("apply topologySpread priority node: %s", nodes)
+
+	constraints, err := algorithm.HostSpreadConstraints(pod, algorithm.ScheduleAnyway)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sum the matching pods of all constraints per physical host
+	hostCount := make(map[string]int)
+	for i := range constraints {
+		counts, _, err := algorithm.CountPodsPerHost(t.podLister, t.hostCache, pod, &constraints[i])
+		if err != nil {
+			return nil, err
+		}
+		for host, count := range counts {
+			hostCount[host] += count
+		}
+	}
+
+	maxCount := 0
+	for _, node := range nodes {
+		if count := hostCount[t.hostCache.GetHost(node)]; count > maxCount {
+			maxCount = count
+		}
+	}
+
+	result := make(schedulerapi.HostPriorityList, 0, len(nodes))
+	for _, node := range nodes {
+		host := t.hostCache.GetHost(node)
+
+		score := algorithm.MaxPriority
+		if len(constraints) > 0 && host == "" {
+			score = 0
+		} else if maxCount > 0 {
+			score = algorithm.MaxPriority * (maxCount - hostCount[host]) / maxCount
+		}
+		result = append(result, schedulerapi.HostPriority{Host: node, Score: score})
+	}
+
+	log.Printf("applied topologySpread priority: %v", result)
+
+	return result, nil
+}
@@ -0,0 +1,120 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package priorities
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm/fake"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/constants"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api/v1"
+)
+
+func TestTopologySpread(t *testing.T) {
+	newPod := func(node string, labels map[string]string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Labels:    labels,
+			},
+			Spec: v1.PodSpec{
+				NodeName: node,
+			},
+		}
+	}
+	web := map[string]string{"app": "web"}
+	db := map[string]string{"app": "db"}
+
+	nodeToHost := map[string]string{
+		"node1": "host1",
+		"node2": "host1",
+		"node3": "host2",
+		"node4": "host3",
+	}
+
+	tests := []struct {
+		desc        string
+		constraints string
+		pods        []*v1.Pod
+		nodes       []string
+		expect      schedulerapi.HostPriorityList
+	}{
+		{
+			desc:  "no constraints; all nodes get max score.",
+			pods:  []*v1.Pod{newPod("node1", web)},
+			nodes: []string{"node1", "node3"},
+			expect: schedulerapi.HostPriorityList{
+				{Host: "node1", Score: 10},
+				{Host: "node3", Score: 10},
+			},
+		},
+		{
+			desc:        "hosts running fewer matching pods score higher.",
+			constraints: `[{"maxSkew": 1, "topologyKey": "alpha.cna.vmware.com/host", "whenUnsatisfiable": "ScheduleAnyway", "labelSelector": {"matchLabels": {"app": "web"}}}]`,
+			pods: []*v1.Pod{
+				newPod("node1", web),
+				newPod("node2", web),
+				newPod("node3", web),
+				newPod("node4", db),
+			},
+			nodes: []string{"node1", "node3", "node4"},
+			expect: schedulerapi.HostPriorityList{
+				{Host: "node1", Score: 0},
+				{Host: "node3", Score: 5},
+				{Host: "node4", Score: 10},
+			},
+		},
+		{
+			desc:        "DoNotSchedule constraints are left to the filter.",
+			constraints: `[{"maxSkew": 1, "topologyKey": "alpha.cna.vmware.com/host", "whenUnsatisfiable": "DoNotSchedule", "labelSelector": {"matchLabels": {"app": "web"}}}]`,
+			pods:        []*v1.Pod{newPod("node1", web)},
+			nodes:       []string{"node1", "node3"},
+			expect: schedulerapi.HostPriorityList{
+				{Host: "node1", Score: 10},
+				{Host: "node3", Score: 10},
+			},
+		},
+		{
+			desc:        "node without host gets the lowest score.",
+			constraints: `[{"maxSkew": 1, "topologyKey": "alpha.cna.vmware.com/host", "whenUnsatisfiable": "ScheduleAnyway", "labelSelector": {"matchLabels": {"app": "web"}}}]`,
+			nodes:       []string{"node1", "node5"},
+			expect: schedulerapi.HostPriorityList{
+				{Host: "node1", Score: 10},
+				{Host: "node5", Score: 0},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		pod := newPod("", web)
+		if test.constraints != "" {
+			pod.Annotations = map[string]string{constants.TopologySpreadAnnotation: test.constraints}
+		}
+
+		prioritizer := NewTopologySpread(fake.NewPodLister(test.pods), fake.NodeCache(nodeToHost))
+		result, err := prioritizer.Prioritize(pod, test.nodes)
+		if err != nil {
+			t.Error(err)
+		}
+		if !reflect.DeepEqual(result, test.expect) {
+			t.Errorf("[%s] expect %v; got %v", test.desc, test.expect, result)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package algorithm
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/constants"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/selector"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// UnsatisfiableConstraintAction is what to do with a pod when a topology
+// spread constraint cannot be satisfied.
+type UnsatisfiableConstraintAction string
+
+const (
+	// DoNotSchedule filters out the nodes violating the constraint
+	DoNotSchedule UnsatisfiableConstraintAction = "DoNotSchedule"
+
+	// ScheduleAnyway prefers the nodes reducing the skew
+	ScheduleAnyway UnsatisfiableConstraintAction = "ScheduleAnyway"
+)
+
+// TopologySpreadConstraint mirrors the Kubernetes type of the same name. It
+// limits how unevenly the pods matching LabelSelector, in the namespace of the
+// pod, are spread across the domains of TopologyKey.
+type TopologySpreadConstraint struct {
+	MaxSkew           int32                         `json:"maxSkew"`
+	TopologyKey       string                        `json:"topologyKey"`
+	WhenUnsatisfiable UnsatisfiableConstraintAction `json:"whenUnsatisfiable"`
+	LabelSelector     *metav1.LabelSelector         `json:"labelSelector,omitempty"`
+}
+
+// PodSelector returns the Selector of the pods spread by c, which are in the
+// namespace of pod.
+func (c *TopologySpreadConstraint) PodSelector(pod *v1.Pod) (selector.Selector, error) {
+	s, err := metav1.LabelSelectorAsSelector(c.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	return selector.And{selector.InNamespaces(pod.Namespace), s}, nil
+}
+
+// HostSpreadConstraints returns the topology spread constraints of pod on
+// physical host scope with the given action.
+func HostSpreadConstraints(pod *v1.Pod, action UnsatisfiableConstraintAction) ([]TopologySpreadConstraint, error) {
+	value, ok := pod.Annotations[constants.TopologySpreadAnnotation]
+	if !ok {
+		return nil, nil
+	}
+
+	var constraints []TopologySpreadConstraint
+	if err := json.Unmarshal([]byte(value), &constraints); err != nil {
+		return nil, fmt.Errorf("invalid annotation %s of pod %s/%s: %v",
+			constants.TopologySpreadAnnotation, pod.Namespace, pod.Name, err)
+	}
+
+	result := []TopologySpreadConstraint{}
+	for _, c := range constraints {
+		if c.TopologyKey != constants.HostLabel || c.WhenUnsatisfiable != action {
+			continue
+		}
+		if c.MaxSkew < 1 {
+			return nil, fmt.Errorf("invalid maxSkew %d of pod %s/%s, must be greater than zero",
+				c.MaxSkew, pod.Namespace, pod.Name)
+		}
+		result = append(result, c)
+	}
+
+	return result, nil
+}
+
+// CountPodsPerHost counts the pods matching constraint c of pod per physical
+// host. Pods on nodes without host information are not counted. It also
+// returns whether pod itself matches c, i.e. adds to the count of its host.
+func CountPodsPerHost(podLister PodLister, hostCache HostCache, pod *v1.Pod,
+	c *TopologySpreadConstraint) (map[string]int, bool, error) {
+	podSelector, err := c.PodSelector(pod)
+	if err != nil {
+		return nil, false, err
+	}
+
+	pods, err := podLister.ListPod(podSelector)
+	if err != nil {
+		return nil, false, err
+	}
+
+	counts := make(map[string]int)
+	for _, p := range pods {
+		if p.Spec.NodeName == "" || p.DeletionTimestamp != nil ||
+			(pod.UID != "" && p.UID == pod.UID) {
+			continue
+		}
+		if host := hostCache.GetHost(p.Spec.NodeName); host != "" {
+			counts[host]++
+		}
+	}
+
+	return counts, podSelector.Matches(selector.PodLabels(pod)), nil
+}
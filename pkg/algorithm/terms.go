@@ -0,0 +1,50 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package algorithm
+
+import (
+	"k8s.io/api/core/v1"
+)
+
+// AntiAffinityTopologyKeys returns the topology keys of the required
+// anti-affinity terms of pod.
+func AntiAffinityTopologyKeys(pod *v1.Pod) []string {
+	keys := []string{}
+	for _, term := range AntiAffinityTerms(pod) {
+		found := false
+		for _, key := range keys {
+			if key == term.TopologyKey {
+				found = true
+				break
+			}
+		}
+		if !found {
+			keys = append(keys, term.TopologyKey)
+		}
+	}
+
+	return keys
+}
+
+// AntiAffinityTerms returns the required anti-affinity terms of pod
+func AntiAffinityTerms(pod *v1.Pod) []v1.PodAffinityTerm {
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.PodAntiAffinity == nil {
+		return nil
+	}
+
+	return pod.Spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+}
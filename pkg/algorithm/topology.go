@@ -0,0 +1,34 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package algorithm
+
+// HostTopology returns the Topology of physical hosts kept in hostCache
+func HostTopology(hostCache HostCache) Topology {
+	return hostTopology{hostCache}
+}
+
+type hostTopology struct {
+	HostCache
+}
+
+// GetDomains returns the host of a given node, if it is known
+func (t hostTopology) GetDomains(node string) []string {
+	if host := t.GetHost(node); host != "" {
+		return []string{host}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bridgecache
+
+import (
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/vsphere"
+)
+
+// datastoreTopology is the Topology of the datastores Kubernetes nodes are
+// stored on, a node is in the domain of every datastore its VM uses.
+type datastoreTopology struct {
+	cache   Cache
+	querier vsphere.Querier
+}
+
+// NewDatastoreTopology creates the Topology of the datastores of node VMs
+func NewDatastoreTopology(cache Cache, querier vsphere.Querier) algorithm.Topology {
+	return &datastoreTopology{
+		cache:   cache,
+		querier: querier,
+	}
+}
+
+// GetDomains returns the datastores the VM of a given node is stored on
+func (t *datastoreTopology) GetDomains(node string) []string {
+	vmid := t.cache.GetVMIDFromNode(node)
+	if vmid == "" {
+		return nil
+	}
+
+	return t.querier.GetDatastoresFromVMID(vmid)
+}
+
+// GetNodes returns all the nodes whose VMs are stored on a given datastore
+func (t *datastoreTopology) GetNodes(datastore string) []string {
+	result := []string{}
+	for _, vmid := range t.querier.GetVMIDsFromDatastore(datastore) {
+		if node := t.cache.GetNodeFromVMID(vmid); node != "" {
+			result = append(result, node)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,77 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bridgecache
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/bridgecache/test"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/vsphere"
+)
+
+// fakeDatastoreQuerier maps vmids to the datastores they are stored on
+type fakeDatastoreQuerier struct {
+	vsphere.Querier
+	datastores map[string][]string
+}
+
+func (q *fakeDatastoreQuerier) GetDatastoresFromVMID(vmid string) []string {
+	return q.datastores[vmid]
+}
+
+func (q *fakeDatastoreQuerier) GetVMIDsFromDatastore(datastore string) []string {
+	result := []string{}
+	for vmid, datastores := range q.datastores {
+		for _, ds := range datastores {
+			if ds == datastore {
+				result = append(result, vmid)
+			}
+		}
+	}
+	return result
+}
+
+func TestDatastoreTopology(t *testing.T) {
+	topology := NewDatastoreTopology(
+		test.FakeCache{
+			"node1": "vm-1",
+			"node2": "vm-2",
+		},
+		&fakeDatastoreQuerier{
+			datastores: map[string][]string{
+				"vm-1": {"ds-1", "ds-2"},
+				"vm-2": {"ds-2"},
+				"vm-3": {"ds-2"}, // not a Kubernetes node
+			},
+		},
+	)
+
+	if domains := topology.GetDomains("node1"); !reflect.DeepEqual(domains, []string{"ds-1", "ds-2"}) {
+		t.Errorf("expect domains of node1 [ds-1 ds-2]; got %v", domains)
+	}
+	if domains := topology.GetDomains("node3"); len(domains) != 0 {
+		t.Errorf("expect no domains of unknown node3; got %v", domains)
+	}
+
+	nodes := topology.GetNodes("ds-2")
+	sort.Strings(nodes)
+	if !reflect.DeepEqual(nodes, []string{"node1", "node2"}) {
+		t.Errorf("expect nodes on ds-2 [node1 node2]; got %v", nodes)
+	}
+}
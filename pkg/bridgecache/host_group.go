@@ -0,0 +1,121 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bridgecache
+
+import (
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/k8s/cache"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/vsphere"
+)
+
+// hostGroupIndexTTL bounds how long the nodes of the host groups are cached,
+// as VMs move between hosts without the host groups changing.
+const hostGroupIndexTTL = 10 * time.Second
+
+// hostGroupTopology is the Topology of the DRS host groups, a node is in the
+// domain of every host group the host of its VM belongs to.
+type hostGroupTopology struct {
+	cache      Cache
+	vsclient   vsphere.Vsphere
+	nodeLister k8scache.NodeLister
+
+	// nodes indexes the nodes by host group. It is rebuilt when the host
+	// groups change, or once it is older than hostGroupIndexTTL.
+	lock       sync.Mutex
+	hostGroups map[string][]string
+	nodes      map[string][]string
+	built      time.Time
+}
+
+// NewHostGroupTopology creates the Topology of the DRS host groups of the
+// hosts running node VMs
+func NewHostGroupTopology(cache Cache, vsclient vsphere.Vsphere, nodeLister k8scache.NodeLister) algorithm.Topology {
+	return &hostGroupTopology{
+		cache:      cache,
+		vsclient:   vsclient,
+		nodeLister: nodeLister,
+	}
+}
+
+// GetDomains returns the host groups of the host running a given node
+func (t *hostGroupTopology) GetDomains(node string) []string {
+	return t.domains(t.vsclient.HostGroups(), node)
+}
+
+func (t *hostGroupTopology) domains(hostGroups map[string][]string, node string) []string {
+	vmid := t.cache.GetVMIDFromNode(node)
+	if vmid == "" {
+		return nil
+	}
+
+	host, err := t.vsclient.GetHostFromVMID(vmid)
+	if err != nil || host == "" {
+		return nil
+	}
+
+	return HostGroupsOf(hostGroups, host)
+}
+
+// GetNodes returns all the nodes running on the hosts of a given host group
+func (t *hostGroupTopology) GetNodes(hostGroup string) []string {
+	hostGroups := t.vsclient.HostGroups()
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.nodes == nil || time.Since(t.built) > hostGroupIndexTTL ||
+		!reflect.DeepEqual(hostGroups, t.hostGroups) {
+		nodes, err := t.nodeLister.ListNode()
+		if err != nil {
+			log.Printf("[ERROR] failed to list nodes: %s", err)
+			return []string{}
+		}
+
+		t.nodes = make(map[string][]string)
+		for _, node := range nodes {
+			for _, group := range t.domains(hostGroups, node.Name) {
+				t.nodes[group] = append(t.nodes[group], node.Name)
+			}
+		}
+		t.hostGroups = hostGroups
+		t.built = time.Now()
+	}
+
+	return append([]string{}, t.nodes[hostGroup]...)
+}
+
+// HostGroupsOf returns the names of the host groups that host belongs to
+func HostGroupsOf(hostGroups map[string][]string, host string) []string {
+	result := []string{}
+	for group, hosts := range hostGroups {
+		for _, h := range hosts {
+			if h == host {
+				result = append(result, group)
+				break
+			}
+		}
+	}
+
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,138 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bridgecache
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/bridgecache/test"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/vsphere"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeHostGroupClient places vmids on hosts, and hosts into host groups
+type fakeHostGroupClient struct {
+	vsphere.Vsphere
+	hosts      map[string]string
+	hostGroups map[string][]string
+}
+
+func (c *fakeHostGroupClient) GetHostFromVMID(vmid string) (string, error) {
+	return c.hosts[vmid], nil
+}
+
+func (c *fakeHostGroupClient) HostGroups() map[string][]string {
+	return c.hostGroups
+}
+
+// fakeNodeLister lists nodes by name
+type fakeNodeLister []string
+
+func (l fakeNodeLister) ListNode() ([]*v1.Node, error) {
+	result := []*v1.Node{}
+	for _, name := range l {
+		result = append(result, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}})
+	}
+	return result, nil
+}
+
+func TestHostGroupTopology(t *testing.T) {
+	topology := NewHostGroupTopology(
+		test.FakeCache{
+			"node1": "vm-1",
+			"node2": "vm-2",
+			"node3": "vm-3",
+		},
+		&fakeHostGroupClient{
+			hosts: map[string]string{
+				"vm-1": "esx-01",
+				"vm-2": "esx-02",
+				"vm-3": "esx-03",
+			},
+			hostGroups: map[string][]string{
+				"rack-a":  {"esx-01", "esx-02"},
+				"rack-b":  {"esx-03"},
+				"power-1": {"esx-01", "esx-03"},
+			},
+		},
+		fakeNodeLister{"node1", "node2", "node3"},
+	)
+
+	if domains := topology.GetDomains("node1"); !reflect.DeepEqual(domains, []string{"power-1", "rack-a"}) {
+		t.Errorf("expect domains of node1 [power-1 rack-a]; got %v", domains)
+	}
+
+	nodes := topology.GetNodes("rack-a")
+	sort.Strings(nodes)
+	if !reflect.DeepEqual(nodes, []string{"node1", "node2"}) {
+		t.Errorf("expect nodes in rack-a [node1 node2]; got %v", nodes)
+	}
+}
+
+// countingNodeLister counts the calls to ListNode
+type countingNodeLister struct {
+	fakeNodeLister
+	calls int
+}
+
+func (l *countingNodeLister) ListNode() ([]*v1.Node, error) {
+	l.calls++
+	return l.fakeNodeLister.ListNode()
+}
+
+func TestHostGroupTopologyIndex(t *testing.T) {
+	client := &fakeHostGroupClient{
+		hosts: map[string]string{
+			"vm-1": "esx-01",
+			"vm-2": "esx-02",
+		},
+		hostGroups: map[string][]string{
+			"rack-a": {"esx-01"},
+			"rack-b": {"esx-02"},
+		},
+	}
+	lister := &countingNodeLister{fakeNodeLister: fakeNodeLister{"node1", "node2"}}
+	topology := NewHostGroupTopology(test.FakeCache{"node1": "vm-1", "node2": "vm-2"}, client, lister)
+
+	for i := 0; i < 3; i++ {
+		if nodes := topology.GetNodes("rack-a"); !reflect.DeepEqual(nodes, []string{"node1"}) {
+			t.Errorf("expect nodes in rack-a [node1]; got %v", nodes)
+		}
+		topology.GetNodes("rack-b")
+	}
+	if lister.calls != 1 {
+		t.Errorf("expect nodes listed once while the host groups don't change; got %d", lister.calls)
+	}
+
+	client.hostGroups = map[string][]string{
+		"rack-a": {"esx-01", "esx-02"},
+	}
+	nodes := topology.GetNodes("rack-a")
+	sort.Strings(nodes)
+	if !reflect.DeepEqual(nodes, []string{"node1", "node2"}) {
+		t.Errorf("expect nodes in rack-a [node1 node2] after the host groups change; got %v", nodes)
+	}
+	if nodes := topology.GetNodes("rack-b"); len(nodes) != 0 {
+		t.Errorf("expect no nodes in removed rack-b; got %v", nodes)
+	}
+	if lister.calls != 2 {
+		t.Errorf("expect nodes listed again once the host groups change; got %d", lister.calls)
+	}
+}
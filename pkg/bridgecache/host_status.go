@@ -0,0 +1,43 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bridgecache
+
+import (
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/vsphere"
+)
+
+// hostStatus reports the runtime state of the ESX servers from vSphere
+type hostStatus struct {
+	querier vsphere.Querier
+}
+
+// NewHostStatus creates the HostStatus of the ESX servers
+func NewHostStatus(querier vsphere.Querier) algorithm.HostStatus {
+	return &hostStatus{querier: querier}
+}
+
+// Unavailable returns why no new pod should be placed on a given host. Hosts
+// unknown to vSphere are considered fine.
+func (s *hostStatus) Unavailable(host string) string {
+	state, ok := s.querier.GetHostState(host)
+	if !ok {
+		return ""
+	}
+
+	return state.Unavailable()
+}
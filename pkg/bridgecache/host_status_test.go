@@ -0,0 +1,54 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bridgecache
+
+import (
+	"testing"
+
+	"github.com/vmware/govmomi/vim25/types"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/vsphere"
+)
+
+// fakeHostStateQuerier maps host names to their state
+type fakeHostStateQuerier struct {
+	vsphere.Querier
+	states map[string]vsphere.HostState
+}
+
+func (q *fakeHostStateQuerier) GetHostState(host string) (vsphere.HostState, bool) {
+	state, ok := q.states[host]
+	return state, ok
+}
+
+func TestHostStatus(t *testing.T) {
+	status := NewHostStatus(&fakeHostStateQuerier{
+		states: map[string]vsphere.HostState{
+			"host1": {ConnectionState: types.HostSystemConnectionStateConnected},
+			"host2": {ConnectionState: types.HostSystemConnectionStateConnected, InMaintenanceMode: true},
+		},
+	})
+
+	for host, expect := range map[string]string{
+		"host1": "",
+		"host2": "in maintenance mode",
+		"host3": "",
+	} {
+		if reason := status.Unavailable(host); reason != expect {
+			t.Errorf("expect %s to be %q; got %q", host, expect, reason)
+		}
+	}
+}
@@ -24,18 +24,28 @@ import (
 	"k8s.io/client-go/tools/cache"
 )
 
-// kubeNodeCache keeps the mapping between node name and hostname of the node
+// kubeNodeCache indexes the nodes by their keys of every node matcher
 type kubeNodeCache struct {
-	hostnameToKubeNode map[string]string
-	kubeNodeToHostname map[string]string
+	matchers []Matcher
+
+	// nodeKeys are the keys of the nodes by name, one list per matcher
+	nodeKeys map[string][][]string
+
+	// nodes are the names of the nodes by key, one map per matcher
+	nodes []map[string]map[string]struct{}
+
 	sync.Mutex
 }
 
 // newKubeNodeCache creates a kubeNodeCache instance
-func newKubeNodeCache(nodeInformer cache.SharedIndexInformer) *kubeNodeCache {
+func newKubeNodeCache(nodeInformer cache.SharedIndexInformer, matchers []Matcher) *kubeNodeCache {
 	c := &kubeNodeCache{
-		hostnameToKubeNode: make(map[string]string),
-		kubeNodeToHostname: make(map[string]string),
+		matchers: matchers,
+		nodeKeys: make(map[string][][]string),
+		nodes:    make([]map[string]map[string]struct{}, len(matchers)),
+	}
+	for i := range c.nodes {
+		c.nodes[i] = make(map[string]map[string]struct{})
 	}
 
 	nodeInformer.AddEventHandler(c)
@@ -43,22 +53,27 @@ func newKubeNodeCache(nodeInformer cache.SharedIndexInformer) *kubeNodeCache {
 	return c
 }
 
-// GetHostnameFromNodeName returns hostname that matches the node name
-// in cache.
-func (c *kubeNodeCache) GetHostnameFromNodeName(nodename string) string {
+// getKeys returns the keys of a node for the i-th matcher
+func (c *kubeNodeCache) getKeys(i int, nodename string) []string {
 	c.Lock()
 	defer c.Unlock()
 
-	return c.kubeNodeToHostname[nodename]
+	if keys, ok := c.nodeKeys[nodename]; ok {
+		return keys[i]
+	}
+	return nil
 }
 
-// GetNodeNameFromHostname returns node name that matches the hostname
-// in cache.
-func (c *kubeNodeCache) GetNodeNameFromHostname(hostname string) string {
+// getNodes returns the names of the nodes with a key of the i-th matcher
+func (c *kubeNodeCache) getNodes(i int, key string) []string {
 	c.Lock()
 	defer c.Unlock()
 
-	return c.hostnameToKubeNode[hostname]
+	result := []string{}
+	for nodename := range c.nodes[i][key] {
+		result = append(result, nodename)
+	}
+	return result
 }
 
 // OnAdd is the callback that gets triggered when informer informs the
@@ -71,21 +86,25 @@ func (c *kubeNodeCache) OnAdd(obj interface{}) {
 
 	log.Printf("kubeNodeCache: OnAdd(%s)", node.Name)
 
-	hostname := getHostname(node)
-	if hostname == "" {
-		return
-	}
-
 	c.Lock()
 	defer c.Unlock()
 
-	c.add(node, hostname)
+	c.add(node)
 }
 
 // add adds a Node in cache. Caller needs to own the lock.
-func (c *kubeNodeCache) add(node *v1.Node, hostname string) {
-	c.hostnameToKubeNode[hostname] = node.Name
-	c.kubeNodeToHostname[node.Name] = hostname
+func (c *kubeNodeCache) add(node *v1.Node) {
+	keys := make([][]string, len(c.matchers))
+	for i, matcher := range c.matchers {
+		keys[i] = matcher.NodeKeys(node)
+		for _, key := range keys[i] {
+			if _, ok := c.nodes[i][key]; !ok {
+				c.nodes[i][key] = make(map[string]struct{})
+			}
+			c.nodes[i][key][node.Name] = struct{}{}
+		}
+	}
+	c.nodeKeys[node.Name] = keys
 }
 
 // OnDelete is the callback that gets triggered when informer informs the
@@ -106,9 +125,15 @@ func (c *kubeNodeCache) OnDelete(obj interface{}) {
 
 // delete deletes a Node from cache. Caller needs to own the lock.
 func (c *kubeNodeCache) delete(node *v1.Node) {
-	hostname := c.kubeNodeToHostname[node.Name]
-	delete(c.kubeNodeToHostname, node.Name)
-	delete(c.hostnameToKubeNode, hostname)
+	for i, keys := range c.nodeKeys[node.Name] {
+		for _, key := range keys {
+			delete(c.nodes[i][key], node.Name)
+			if len(c.nodes[i][key]) == 0 {
+				delete(c.nodes[i], key)
+			}
+		}
+	}
+	delete(c.nodeKeys, node.Name)
 }
 
 // OnDelete is the callback that gets triggered when informer informs the
@@ -126,13 +151,11 @@ func (c *kubeNodeCache) OnUpdate(old, new interface{}) {
 
 	log.Printf("kubeNodeCache: OnUpdate(%s)", oldNode.Name)
 
-	hostname := getHostname(newNode)
-
 	c.Lock()
 	defer c.Unlock()
 
 	c.delete(oldNode)
-	c.add(newNode, hostname)
+	c.add(newNode)
 }
 
 // getHostname retrieves the hostname from Node status.
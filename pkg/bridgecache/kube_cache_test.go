@@ -17,6 +17,7 @@ limitations under the License.
 package bridgecache
 
 import (
+	"reflect"
 	"testing"
 	"time"
 
@@ -38,7 +39,7 @@ func TestKubeCache(t *testing.T) {
 		cache.Indexers{},
 	)
 
-	cache := newKubeNodeCache(nodeInformer)
+	cache := newKubeNodeCache(nodeInformer, []Matcher{hostnameMatcher{}})
 
 	stopCh := make(chan struct{})
 	defer close(stopCh)
@@ -75,12 +76,12 @@ func TestKubeCache(t *testing.T) {
 		t.Fatal("timeout waiting nodeInformer.HasSynced()")
 	}
 
-	if hostname := cache.GetHostnameFromNodeName("node1"); hostname != "hostname1" {
-		t.Errorf("expect hostname == '%s'; got '%s'", "hostname1", hostname)
+	if keys := cache.getKeys(0, "node1"); !reflect.DeepEqual(keys, []string{"hostname1"}) {
+		t.Errorf("expect keys == %v; got %v", []string{"hostname1"}, keys)
 	}
 
-	if nodename := cache.GetNodeNameFromHostname("hostname1"); nodename != "node1" {
-		t.Errorf("expect nodename == '%s'; got '%s'", "node1", nodename)
+	if nodes := cache.getNodes(0, "hostname1"); !reflect.DeepEqual(nodes, []string{"node1"}) {
+		t.Errorf("expect nodes == %v; got %v", []string{"node1"}, nodes)
 	}
 
 	// Test cache after updating node
@@ -95,16 +96,16 @@ func TestKubeCache(t *testing.T) {
 		t.Fatal("timeout waiting nodeInformer.HasSynced()")
 	}
 
-	if hostname := cache.GetHostnameFromNodeName("node1"); hostname != updatedHostname {
-		t.Errorf("expect hostname == '%s'; got '%s'", updatedHostname, hostname)
+	if keys := cache.getKeys(0, "node1"); !reflect.DeepEqual(keys, []string{updatedHostname}) {
+		t.Errorf("expect keys == %v; got %v", []string{updatedHostname}, keys)
 	}
 
-	if nodename := cache.GetNodeNameFromHostname(updatedHostname); nodename != "node1" {
-		t.Errorf("expect nodename == '%s'; got '%s'", "node1", nodename)
+	if nodes := cache.getNodes(0, updatedHostname); !reflect.DeepEqual(nodes, []string{"node1"}) {
+		t.Errorf("expect nodes == %v; got %v", []string{"node1"}, nodes)
 	}
 
-	if nodename := cache.GetNodeNameFromHostname("hostname1"); nodename != "" {
-		t.Errorf("expect nodename == '%s'; got '%s'", "", nodename)
+	if nodes := cache.getNodes(0, "hostname1"); len(nodes) != 0 {
+		t.Errorf("expect no nodes; got %v", nodes)
 	}
 
 	// Test after deleting node
@@ -117,11 +118,11 @@ func TestKubeCache(t *testing.T) {
 		t.Fatal("timeout waiting nodeInformer.HasSynced()")
 	}
 
-	if hostname := cache.GetHostnameFromNodeName("node1"); hostname != "" {
-		t.Errorf("expect hostname == '%s'; got '%s'", "", hostname)
+	if keys := cache.getKeys(0, "node1"); len(keys) != 0 {
+		t.Errorf("expect no keys; got %v", keys)
 	}
 
-	if nodename := cache.GetNodeNameFromHostname("hostname1"); nodename != "" {
-		t.Errorf("expect nodename == '%s'; got '%s'", "", nodename)
+	if nodes := cache.getNodes(0, updatedHostname); len(nodes) != 0 {
+		t.Errorf("expect no nodes; got %v", nodes)
 	}
 }
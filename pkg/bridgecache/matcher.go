@@ -0,0 +1,208 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bridgecache
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/vsphere"
+	"k8s.io/api/core/v1"
+)
+
+// Names of the node matching strategies
+const (
+	ProviderIDMatcher = "providerID"
+	UUIDMatcher       = "uuid"
+	IPMatcher         = "ip"
+	HostnameMatcher   = "hostname"
+)
+
+// DefaultMatchers is the default order of the node matching strategies
+var DefaultMatchers = []string{ProviderIDMatcher, UUIDMatcher, IPMatcher, HostnameMatcher}
+
+// Matcher is a strategy matching Kubernetes nodes with the VMs they run on by
+// one kind of identity, like the BIOS UUID. Nodes and VMs are matched by keys
+// tried in order, the first key with any match decides, and a match is
+// ambiguous if there are more than one.
+type Matcher interface {
+	// Name returns the name of the strategy
+	Name() string
+
+	// NodeKeys returns the keys of a node, none if it lacks the identity
+	NodeKeys(node *v1.Node) []string
+
+	// VMKeys returns the keys of a VM
+	VMKeys(querier vsphere.Querier, vmid string) []string
+
+	// VMIDs returns the VMs with a key
+	VMIDs(querier vsphere.Querier, key string) []string
+}
+
+// NewMatchers returns the node matching strategies of the names, in order
+func NewMatchers(names []string) ([]Matcher, error) {
+	var matchers []Matcher
+	for _, name := range names {
+		switch name {
+		case ProviderIDMatcher:
+			matchers = append(matchers, providerIDMatcher{})
+		case UUIDMatcher:
+			matchers = append(matchers, uuidMatcher{})
+		case IPMatcher:
+			matchers = append(matchers, ipMatcher{})
+		case HostnameMatcher:
+			matchers = append(matchers, hostnameMatcher{})
+		default:
+			return nil, fmt.Errorf("unknown node matcher %q", name)
+		}
+	}
+	if len(matchers) == 0 {
+		return nil, fmt.Errorf("no node matcher")
+	}
+	return matchers, nil
+}
+
+// providerIDPrefix is the prefix of the provider IDs set by the vSphere
+// cloud provider, followed by the BIOS UUID of the VM
+const providerIDPrefix = "vsphere://"
+
+// providerIDMatcher matches the provider ID of nodes with the BIOS UUID of
+// VMs
+type providerIDMatcher struct{}
+
+func (providerIDMatcher) Name() string {
+	return ProviderIDMatcher
+}
+
+func (providerIDMatcher) NodeKeys(node *v1.Node) []string {
+	if !strings.HasPrefix(node.Spec.ProviderID, providerIDPrefix) {
+		return nil
+	}
+	return []string{strings.ToLower(strings.TrimPrefix(node.Spec.ProviderID, providerIDPrefix))}
+}
+
+func (providerIDMatcher) VMKeys(querier vsphere.Querier, vmid string) []string {
+	return uuidKeys(querier, vmid)
+}
+
+func (providerIDMatcher) VMIDs(querier vsphere.Querier, key string) []string {
+	return querier.GetVMIDsFromUUID(key)
+}
+
+// uuidMatcher matches the system UUID of nodes with the BIOS UUID of VMs.
+// Older VM hardware versions report the system UUID with the first three
+// fields in little-endian order, so the swapped UUID matches as well.
+type uuidMatcher struct{}
+
+func (uuidMatcher) Name() string {
+	return UUIDMatcher
+}
+
+func (uuidMatcher) NodeKeys(node *v1.Node) []string {
+	uuid := strings.ToLower(node.Status.NodeInfo.SystemUUID)
+	if uuid == "" {
+		return nil
+	}
+
+	keys := []string{uuid}
+	if swapped := swapUUID(uuid); swapped != "" && swapped != uuid {
+		keys = append(keys, swapped)
+	}
+	return keys
+}
+
+func (uuidMatcher) VMKeys(querier vsphere.Querier, vmid string) []string {
+	return uuidKeys(querier, vmid)
+}
+
+func (uuidMatcher) VMIDs(querier vsphere.Querier, key string) []string {
+	return querier.GetVMIDsFromUUID(key)
+}
+
+func uuidKeys(querier vsphere.Querier, vmid string) []string {
+	if uuid := querier.GetUUIDFromVMID(vmid); uuid != "" {
+		return []string{uuid}
+	}
+	return nil
+}
+
+// swapUUID swaps the byte order of the first three fields of a UUID, it
+// returns an empty string if uuid is malformed
+func swapUUID(uuid string) string {
+	fields := strings.Split(uuid, "-")
+	if len(fields) != 5 || len(fields[0]) != 8 || len(fields[1]) != 4 || len(fields[2]) != 4 {
+		return ""
+	}
+
+	for i := 0; i < 3; i++ {
+		field := fields[i]
+		swapped := make([]byte, 0, len(field))
+		for j := len(field) - 2; j >= 0; j -= 2 {
+			swapped = append(swapped, field[j:j+2]...)
+		}
+		fields[i] = string(swapped)
+	}
+	return strings.Join(fields, "-")
+}
+
+// ipMatcher matches the addresses of nodes with the guest IP addresses of VMs
+type ipMatcher struct{}
+
+func (ipMatcher) Name() string {
+	return IPMatcher
+}
+
+func (ipMatcher) NodeKeys(node *v1.Node) []string {
+	var keys []string
+	for _, addressType := range []v1.NodeAddressType{v1.NodeInternalIP, v1.NodeExternalIP} {
+		for _, address := range node.Status.Addresses {
+			if address.Type == addressType && net.ParseIP(address.Address) != nil {
+				keys = append(keys, address.Address)
+			}
+		}
+	}
+	return keys
+}
+
+func (ipMatcher) VMKeys(querier vsphere.Querier, vmid string) []string {
+	return querier.GetIPsFromVMID(vmid)
+}
+
+func (ipMatcher) VMIDs(querier vsphere.Querier, key string) []string {
+	return querier.GetVMIDsFromIP(key)
+}
+
+// hostnameMatcher matches the hostname of nodes with the guest hostname of
+// VMs, which needs VMware Tools. Full hostnames are tried before short ones.
+type hostnameMatcher struct{}
+
+func (hostnameMatcher) Name() string {
+	return HostnameMatcher
+}
+
+func (hostnameMatcher) NodeKeys(node *v1.Node) []string {
+	return vsphere.HostnameKeys(getHostname(node))
+}
+
+func (hostnameMatcher) VMKeys(querier vsphere.Querier, vmid string) []string {
+	return vsphere.HostnameKeys(querier.GetHostnameFromVMID(vmid))
+}
+
+func (hostnameMatcher) VMIDs(querier vsphere.Querier, key string) []string {
+	return querier.GetVMIDsFromHostname(key)
+}
@@ -18,7 +18,11 @@ package bridgecache
 
 import (
 	"log"
+	"sort"
+	"strings"
+	"sync"
 
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/metrics"
 	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/vsphere"
 	"k8s.io/client-go/tools/cache"
 )
@@ -29,32 +33,88 @@ import (
 type Cache interface {
 	// GetVMIDFromNode returns virtual machine from vSphere
 	GetVMIDFromNode(name string) string
+
+	// GetNodeFromVMID returns the Kubernetes node name of a virtual machine
+	GetNodeFromVMID(vmid string) string
 }
 
 // cacheStore is an implementation of Cache.
 //
 // cacheStore keeps watching vSphere and Kubernetes to collect updates and save
-// them internally.
+// them internally. Nodes and VMs are matched by the strategies of the node
+// matchers, tried in order until one of them finds a single match.
 type cacheStore struct {
 	*kubeNodeCache
 	vsphere.Querier
+
+	// reported keeps the ambiguous matches already reported
+	reported     map[string]string
+	reportedLock sync.Mutex
 }
 
-// NewCache returns a Cache instance
-func NewCache(nodeInformer cache.SharedIndexInformer, querier vsphere.Querier) Cache {
+// NewCache returns a Cache instance matching nodes and VMs with matchers
+func NewCache(nodeInformer cache.SharedIndexInformer, querier vsphere.Querier, matchers []Matcher) Cache {
 	return &cacheStore{
 		Querier:       querier,
-		kubeNodeCache: newKubeNodeCache(nodeInformer),
+		kubeNodeCache: newKubeNodeCache(nodeInformer, matchers),
+		reported:      make(map[string]string),
 	}
 }
 
 // GetVMIDFromNode returns virtual machine from vSphere
 func (c *cacheStore) GetVMIDFromNode(name string) string {
-	hostname := c.GetHostnameFromNodeName(name)
-	log.Printf("node,hostname: %s,%s", name, hostname)
-	if hostname == "" {
-		return ""
+	for i, matcher := range c.matchers {
+		for _, key := range c.getKeys(i, name) {
+			vmids := matcher.VMIDs(c.Querier, key)
+			if len(vmids) == 1 {
+				return vmids[0]
+			}
+			if len(vmids) > 1 {
+				c.reportAmbiguous(matcher, "node "+name, key, vmids)
+				break
+			}
+		}
+	}
+	return ""
+}
+
+// GetNodeFromVMID returns the Kubernetes node name of a virtual machine. The
+// node needs to match the virtual machine back, since keys like short
+// hostnames may match one way only.
+func (c *cacheStore) GetNodeFromVMID(vmid string) string {
+	for i, matcher := range c.matchers {
+		for _, key := range matcher.VMKeys(c.Querier, vmid) {
+			nodes := c.getNodes(i, key)
+			if len(nodes) == 1 {
+				if c.GetVMIDFromNode(nodes[0]) != vmid {
+					return ""
+				}
+				return nodes[0]
+			}
+			if len(nodes) > 1 {
+				c.reportAmbiguous(matcher, "vm "+vmid, key, nodes)
+				break
+			}
+		}
+	}
+	return ""
+}
+
+// reportAmbiguous reports that the key of a node or a VM matches several
+// others, once until the matches change, and the next matcher is tried.
+func (c *cacheStore) reportAmbiguous(matcher Matcher, subject, key string, matches []string) {
+	sort.Strings(matches)
+	id := matcher.Name() + " " + subject
+	value := key + " " + strings.Join(matches, ",")
+
+	c.reportedLock.Lock()
+	defer c.reportedLock.Unlock()
+
+	if c.reported[id] == value {
+		return
 	}
+	c.reported[id] = value
 
-	return c.GetVMIDFromHostname(hostname)
+	log.Printf("[WARNING] bridgecache: %s matches %v by %s %s ambiguously", subject, matches, matcher.Name(), key)
+	metrics.NodeMatchAmbiguous.Inc(matcher.Name())
 }
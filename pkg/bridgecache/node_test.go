@@ -0,0 +1,204 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bridgecache
+
+import (
+	"testing"
+
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/test"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/vsphere"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// fakeVM is the identity of a VM
+type fakeVM struct {
+	uuid     string
+	ips      []string
+	hostname string
+}
+
+// fakeNodeQuerier finds VMs by their identities
+type fakeNodeQuerier struct {
+	vsphere.Querier
+	vms map[string]fakeVM
+}
+
+func (q *fakeNodeQuerier) find(match func(vm fakeVM) bool) []string {
+	result := []string{}
+	for vmid, vm := range q.vms {
+		if match(vm) {
+			result = append(result, vmid)
+		}
+	}
+	return result
+}
+
+func (q *fakeNodeQuerier) GetUUIDFromVMID(vmid string) string {
+	return q.vms[vmid].uuid
+}
+
+func (q *fakeNodeQuerier) GetVMIDsFromUUID(uuid string) []string {
+	return q.find(func(vm fakeVM) bool { return vm.uuid == uuid })
+}
+
+func (q *fakeNodeQuerier) GetIPsFromVMID(vmid string) []string {
+	return q.vms[vmid].ips
+}
+
+func (q *fakeNodeQuerier) GetVMIDsFromIP(ip string) []string {
+	return q.find(func(vm fakeVM) bool {
+		for _, i := range vm.ips {
+			if i == ip {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (q *fakeNodeQuerier) GetHostnameFromVMID(vmid string) string {
+	return q.vms[vmid].hostname
+}
+
+func (q *fakeNodeQuerier) GetVMIDsFromHostname(hostname string) []string {
+	return q.find(func(vm fakeVM) bool {
+		for _, key := range vsphere.HostnameKeys(vm.hostname) {
+			if key == hostname {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func newMatchNode(name, providerID, systemUUID string, addresses ...v1.NodeAddress) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       v1.NodeSpec{ProviderID: providerID},
+		Status: v1.NodeStatus{
+			Addresses: addresses,
+			NodeInfo:  v1.NodeSystemInfo{SystemUUID: systemUUID},
+		},
+	}
+}
+
+func TestNodeMatching(t *testing.T) {
+	querier := &fakeNodeQuerier{
+		vms: map[string]fakeVM{
+			"vm-1": {uuid: "4201abcd-0000-1111-2222-333344445555"},
+			"vm-2": {uuid: "12345678-9abc-def0-1234-56789abcdef0"},
+			"vm-3": {ips: []string{"10.0.0.3"}},
+			"vm-4": {hostname: "node4"},
+			// clones sharing a BIOS UUID
+			"vm-5": {uuid: "55555555-0000-0000-0000-000000000000", ips: []string{"10.0.0.5"}},
+			"vm-6": {uuid: "55555555-0000-0000-0000-000000000000", ips: []string{"10.0.0.6"}},
+			// colliding hostnames
+			"vm-7": {hostname: "dup.example.com"},
+			"vm-8": {hostname: "dup.example.org"},
+		},
+	}
+
+	matchers, err := NewMatchers(DefaultMatchers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodeInformer := cache.NewSharedIndexInformer(test.NewFakeNodeListWatch(), &v1.Node{}, 0, cache.Indexers{})
+	c := NewCache(nodeInformer, querier, matchers).(*cacheStore)
+
+	for _, node := range []*v1.Node{
+		newMatchNode("node1", "vsphere://4201ABCD-0000-1111-2222-333344445555", ""),
+		// little-endian system UUID
+		newMatchNode("node2", "", "78563412-BC9A-F0DE-1234-56789ABCDEF0"),
+		newMatchNode("node3", "", "", v1.NodeAddress{Type: v1.NodeInternalIP, Address: "10.0.0.3"}),
+		newMatchNode("node4", "", "", v1.NodeAddress{Type: v1.NodeHostName, Address: "Node4.example.com"}),
+		newMatchNode("node5", "", "55555555-0000-0000-0000-000000000000",
+			v1.NodeAddress{Type: v1.NodeInternalIP, Address: "10.0.0.5"}),
+		newMatchNode("node6", "", "", v1.NodeAddress{Type: v1.NodeHostName, Address: "dup"}),
+	} {
+		c.OnAdd(node)
+	}
+
+	tests := []struct {
+		node string
+		vmid string
+	}{
+		{"node1", "vm-1"},
+		{"node2", "vm-2"},
+		{"node3", "vm-3"},
+		{"node4", "vm-4"},
+		// the UUID is ambiguous, the IP address is not
+		{"node5", "vm-5"},
+		{"node6", ""},
+		{"node7", ""},
+	}
+	for _, test := range tests {
+		if vmid := c.GetVMIDFromNode(test.node); vmid != test.vmid {
+			t.Errorf("expect vmid of %s %q; got %q", test.node, test.vmid, vmid)
+		}
+		if test.vmid == "" {
+			continue
+		}
+		if node := c.GetNodeFromVMID(test.vmid); node != test.node {
+			t.Errorf("expect node of %s %q; got %q", test.vmid, test.node, node)
+		}
+	}
+
+	if node := c.GetNodeFromVMID("vm-7"); node != "" {
+		t.Errorf("expect no node of vm-7; got %q", node)
+	}
+
+	for _, id := range []string{"uuid node node5", "hostname node node6"} {
+		if _, ok := c.reported[id]; !ok {
+			t.Errorf("expect %s reported ambiguous; got %v", id, c.reported)
+		}
+	}
+}
+
+func TestNewMatchers(t *testing.T) {
+	if _, err := NewMatchers([]string{"hostname", "mac"}); err == nil {
+		t.Errorf("expect error of an unknown matcher")
+	}
+	if _, err := NewMatchers(nil); err == nil {
+		t.Errorf("expect error without matchers")
+	}
+
+	matchers, err := NewMatchers([]string{"hostname", "uuid"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matchers) != 2 || matchers[0].Name() != HostnameMatcher || matchers[1].Name() != UUIDMatcher {
+		t.Errorf("expect hostname and uuid matchers in order; got %v", matchers)
+	}
+}
+
+func TestSwapUUID(t *testing.T) {
+	tests := []struct {
+		uuid   string
+		expect string
+	}{
+		{"12345678-9abc-def0-1234-56789abcdef0", "78563412-bc9a-f0de-1234-56789abcdef0"},
+		{"not-a-uuid", ""},
+	}
+	for _, test := range tests {
+		if swapped := swapUUID(test.uuid); swapped != test.expect {
+			t.Errorf("expect %s swapped %q; got %q", test.uuid, test.expect, swapped)
+		}
+	}
+}
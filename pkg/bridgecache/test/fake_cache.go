@@ -23,3 +23,13 @@ type FakeCache map[string]string
 func (c FakeCache) GetVMIDFromNode(name string) string {
 	return c[name]
 }
+
+// GetNodeFromVMID returns the kubernetes node name from vmid
+func (c FakeCache) GetNodeFromVMID(vmid string) string {
+	for name, id := range c {
+		if id == vmid {
+			return name
+		}
+	}
+	return ""
+}
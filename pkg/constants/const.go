@@ -19,4 +19,27 @@ package constants
 const (
 	// HostLabel is the label for physical host name on Kubernetes node.
 	HostLabel = "alpha.cna.vmware.com/host"
+
+	// DatastoreLabel is the topology key for the datastores the VM of a
+	// Kubernetes node is stored on. A VM can be stored on several datastores,
+	// so it is resolved from vSphere instead of being set on the node.
+	DatastoreLabel = "alpha.cna.vmware.com/datastore"
+
+	// HostGroupLabel is the topology key for the DRS host groups, such as
+	// racks or power domains, the host of a Kubernetes node belongs to. Like
+	// DatastoreLabel, it is resolved from vSphere.
+	HostGroupLabel = "alpha.cna.vmware.com/hostgroup"
+
+	// TopologySpreadAnnotation is the pod annotation holding topology spread
+	// constraints, in the JSON format of the topologySpreadConstraints field
+	// of the pod spec, which the vendored Kubernetes API does not have yet.
+	// The field in the pod of extender requests takes precedence over it.
+	TopologySpreadAnnotation = "alpha.cna.vmware.com/topology-spread-constraints"
+
+	// NamespaceSelectorAnnotation is the pod annotation holding the namespace
+	// selectors of its pod affinity and anti-affinity terms, since the
+	// vendored Kubernetes API has no namespaceSelector field in
+	// PodAffinityTerm. It is a JSON object of label selectors keyed by the
+	// kind and index of the term, like anti-affinity-0, see selector.TermKey.
+	NamespaceSelectorAnnotation = "alpha.cna.vmware.com/namespace-selector"
 )
@@ -0,0 +1,130 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package binder
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	// ErrPodNotFound is raised when the pod to bind is not in cache
+	ErrPodNotFound = errors.New("pod to bind is not found")
+
+	// ErrPodUIDMismatch is raised when the cached pod has a different UID
+	// than the one to bind, e.g. it has been recreated.
+	ErrPodUIDMismatch = errors.New("pod to bind has a different UID")
+)
+
+// Binder binds a pod to a node
+type Binder interface {
+	// Bind binds the pod identified by namespace, name and uid to node. It
+	// returns error if the placement is no longer valid.
+	Bind(namespace, name string, uid types.UID, node string) error
+}
+
+// Assumer keeps the pods bound by the binder until the cache sees them bound
+type Assumer interface {
+	// Assume records that pod is bound to node
+	Assume(pod *v1.Pod, node string)
+
+	// Forget drops an assumed pod
+	Forget(pod *v1.Pod)
+}
+
+type binder struct {
+	client    kubernetes.Interface
+	podGetter algorithm.PodGetter
+	filter    algorithm.Filter
+	assumer   Assumer
+
+	// binds are serialized so that the check against the cache and the
+	// binding of one pod are not interleaved with another.
+	sync.Mutex
+}
+
+// New creates a Binder instance. filter is re-applied on the node right
+// before binding, the binding is rejected if the node is filtered out. The
+// bound pods are assumed by assumer, which filter needs to list pods from, so
+// that the next binds see them before the cache does.
+func New(client kubernetes.Interface, podGetter algorithm.PodGetter, filter algorithm.Filter,
+	assumer Assumer) Binder {
+	return &binder{
+		client:    client,
+		podGetter: podGetter,
+		filter:    filter,
+		assumer:   assumer,
+	}
+}
+
+// Bind binds the pod identified by namespace, name and uid to node.
+func (b *binder) Bind(namespace, name string, uid types.UID, node string) error {
+	b.Lock()
+	defer b.Unlock()
+
+	pod, err := b.podGetter.GetPod(namespace, name)
+	if err != nil {
+		return err
+	}
+	if pod == nil {
+		return ErrPodNotFound
+	}
+	if pod.UID != uid {
+		return ErrPodUIDMismatch
+	}
+
+	nodes, failed, err := b.filter.Filter(pod, []string{node})
+	if err != nil {
+		return err
+	}
+	if len(nodes) == 0 {
+		return fmt.Errorf("binder: cannot bind pod %s/%s to node %s: %s",
+			namespace, name, node, failed[node])
+	}
+
+	log.Printf("binder: binding pod %s/%s to node %s", namespace, name, node)
+
+	binding := &v1.Binding{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			UID:       uid,
+		},
+		Target: v1.ObjectReference{
+			Kind: "Node",
+			Name: node,
+		},
+	}
+
+	// The pod is assumed before binding, since the informer may see it bound
+	// before Bind returns
+	b.assumer.Assume(pod, node)
+	if err := b.client.CoreV1().Pods(namespace).Bind(binding); err != nil {
+		b.assumer.Forget(pod)
+		return err
+	}
+
+	return nil
+}
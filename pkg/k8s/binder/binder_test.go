@@ -0,0 +1,194 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package binder
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm/fake"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm/filters"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/constants"
+	k8scache "github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/k8s/cache"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+type podGetter []*v1.Pod
+
+func (g podGetter) GetPod(namespace, name string) (*v1.Pod, error) {
+	for _, pod := range g {
+		if pod.Namespace == namespace && pod.Name == name {
+			return pod, nil
+		}
+	}
+	return nil, nil
+}
+
+// newBindingServer returns a client of a fake API server recording the
+// bindings
+func newBindingServer(t *testing.T, bindings *[]v1.Binding) (kubernetes.Interface, *httptest.Server) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var binding v1.Binding
+		if err := json.NewDecoder(r.Body).Decode(&binding); err != nil {
+			t.Errorf("failed to decode binding: %s", err)
+		}
+		*bindings = append(*bindings, binding)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(&metav1.Status{Status: metav1.StatusSuccess})
+	}))
+
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+func TestBinder(t *testing.T) {
+	var bindings []v1.Binding
+	client, server := newBindingServer(t, &bindings)
+	defer server.Close()
+
+	anchorPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "anchor",
+			Labels:    map[string]string{"key": "value"},
+		},
+		Spec: v1.PodSpec{
+			NodeName: "node1",
+		},
+	}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "pod1",
+			UID:       types.UID("uid-pod1"),
+		},
+		Spec: v1.PodSpec{
+			Affinity: &v1.Affinity{
+				PodAntiAffinity: &v1.PodAntiAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{
+						{
+							LabelSelector: &metav1.LabelSelector{
+								MatchLabels: map[string]string{"key": "value"},
+							},
+							TopologyKey: constants.HostLabel,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	pods := []*v1.Pod{anchorPod, pod}
+	nodeCache := fake.NodeCache(map[string]string{
+		"node1": "host1",
+		"node2": "host1",
+		"node3": "host2",
+	})
+	assumed := k8scache.NewAssumedPods(fake.NewPodLister(pods))
+	b := New(client, podGetter(pods), filters.NewPodAntiAffinity(assumed, nil, nodeCache, nil), assumed)
+
+	if err := b.Bind("default", "pod1", "uid-pod1", "node2"); err == nil {
+		t.Errorf("expect binding to node2 to fail")
+	}
+	if err := b.Bind("default", "pod2", "uid-pod2", "node3"); err != ErrPodNotFound {
+		t.Errorf("expect %s; got %v", ErrPodNotFound, err)
+	}
+	if err := b.Bind("default", "pod1", "uid-other", "node3"); err != ErrPodUIDMismatch {
+		t.Errorf("expect %s; got %v", ErrPodUIDMismatch, err)
+	}
+	if len(bindings) != 0 {
+		t.Errorf("expect no binding created; got %+v", bindings)
+	}
+
+	if err := b.Bind("default", "pod1", "uid-pod1", "node3"); err != nil {
+		t.Errorf("expect binding to node3 to succeed; got %s", err)
+	}
+	if len(bindings) != 1 || bindings[0].Name != "pod1" || bindings[0].Target.Name != "node3" {
+		t.Errorf("expect pod1 bound to node3; got %+v", bindings)
+	}
+}
+
+func TestBinderAssumesBoundPods(t *testing.T) {
+	var bindings []v1.Binding
+	client, server := newBindingServer(t, &bindings)
+	defer server.Close()
+
+	newPod := func(name string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      name,
+				UID:       types.UID("uid-" + name),
+				Labels:    map[string]string{"app": "web"},
+			},
+			Spec: v1.PodSpec{
+				Affinity: &v1.Affinity{
+					PodAntiAffinity: &v1.PodAntiAffinity{
+						RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{
+							{
+								LabelSelector: &metav1.LabelSelector{
+									MatchLabels: map[string]string{"app": "web"},
+								},
+								TopologyKey: constants.HostLabel,
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	// the cache never sees the pods bound during the test
+	pods := []*v1.Pod{newPod("pod1"), newPod("pod2")}
+	nodeCache := fake.NodeCache(map[string]string{
+		"node1": "host1",
+		"node2": "host1",
+		"node3": "host2",
+	})
+	assumed := k8scache.NewAssumedPods(fake.NewPodLister(pods))
+	b := New(client, podGetter(pods), filters.NewPodAntiAffinity(assumed, nil, nodeCache, nil), assumed)
+
+	if err := b.Bind("default", "pod1", "uid-pod1", "node1"); err != nil {
+		t.Fatalf("expect binding pod1 to node1 to succeed; got %s", err)
+	}
+	if err := b.Bind("default", "pod2", "uid-pod2", "node2"); err == nil {
+		t.Errorf("expect binding pod2 to node2 on the host of pod1 to fail")
+	}
+	if err := b.Bind("default", "pod2", "uid-pod2", "node3"); err != nil {
+		t.Errorf("expect binding pod2 to node3 to succeed; got %s", err)
+	}
+	if len(bindings) != 2 {
+		t.Errorf("expect 2 bindings; got %+v", bindings)
+	}
+
+	// the informer sees pod1 bound, it is no longer assumed
+	bound := pods[0].DeepCopy()
+	bound.Spec.NodeName = "node1"
+	assumed.Update(pods[0], bound)
+	if list, _ := assumed.ListAntiAffinityPods(constants.HostLabel); len(list) != 1 || list[0].Name != "pod2" {
+		t.Errorf("expect only pod2 assumed; got %v", list)
+	}
+}
@@ -0,0 +1,143 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8scache
+
+import (
+	"sync"
+
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/selector"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+)
+
+// AssumedPods is a PodLister overlaying the pods being bound, or bound but
+// not yet seen bound by the informer, so that the checks of the next binds
+// take them into account. A pod is forgotten once the informer sees its
+// spec.nodeName, or it is deleted.
+type AssumedPods struct {
+	algorithm.PodLister
+
+	lock sync.Mutex
+	pods map[types.UID]*v1.Pod
+}
+
+// NewAssumedPods creates an AssumedPods overlaying lister. It needs to be
+// registered as a handler of the pod informer backing lister.
+func NewAssumedPods(lister algorithm.PodLister) *AssumedPods {
+	return &AssumedPods{
+		PodLister: lister,
+		pods:      make(map[types.UID]*v1.Pod),
+	}
+}
+
+// Assume records that pod is bound to node
+func (a *AssumedPods) Assume(pod *v1.Pod, node string) {
+	assumed := pod.DeepCopy()
+	assumed.Spec.NodeName = node
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.pods[pod.UID] = assumed
+}
+
+// Forget drops an assumed pod, e.g. after its binding failed
+func (a *AssumedPods) Forget(pod *v1.Pod) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	delete(a.pods, pod.UID)
+}
+
+// Add expires the assumed pod once the informer sees it bound
+func (a *AssumedPods) Add(obj interface{}) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok || pod.Spec.NodeName == "" {
+		return
+	}
+	a.Forget(pod)
+}
+
+// Update expires the assumed pod once the informer sees it bound
+func (a *AssumedPods) Update(old, new interface{}) {
+	a.Add(new)
+}
+
+// Delete expires the assumed pod
+func (a *AssumedPods) Delete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	if pod, ok := obj.(*v1.Pod); ok {
+		a.Forget(pod)
+	}
+}
+
+// ListPod lists the pods matching s, the assumed ones replace their
+// unbound copies.
+func (a *AssumedPods) ListPod(s selector.Selector) ([]*v1.Pod, error) {
+	pods, err := a.PodLister.ListPod(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.overlay(pods, func(pod *v1.Pod) bool {
+		return s.Matches(selector.PodLabels(pod))
+	}), nil
+}
+
+// ListAntiAffinityPods lists the assigned pods with required anti-affinity
+// terms with the topology key, including the assumed ones.
+func (a *AssumedPods) ListAntiAffinityPods(topologyKey string) ([]*v1.Pod, error) {
+	pods, err := a.PodLister.ListAntiAffinityPods(topologyKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.overlay(pods, func(pod *v1.Pod) bool {
+		for _, key := range algorithm.AntiAffinityTopologyKeys(pod) {
+			if key == topologyKey {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+// overlay replaces the assumed pods in pods, and adds the ones matching match
+// that are missing.
+func (a *AssumedPods) overlay(pods []*v1.Pod, match func(*v1.Pod) bool) []*v1.Pod {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if len(a.pods) == 0 {
+		return pods
+	}
+
+	result := make([]*v1.Pod, 0, len(pods)+len(a.pods))
+	for _, pod := range pods {
+		if _, ok := a.pods[pod.UID]; !ok {
+			result = append(result, pod)
+		}
+	}
+	for _, pod := range a.pods {
+		if match(pod) {
+			result = append(result, pod)
+		}
+	}
+	return result
+}
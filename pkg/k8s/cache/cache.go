@@ -17,6 +17,7 @@ limitations under the License.
 package k8scache
 
 import (
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm"
 	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/selector"
 	"k8s.io/api/core/v1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -26,6 +27,31 @@ import (
 	"k8s.io/client-go/tools/cache"
 )
 
+// antiAffinityIndex indexes the assigned pods by the topology keys of their
+// required anti-affinity terms, so that filters needn't scan all pods.
+const antiAffinityIndex = "antiAffinityTopologyKey"
+
+func indexAntiAffinity(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok || pod.Spec.NodeName == "" {
+		return nil, nil
+	}
+
+	return algorithm.AntiAffinityTopologyKeys(pod), nil
+}
+
+// podIndexers returns the indexers of the pod informer. The label and
+// namespace indexes are kept with the store, so that ListPod never misses a
+// pod in the store.
+func podIndexers() cache.Indexers {
+	return cache.Indexers{
+		antiAffinityIndex:           indexAntiAffinity,
+		selector.NamespaceIndexName: selector.IndexNamespace,
+		selector.LabelIndexName:     selector.IndexLabels,
+		selector.LabelKeyIndexName:  selector.IndexLabelKeys,
+	}
+}
+
 // CacheHandler is the interface for cache updates from external source.
 type CacheHandler interface {
 	Add(obj interface{})
@@ -47,8 +73,17 @@ type SchedCache struct {
 	*nodePodCache
 	*hostLabelCache
 
-	podInformer  cache.SharedIndexInformer
-	nodeInformer cache.SharedIndexInformer
+	podInformer     cache.SharedIndexInformer
+	nodeInformer    cache.SharedIndexInformer
+	serviceInformer cache.SharedIndexInformer
+
+	// namespaceInformer resolves the namespace selectors of pod affinity
+	// terms
+	namespaceInformer cache.SharedIndexInformer
+
+	// podLabels looks up pods in the label and namespace indexes of
+	// podInformer for ListPod
+	podLabels *selector.LabelIndex
 }
 
 // New creates a SchedCache instance
@@ -68,8 +103,9 @@ func New(client kubernetes.Interface) *SchedCache {
 		lw,
 		&v1.Pod{},
 		0, // skip resync
-		cache.Indexers{},
+		podIndexers(),
 	)
+	c.podLabels = selector.NewLabelIndex(c.podInformer.GetIndexer())
 
 	c.podInformer.AddEventHandler(CreateHandler(c.nodePodCache))
 
@@ -88,6 +124,32 @@ func New(client kubernetes.Interface) *SchedCache {
 
 	c.nodeInformer.AddEventHandler(CreateHandler(c.hostLabelCache))
 
+	serviceLw := cache.NewListWatchFromClient(
+		client.CoreV1().RESTClient(),
+		"services",
+		meta_v1.NamespaceAll,
+		fields.Everything())
+
+	c.serviceInformer = cache.NewSharedIndexInformer(
+		serviceLw,
+		&v1.Service{},
+		0, // skip resync
+		cache.Indexers{},
+	)
+
+	namespaceLw := cache.NewListWatchFromClient(
+		client.CoreV1().RESTClient(),
+		"namespaces",
+		meta_v1.NamespaceAll,
+		fields.Everything())
+
+	c.namespaceInformer = cache.NewSharedIndexInformer(
+		namespaceLw,
+		&v1.Namespace{},
+		0, // skip resync
+		cache.Indexers{},
+	)
+
 	return c
 }
 
@@ -96,6 +158,16 @@ func New(client kubernetes.Interface) *SchedCache {
 func (c *SchedCache) Run(stopCh <-chan struct{}) {
 	go c.podInformer.Run(stopCh)
 	go c.nodeInformer.Run(stopCh)
+	go c.serviceInformer.Run(stopCh)
+	go c.namespaceInformer.Run(stopCh)
+}
+
+// HasSynced returns true once the informers in cache have synced
+func (c *SchedCache) HasSynced() bool {
+	return c.podInformer.HasSynced() &&
+		c.nodeInformer.HasSynced() &&
+		c.serviceInformer.HasSynced() &&
+		c.namespaceInformer.HasSynced()
 }
 
 // ListNode lists all nodes cached in SchedCached
@@ -110,6 +182,20 @@ func (c *SchedCache) ListNode() ([]*v1.Node, error) {
 	return result, nil
 }
 
+// ListNamespaces lists the names of the namespaces cached in SchedCache that
+// match the selector
+func (c *SchedCache) ListNamespaces(s labels.Selector) ([]string, error) {
+	result := []string{}
+	for _, obj := range c.namespaceInformer.GetStore().List() {
+		namespace := obj.(*v1.Namespace)
+		if s.Matches(labels.Set(namespace.Labels)) {
+			result = append(result, namespace.Name)
+		}
+	}
+
+	return result, nil
+}
+
 // PodInformer returns the SharedIndexInformer for pods
 func (c *SchedCache) PodInformer() cache.SharedIndexInformer {
 	return c.podInformer
@@ -120,17 +206,76 @@ func (c *SchedCache) NodeInformer() cache.SharedIndexInformer {
 	return c.nodeInformer
 }
 
-// ListPod list pods that matched the selector cached in SchedCache
-func (c *SchedCache) ListPod(selector selector.Selector) ([]*v1.Pod, error) {
+// ListPod list pods that matched the selector cached in SchedCache. Only the
+// candidates found in the label index are matched, if s can be narrowed down.
+func (c *SchedCache) ListPod(s selector.Selector) ([]*v1.Pod, error) {
 	result := []*v1.Pod{}
-	list := c.podInformer.GetStore().List()
+	store := c.podInformer.GetStore()
+
+	var list []interface{}
+	if keys, ok := c.podLabels.Candidates(s); ok {
+		list = make([]interface{}, 0, len(keys))
+		for _, key := range keys {
+			obj, exists, err := store.GetByKey(key)
+			if err != nil {
+				return nil, err
+			}
+			if exists {
+				list = append(list, obj)
+			}
+		}
+	} else {
+		list = store.List()
+	}
 
 	for _, obj := range list {
 		pod := obj.(*v1.Pod)
-		if selector.Matches(labels.Set(pod.GetLabels())) {
+		if s.Matches(selector.PodLabels(pod)) {
 			result = append(result, pod)
 		}
 	}
 
 	return result, nil
 }
+
+// ListAntiAffinityPods lists the pods cached in SchedCache that are assigned
+// to a node and have required anti-affinity terms with the topology key.
+func (c *SchedCache) ListAntiAffinityPods(topologyKey string) ([]*v1.Pod, error) {
+	list, err := c.podInformer.GetIndexer().ByIndex(antiAffinityIndex, topologyKey)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*v1.Pod, 0, len(list))
+	for _, obj := range list {
+		result = append(result, obj.(*v1.Pod))
+	}
+
+	return result, nil
+}
+
+// GetPod gets the pod cached in SchedCache by namespace and name, it returns
+// nil if the pod is not found.
+func (c *SchedCache) GetPod(namespace, name string) (*v1.Pod, error) {
+	obj, exists, err := c.podInformer.GetStore().GetByKey(namespace + "/" + name)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	return obj.(*v1.Pod), nil
+}
+
+// ListService lists services in the given namespace cached in SchedCache
+func (c *SchedCache) ListService(namespace string) ([]*v1.Service, error) {
+	result := []*v1.Service{}
+	list := c.serviceInformer.GetStore().List()
+
+	for _, obj := range list {
+		service := obj.(*v1.Service)
+		if service.Namespace == namespace {
+			result = append(result, service)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,180 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8scache
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/constants"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/selector"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestIndexAntiAffinity(t *testing.T) {
+	antiAffinity := func(topologyKey string) *v1.Affinity {
+		return &v1.Affinity{
+			PodAntiAffinity: &v1.PodAntiAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{
+					{
+						LabelSelector: &metav1.LabelSelector{
+							MatchLabels: map[string]string{"app": "web"},
+						},
+						TopologyKey: topologyKey,
+					},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		desc   string
+		pod    *v1.Pod
+		expect []string
+	}{
+		{
+			desc: "assigned pod with host anti-affinity",
+			pod: &v1.Pod{
+				Spec: v1.PodSpec{
+					NodeName: "node1",
+					Affinity: antiAffinity(constants.HostLabel),
+				},
+			},
+			expect: []string{constants.HostLabel},
+		},
+		{
+			desc: "pending pod with host anti-affinity",
+			pod: &v1.Pod{
+				Spec: v1.PodSpec{
+					Affinity: antiAffinity(constants.HostLabel),
+				},
+			},
+		},
+		{
+			desc: "assigned pod with datastore anti-affinity",
+			pod: &v1.Pod{
+				Spec: v1.PodSpec{
+					NodeName: "node1",
+					Affinity: antiAffinity(constants.DatastoreLabel),
+				},
+			},
+			expect: []string{constants.DatastoreLabel},
+		},
+		{
+			desc: "assigned pod without affinity",
+			pod: &v1.Pod{
+				Spec: v1.PodSpec{
+					NodeName: "node1",
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		values, err := indexAntiAffinity(test.pod)
+		if err != nil {
+			t.Error(err)
+		}
+		if len(values) > 0 || len(test.expect) > 0 {
+			if !reflect.DeepEqual(values, test.expect) {
+				t.Errorf("[%s] expect index values %v; got %v", test.desc, test.expect, values)
+			}
+		}
+	}
+}
+
+// newBenchmarkCache creates a SchedCache with n pods in 1000 apps, without
+// running the informers.
+func newBenchmarkCache(n int) *SchedCache {
+	c := &SchedCache{
+		podInformer: cache.NewSharedIndexInformer(
+			&cache.ListWatch{},
+			&v1.Pod{},
+			0,
+			podIndexers(),
+		),
+	}
+	c.podLabels = selector.NewLabelIndex(c.podInformer.GetIndexer())
+
+	for i := 0; i < n; i++ {
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      fmt.Sprintf("pod-%d", i),
+				Labels:    map[string]string{"app": fmt.Sprintf("app-%d", i%1000)},
+			},
+		}
+		c.podInformer.GetStore().Add(pod)
+	}
+
+	return c
+}
+
+func benchmarkSelector() selector.Selector {
+	return selector.And{
+		selector.InNamespaces("default"),
+		labels.SelectorFromSet(labels.Set{"app": "app-1"}),
+	}
+}
+
+func TestListPodIndexed(t *testing.T) {
+	c := newBenchmarkCache(2000)
+
+	pods, err := c.ListPod(benchmarkSelector())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods) != 2 {
+		t.Errorf("expect 2 pods; got %d", len(pods))
+	}
+
+	pods, err = c.ListPod(selector.Not(benchmarkSelector()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods) != 1998 {
+		t.Errorf("expect 1998 pods; got %d", len(pods))
+	}
+}
+
+func BenchmarkListPod(b *testing.B) {
+	c := newBenchmarkCache(20000)
+	s := benchmarkSelector()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.ListPod(s)
+	}
+}
+
+// BenchmarkListPodScan matches every pod, as ListPod does when the selector
+// cannot be narrowed down by the label index.
+func BenchmarkListPodScan(b *testing.B) {
+	c := newBenchmarkCache(20000)
+	s := benchmarkSelector()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, obj := range c.podInformer.GetStore().List() {
+			s.Matches(selector.PodLabels(obj.(*v1.Pod)))
+		}
+	}
+}
@@ -134,3 +134,18 @@ func (c *hostLabelCache) GetNodes(host string) []string {
 
 	return result
 }
+
+// ListHosts returns all the hosts running nodes
+func (c *hostLabelCache) ListHosts() []string {
+	c.Lock()
+	defer c.Unlock()
+
+	result := []string{}
+	for host, nodes := range c.hostToNodes {
+		if len(nodes) > 0 {
+			result = append(result, host)
+		}
+	}
+
+	return result
+}
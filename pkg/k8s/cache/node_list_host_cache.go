@@ -0,0 +1,90 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8scache
+
+import (
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/constants"
+	"k8s.io/api/core/v1"
+)
+
+// nodeListHostCache reads the host label from a list of nodes, and falls back
+// to another HostCache for the nodes not in the list.
+type nodeListHostCache struct {
+	nodeToHost  map[string]string
+	hostToNodes map[string][]string
+	fallback    algorithm.HostCache
+}
+
+// NewNodeListHostCache creates a HostCache from the host label of nodes. The
+// nodes not in the list, e.g. those already filtered out by kube-scheduler but
+// still running pods, are looked up in fallback.
+func NewNodeListHostCache(nodes []v1.Node, fallback algorithm.HostCache) algorithm.HostCache {
+	c := &nodeListHostCache{
+		nodeToHost:  make(map[string]string),
+		hostToNodes: make(map[string][]string),
+		fallback:    fallback,
+	}
+
+	for _, node := range nodes {
+		host := node.Labels[constants.HostLabel]
+		c.nodeToHost[node.Name] = host
+		if host != "" {
+			c.hostToNodes[host] = append(c.hostToNodes[host], node.Name)
+		}
+	}
+
+	return c
+}
+
+// GetHost returns the hostname of a given node
+func (c *nodeListHostCache) GetHost(node string) string {
+	if host, ok := c.nodeToHost[node]; ok {
+		return host
+	}
+	return c.fallback.GetHost(node)
+}
+
+// GetNodes returns all the nodes running on a given host
+func (c *nodeListHostCache) GetNodes(host string) []string {
+	result := append([]string{}, c.hostToNodes[host]...)
+
+	for _, node := range c.fallback.GetNodes(host) {
+		if _, ok := c.nodeToHost[node]; !ok {
+			result = append(result, node)
+		}
+	}
+
+	return result
+}
+
+// ListHosts returns all the hosts running the listed nodes or known to
+// fallback
+func (c *nodeListHostCache) ListHosts() []string {
+	result := []string{}
+	for host := range c.hostToNodes {
+		result = append(result, host)
+	}
+
+	for _, host := range c.fallback.ListHosts() {
+		if _, ok := c.hostToNodes[host]; !ok {
+			result = append(result, host)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,65 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8scache
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm/fake"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/constants"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNodeListHostCache(t *testing.T) {
+	nodes := []v1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "node1",
+				Labels: map[string]string{constants.HostLabel: "host2"},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "node2",
+				Labels: map[string]string{constants.HostLabel: "host1"},
+			},
+		},
+	}
+
+	// node1 has been migrated to host2 while the cache is not updated yet
+	cache := NewNodeListHostCache(nodes, fake.NodeCache(map[string]string{
+		"node1": "host1",
+		"node2": "host1",
+		"node3": "host1",
+	}))
+
+	if host := cache.GetHost("node1"); host != "host2" {
+		t.Errorf("expect cache.GetHost return %s; got %s", "host2", host)
+	}
+	if host := cache.GetHost("node3"); host != "host1" {
+		t.Errorf("expect cache.GetHost return %s; got %s", "host1", host)
+	}
+
+	result := cache.GetNodes("host1")
+	sort.Strings(result)
+	if expect := []string{"node2", "node3"}; !reflect.DeepEqual(result, expect) {
+		t.Errorf("expect cache.GetNodes return %s; got %s", expect, result)
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"time"
+)
+
+// DefaultRegistry is the registry of the metrics of the plugin
+var DefaultRegistry = NewRegistry()
+
+var (
+	// ExtenderLatency is the latency of the scheduler extender requests
+	ExtenderLatency = NewHistogramVec(DefaultRegistry,
+		"vsphere_plugin_extender_request_duration_seconds",
+		"Latency of scheduler extender requests by verb.",
+		DefaultBuckets, "verb")
+
+	// FilterRejectedNodes counts the nodes rejected by each filter in filter
+	// requests
+	FilterRejectedNodes = NewCounterVec(DefaultRegistry,
+		"vsphere_plugin_filter_rejected_nodes_total",
+		"Number of nodes rejected by each filter.",
+		"filter")
+
+	// QuerierUpdates counts the vSphere object updates received by the
+	// cached querier
+	QuerierUpdates = NewCounterVec(DefaultRegistry,
+		"vsphere_plugin_querier_updates_total",
+		"Number of vSphere object updates received by the cached querier by kind.",
+		"kind")
+
+	// DRSRuleOperations counts the DRS rule operations by result
+	DRSRuleOperations = NewCounterVec(DefaultRegistry,
+		"vsphere_plugin_drs_rule_operations_total",
+		"Number of DRS rule operations by operation and result.",
+		"operation", "result")
+
+	// VSphereLogins counts the logins to vCenter by result
+	VSphereLogins = NewCounterVec(DefaultRegistry,
+		"vsphere_plugin_vsphere_logins_total",
+		"Number of logins to vCenter by result.",
+		"result")
+
+	// VSphereWatchRestarts counts the restarts of the vSphere watchers after
+	// failures, such as a lost session
+	VSphereWatchRestarts = NewCounterVec(DefaultRegistry,
+		"vsphere_plugin_vsphere_watch_restarts_total",
+		"Number of restarts of vSphere watchers after failures by watcher.",
+		"watcher")
+
+	// NodeMatchAmbiguous counts the nodes or VMs matched ambiguously by a
+	// node matching strategy
+	NodeMatchAmbiguous = NewCounterVec(DefaultRegistry,
+		"vsphere_plugin_node_match_ambiguous_total",
+		"Number of ambiguous matches between nodes and VMs by matcher.",
+		"matcher")
+
+	// VSphereTaskLatency is the latency of vCenter tasks
+	VSphereTaskLatency = NewHistogramVec(DefaultRegistry,
+		"vsphere_plugin_vsphere_task_duration_seconds",
+		"Latency of vCenter tasks by operation.",
+		DefaultBuckets, "operation")
+)
+
+// SinceInSeconds returns the seconds elapsed since start
+func SinceInSeconds(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
+
+// Result returns the result label of err
+func Result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
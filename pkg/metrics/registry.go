@@ -0,0 +1,290 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Collector is a metric that writes itself in Prometheus text format
+type Collector interface {
+	Write(w io.Writer)
+}
+
+// Registry is a set of Collectors
+type Registry struct {
+	sync.Mutex
+	collectors map[string]Collector
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		collectors: make(map[string]Collector),
+	}
+}
+
+// Register adds collector to the registry by name, the collector registered
+// before with the same name is replaced.
+func (r *Registry) Register(name string, collector Collector) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.collectors[name] = collector
+}
+
+// Write writes all the collectors in Prometheus text format, sorted by name
+func (r *Registry) Write(w io.Writer) {
+	r.Lock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	collectors := make([]Collector, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		collectors = append(collectors, r.collectors[name])
+	}
+	r.Unlock()
+
+	for _, collector := range collectors {
+		collector.Write(w)
+	}
+}
+
+// Handler returns a http.Handler that serves the metrics of the registry
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		buf := bufio.NewWriter(w)
+		r.Write(buf)
+		buf.Flush()
+	})
+}
+
+// CounterVec is a counter partitioned by labels
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	sync.Mutex
+	values map[string]float64
+}
+
+// NewCounterVec creates a CounterVec and registers it in registry
+func NewCounterVec(registry *Registry, name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64),
+	}
+	registry.Register(name, c)
+	return c
+}
+
+// Inc increments the counter of labelValues by 1
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v to the counter of labelValues
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	key := labelKey(c.labels, labelValues)
+
+	c.Lock()
+	defer c.Unlock()
+	c.values[key] += v
+}
+
+// Write implements Collector
+func (c *CounterVec) Write(w io.Writer) {
+	c.Lock()
+	defer c.Unlock()
+
+	writeHeader(w, c.name, c.help, "counter")
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, key, formatFloat(c.values[key]))
+	}
+}
+
+// GaugeFunc is a gauge whose value is read from a function on collection
+type GaugeFunc struct {
+	name string
+	help string
+	f    func() float64
+}
+
+// NewGaugeFunc creates a GaugeFunc and registers it in registry
+func NewGaugeFunc(registry *Registry, name, help string, f func() float64) *GaugeFunc {
+	g := &GaugeFunc{
+		name: name,
+		help: help,
+		f:    f,
+	}
+	registry.Register(name, g)
+	return g
+}
+
+// Write implements Collector
+func (g *GaugeFunc) Write(w io.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.f()))
+}
+
+// HistogramVec is a histogram partitioned by labels
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	sync.Mutex
+	values map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// DefaultBuckets are the default histogram buckets in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// NewHistogramVec creates a HistogramVec and registers it in registry. The
+// buckets are upper bounds in increasing order.
+func NewHistogramVec(registry *Registry, name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		values:  make(map[string]*histogram),
+	}
+	registry.Register(name, h)
+	return h
+}
+
+// Observe adds an observation v to the histogram of labelValues
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	key := labelKey(h.labels, labelValues)
+
+	h.Lock()
+	defer h.Unlock()
+
+	value, ok := h.values[key]
+	if !ok {
+		value = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = value
+	}
+
+	for i, bound := range h.buckets {
+		if v <= bound {
+			value.counts[i]++
+		}
+	}
+	value.sum += v
+	value.count++
+}
+
+// Write implements Collector
+func (h *HistogramVec) Write(w io.Writer) {
+	h.Lock()
+	defer h.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := h.values[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLabel(key, "le", formatFloat(bound)), value.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLabel(key, "le", "+Inf"), value.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, key, formatFloat(value.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, key, value.count)
+	}
+}
+
+func writeHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+// labelKey formats the labels as `{name="value",...}`, which is also used as
+// the key of the value. Missing values are left empty.
+func labelKey(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = formatLabel(name, value)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// withLabel appends a label to the formatted labels of key
+func withLabel(key, name, value string) string {
+	pair := formatLabel(name, value)
+	if key == "" {
+		return "{" + pair + "}"
+	}
+	return key[:len(key)-1] + "," + pair + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabel(name, value string) string {
+	return name + `="` + labelEscaper.Replace(value) + `"`
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,60 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	registry := NewRegistry()
+
+	counter := NewCounterVec(registry, "test_counter", "A counter.", "operation", "result")
+	counter.Inc("apply", "success")
+	counter.Add(2, "apply", "success")
+	counter.Inc("delete", "fail\"ure")
+
+	NewGaugeFunc(registry, "test_gauge", "A gauge.", func() float64 { return 42 })
+
+	histogram := NewHistogramVec(registry, "test_histogram", "A histogram.", []float64{0.1, 1}, "verb")
+	histogram.Observe(0.05, "filter")
+	histogram.Observe(0.5, "filter")
+	histogram.Observe(5, "filter")
+
+	var buf bytes.Buffer
+	registry.Write(&buf)
+
+	expect := `# HELP test_counter A counter.
+# TYPE test_counter counter
+test_counter{operation="apply",result="success"} 3
+test_counter{operation="delete",result="fail\"ure"} 1
+# HELP test_gauge A gauge.
+# TYPE test_gauge gauge
+test_gauge 42
+# HELP test_histogram A histogram.
+# TYPE test_histogram histogram
+test_histogram_bucket{verb="filter",le="0.1"} 1
+test_histogram_bucket{verb="filter",le="1"} 2
+test_histogram_bucket{verb="filter",le="+Inf"} 3
+test_histogram_sum{verb="filter"} 5.55
+test_histogram_count{verb="filter"} 3
+`
+	if buf.String() != expect {
+		t.Errorf("expect:\n%s\ngot:\n%s", expect, buf.String())
+	}
+}
@@ -0,0 +1,273 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package selector
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+const (
+	// NamespaceIndexName indexes objects by namespace
+	NamespaceIndexName = "namespace"
+
+	// LabelIndexName indexes objects by the key=value pairs of their labels
+	LabelIndexName = "label"
+
+	// LabelKeyIndexName indexes objects by the keys of their labels
+	LabelKeyIndexName = "labelKey"
+)
+
+// IndexNamespace is the index function of NamespaceIndexName
+func IndexNamespace(obj interface{}) ([]string, error) {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	return []string{m.GetNamespace()}, nil
+}
+
+// IndexLabels is the index function of LabelIndexName
+func IndexLabels(obj interface{}) ([]string, error) {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(m.GetLabels()))
+	for k, v := range m.GetLabels() {
+		result = append(result, labelIndexValue(k, v))
+	}
+	return result, nil
+}
+
+// IndexLabelKeys is the index function of LabelKeyIndexName
+func IndexLabelKeys(obj interface{}) ([]string, error) {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(m.GetLabels()))
+	for k := range m.GetLabels() {
+		result = append(result, k)
+	}
+	return result, nil
+}
+
+func labelIndexValue(key, value string) string {
+	return key + "=" + value
+}
+
+// KeyIndexer looks up the keys of the objects by index, like the Indexer of
+// an informer.
+type KeyIndexer interface {
+	IndexKeys(indexName, indexKey string) ([]string, error)
+}
+
+// keySet is a set of object keys
+type keySet map[string]struct{}
+
+// LabelIndex looks up the keys of the objects a Selector may match in the
+// namespace and label indexes of a KeyIndexer, so that they needn't be
+// matched one by one. The indexes are kept by the KeyIndexer together with
+// its store, with the index functions IndexNamespace, IndexLabels and
+// IndexLabelKeys.
+type LabelIndex struct {
+	indexer KeyIndexer
+}
+
+// NewLabelIndex creates a LabelIndex on the indexes of indexer
+func NewLabelIndex(indexer KeyIndexer) *LabelIndex {
+	return &LabelIndex{indexer: indexer}
+}
+
+// Candidates returns the keys of the objects s may match. It returns false if
+// the index cannot narrow s down, then all objects are candidates.
+func (i *LabelIndex) Candidates(s Selector) ([]string, bool) {
+	set, ok := i.candidates(s)
+	if !ok {
+		return nil, false
+	}
+
+	result := make([]string, 0, len(set))
+	for key := range set {
+		result = append(result, key)
+	}
+	return result, true
+}
+
+// candidates returns the keys s may match, or false if s cannot be narrowed
+// down.
+func (i *LabelIndex) candidates(s Selector) (keySet, bool) {
+	switch s := s.(type) {
+	case And:
+		var result keySet
+		narrowed := false
+		narrow := func(children []Selector) {
+			for _, child := range children {
+				set, ok := i.candidates(child)
+				if !ok {
+					continue
+				}
+				if !narrowed {
+					result, narrowed = set, true
+				} else {
+					result = intersect(result, set)
+				}
+			}
+		}
+
+		// A namespace usually holds many more objects than the labels select,
+		// so the namespaces only narrow s down if nothing else does. The
+		// candidates are matched against s anyway.
+		var others, namespaces []Selector
+		for _, child := range s {
+			if _, ok := child.(inNamespaces); ok {
+				namespaces = append(namespaces, child)
+			} else {
+				others = append(others, child)
+			}
+		}
+		if narrow(others); !narrowed {
+			narrow(namespaces)
+		}
+		return result, narrowed
+
+	case Or:
+		if len(s) == 0 {
+			return nil, false
+		}
+		var sets []keySet
+		for _, child := range s {
+			set, ok := i.candidates(child)
+			if !ok {
+				return nil, false
+			}
+			sets = append(sets, set)
+		}
+		return union(sets...), true
+
+	case inNamespaces:
+		var sets []keySet
+		for namespace := range s {
+			set, ok := i.lookup(NamespaceIndexName, namespace)
+			if !ok {
+				return nil, false
+			}
+			sets = append(sets, set)
+		}
+		return union(sets...), true
+
+	case labels.Selector:
+		requirements, selectable := s.Requirements()
+		if !selectable {
+			// matches nothing
+			return keySet{}, true
+		}
+
+		var result keySet
+		narrowed := false
+		for _, r := range requirements {
+			set, ok := i.candidatesOfRequirement(&r)
+			if !ok {
+				continue
+			}
+			if !narrowed {
+				result, narrowed = set, true
+			} else {
+				result = intersect(result, set)
+			}
+		}
+		return result, narrowed
+	}
+
+	return nil, false
+}
+
+// candidatesOfRequirement returns the keys matching r, or false if r cannot
+// be narrowed down by the index, like NotIn.
+func (i *LabelIndex) candidatesOfRequirement(r *labels.Requirement) (keySet, bool) {
+	switch r.Operator() {
+	case selection.Equals, selection.DoubleEquals, selection.In:
+		var sets []keySet
+		for value := range r.Values() {
+			set, ok := i.lookup(LabelIndexName, labelIndexValue(r.Key(), value))
+			if !ok {
+				return nil, false
+			}
+			sets = append(sets, set)
+		}
+		return union(sets...), true
+
+	case selection.Exists:
+		return i.lookup(LabelKeyIndexName, r.Key())
+	}
+
+	return nil, false
+}
+
+// lookup returns the keys of the objects indexed by value in the index of
+// name, or false if there is no such index.
+func (i *LabelIndex) lookup(name, value string) (keySet, bool) {
+	keys, err := i.indexer.IndexKeys(name, value)
+	if err != nil {
+		return nil, false
+	}
+
+	set := make(keySet, len(keys))
+	for _, key := range keys {
+		set[key] = struct{}{}
+	}
+	return set, true
+}
+
+func intersect(a, b keySet) keySet {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+
+	result := keySet{}
+	for key := range a {
+		if _, ok := b[key]; ok {
+			result[key] = struct{}{}
+		}
+	}
+	return result
+}
+
+// union returns the union of sets. A single set is returned as is.
+func union(sets ...keySet) keySet {
+	switch len(sets) {
+	case 0:
+		return keySet{}
+	case 1:
+		if sets[0] == nil {
+			return keySet{}
+		}
+		return sets[0]
+	}
+
+	result := keySet{}
+	for _, set := range sets {
+		for key := range set {
+			result[key] = struct{}{}
+		}
+	}
+	return result
+}
@@ -0,0 +1,171 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package selector
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// newIndexer creates an informer Indexer with the label and namespace indexes
+func newIndexer() cache.Indexer {
+	return cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{
+		NamespaceIndexName: IndexNamespace,
+		LabelIndexName:     IndexLabels,
+		LabelKeyIndexName:  IndexLabelKeys,
+	})
+}
+
+func newPod(namespace, name string, podLabels map[string]string) *v1.Pod {
+	return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: podLabels}}
+}
+
+func TestLabelIndexCandidates(t *testing.T) {
+	type object struct {
+		namespace string
+		labels    map[string]string
+	}
+	objects := map[string]object{
+		"a/web-0": {"a", map[string]string{"app": "web", "tier": "front"}},
+		"a/web-1": {"a", map[string]string{"app": "web"}},
+		"a/db-0":  {"a", map[string]string{"app": "db", "tier": "back"}},
+		"b/web-0": {"b", map[string]string{"app": "web"}},
+		"b/none":  {"b", nil},
+	}
+
+	indexer := newIndexer()
+	for key, obj := range objects {
+		_, name, _ := cache.SplitMetaNamespaceKey(key)
+		indexer.Add(newPod(obj.namespace, name, obj.labels))
+	}
+	index := NewLabelIndex(indexer)
+
+	mustParse := func(s string) labels.Selector {
+		selector, err := labels.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return selector
+	}
+
+	tests := []struct {
+		desc     string
+		selector Selector
+		narrowed bool
+	}{
+		{"equals", mustParse("app=web"), true},
+		{"in", mustParse("app in (web,db)"), true},
+		{"exists", mustParse("tier"), true},
+		{"not in", mustParse("app notin (web)"), false},
+		{"equals and not in", mustParse("app=web,tier notin (front)"), true},
+		{"everything", labels.Everything(), false},
+		{"nothing", labels.Nothing(), true},
+		{"namespace", InNamespaces("a"), true},
+		{"and", And{InNamespaces("b"), mustParse("app=web")}, true},
+		{"and of namespaces", And{InNamespaces("a", "b"), InNamespaces("b")}, true},
+		{"and with everything", And{InNamespaces("a"), labels.Everything()}, true},
+		{"and with not", And{Not(mustParse("app=db")), mustParse("app=web")}, true},
+		{"empty and", And{}, false},
+		{"or", Or{mustParse("app=db"), mustParse("tier=front")}, true},
+		{"or with not", Or{mustParse("app=db"), Not(mustParse("app=db"))}, false},
+	}
+
+	for _, test := range tests {
+		var expect []string
+		for key, obj := range objects {
+			l := &namespacedLabels{Set: labels.Set(obj.labels), namespace: obj.namespace}
+			if test.selector.Matches(l) {
+				expect = append(expect, key)
+			}
+		}
+		sort.Strings(expect)
+
+		keys, narrowed := index.Candidates(test.selector)
+		if narrowed != test.narrowed {
+			t.Errorf("[%s] expect narrowed=%v; got %v", test.desc, test.narrowed, narrowed)
+		}
+		if !narrowed {
+			continue
+		}
+
+		var result []string
+		for _, key := range keys {
+			obj := objects[key]
+			l := &namespacedLabels{Set: labels.Set(obj.labels), namespace: obj.namespace}
+			if test.selector.Matches(l) {
+				result = append(result, key)
+			}
+		}
+		sort.Strings(result)
+
+		if !reflect.DeepEqual(result, expect) {
+			t.Errorf("[%s] expect %v; got %v", test.desc, expect, result)
+		}
+	}
+}
+
+func TestLabelIndexUpdate(t *testing.T) {
+	indexer := newIndexer()
+	index := NewLabelIndex(indexer)
+	indexer.Add(newPod("a", "web-0", map[string]string{"app": "web"}))
+	indexer.Update(newPod("a", "web-0", map[string]string{"app": "db"}))
+
+	keys, _ := index.Candidates(labels.SelectorFromSet(labels.Set{"app": "web"}))
+	if len(keys) != 0 {
+		t.Errorf("expect no candidates of app=web after update; got %v", keys)
+	}
+
+	indexer.Delete(newPod("a", "web-0", nil))
+
+	keys, _ = index.Candidates(labels.SelectorFromSet(labels.Set{"app": "db"}))
+	if len(keys) != 0 {
+		t.Errorf("expect no candidates of app=db after delete; got %v", keys)
+	}
+	if keys, _ = index.Candidates(InNamespaces("a")); len(keys) != 0 {
+		t.Errorf("expect no candidates in namespace a after delete; got %v", keys)
+	}
+}
+
+func TestLabelIndexWithoutIndexes(t *testing.T) {
+	index := NewLabelIndex(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}))
+	if _, narrowed := index.Candidates(labels.SelectorFromSet(labels.Set{"app": "web"})); narrowed {
+		t.Errorf("expect no narrowing without the label indexes")
+	}
+}
+
+func BenchmarkLabelIndexCandidates(b *testing.B) {
+	indexer := newIndexer()
+	for i := 0; i < 20000; i++ {
+		indexer.Add(newPod("ns", fmt.Sprintf("pod-%d", i),
+			map[string]string{"app": fmt.Sprintf("app-%d", i%1000)}))
+	}
+	index := NewLabelIndex(indexer)
+
+	s := And{InNamespaces("ns"), labels.SelectorFromSet(labels.Set{"app": "app-1"})}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index.Candidates(s)
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package selector
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/constants"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// namespacedLabels is the labels of an object together with its namespace
+type namespacedLabels struct {
+	labels.Set
+	namespace string
+}
+
+// namespaced is implemented by labels that know the namespace of the object
+type namespaced interface {
+	GetNamespace() string
+}
+
+func (l *namespacedLabels) GetNamespace() string {
+	return l.namespace
+}
+
+// PodLabels returns the labels of pod to match against a Selector, which also
+// carries the namespace of the pod for namespace selectors.
+func PodLabels(pod *v1.Pod) labels.Labels {
+	return &namespacedLabels{
+		Set:       labels.Set(pod.GetLabels()),
+		namespace: pod.Namespace,
+	}
+}
+
+type inNamespaces map[string]struct{}
+
+// InNamespaces returns a Selector which matches objects in any of the
+// namespaces. Labels without namespace information never match.
+func InNamespaces(namespaces ...string) Selector {
+	s := make(inNamespaces, len(namespaces))
+	for _, namespace := range namespaces {
+		s[namespace] = struct{}{}
+	}
+	return s
+}
+
+func (s inNamespaces) Matches(l labels.Labels) bool {
+	n, ok := l.(namespaced)
+	if !ok {
+		return false
+	}
+
+	_, ok = s[n.GetNamespace()]
+	return ok
+}
+
+// NamespaceLister lists the namespaces matching a label selector, to resolve
+// namespace selectors.
+type NamespaceLister interface {
+	ListNamespaces(selector labels.Selector) ([]string, error)
+}
+
+// TermKind is the kind of a pod affinity term, which together with the index
+// of the term in the pod spec keys its namespace selector
+type TermKind string
+
+const (
+	// AffinityTerm is a required pod affinity term
+	AffinityTerm TermKind = "affinity"
+	// AntiAffinityTerm is a required pod anti-affinity term
+	AntiAffinityTerm TermKind = "anti-affinity"
+	// PreferredAffinityTerm is a preferred pod affinity term
+	PreferredAffinityTerm TermKind = "preferred-affinity"
+	// PreferredAntiAffinityTerm is a preferred pod anti-affinity term
+	PreferredAntiAffinityTerm TermKind = "preferred-anti-affinity"
+)
+
+// TermKey returns the key of the namespace selector of the i-th term of kind,
+// like anti-affinity-0.
+func TermKey(kind TermKind, i int) string {
+	return fmt.Sprintf("%s-%d", kind, i)
+}
+
+// namespaceSelector returns the namespace selector of the i-th pod affinity
+// term of kind of pod, or nil if the term has none.
+func namespaceSelector(pod *v1.Pod, kind TermKind, i int) (labels.Selector, error) {
+	value, ok := pod.Annotations[constants.NamespaceSelectorAnnotation]
+	if !ok {
+		return nil, nil
+	}
+
+	var selectors map[string]metav1.LabelSelector
+	if err := json.Unmarshal([]byte(value), &selectors); err != nil {
+		return nil, fmt.Errorf("invalid annotation %s: %s", constants.NamespaceSelectorAnnotation, err)
+	}
+
+	s, ok := selectors[TermKey(kind, i)]
+	if !ok {
+		return nil, nil
+	}
+	return metav1.LabelSelectorAsSelector(&s)
+}
+
+// ForTerm returns the Selector of the i-th pod affinity term of kind of pod.
+// Like Kubernetes, the term applies to the namespaces given, and to those
+// matching its namespace selector, which are listed by namespaceLister. It
+// applies to the namespace of pod if neither is given, and to all namespaces
+// if the namespace selector is empty.
+func ForTerm(pod *v1.Pod, kind TermKind, i int, term *v1.PodAffinityTerm, namespaceLister NamespaceLister) (Selector, error) {
+	s, err := metav1.LabelSelectorAsSelector(term.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	nsSelector, err := namespaceSelector(pod, kind, i)
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces := term.Namespaces
+	switch {
+	case nsSelector == nil:
+		if len(namespaces) == 0 {
+			namespaces = []string{pod.Namespace}
+		}
+
+	case nsSelector.Empty():
+		return s, nil
+
+	case namespaceLister == nil:
+		return nil, fmt.Errorf("no namespace lister to resolve the namespace selector %s", nsSelector)
+
+	default:
+		selected, err := namespaceLister.ListNamespaces(nsSelector)
+		if err != nil {
+			return nil, err
+		}
+		namespaces = append(append([]string{}, namespaces...), selected...)
+	}
+
+	return And{InNamespaces(namespaces...), s}, nil
+}
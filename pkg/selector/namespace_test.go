@@ -0,0 +1,189 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package selector
+
+import (
+	"testing"
+
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/constants"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// namespaceLister maps the namespace names to their labels
+type namespaceLister map[string]labels.Set
+
+func (l namespaceLister) ListNamespaces(s labels.Selector) ([]string, error) {
+	result := []string{}
+	for name, set := range l {
+		if s.Matches(set) {
+			result = append(result, name)
+		}
+	}
+	return result, nil
+}
+
+func TestForTerm(t *testing.T) {
+	lister := namespaceLister{
+		"a": labels.Set{"team": "a"},
+		"b": labels.Set{"team": "b"},
+		"c": labels.Set{"team": "c"},
+	}
+
+	newPod := func(namespace, nsSelector string) *v1.Pod {
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "web-0"}}
+		if nsSelector != "" {
+			pod.Annotations = map[string]string{
+				constants.NamespaceSelectorAnnotation: `{"affinity-0":` + nsSelector + `}`,
+			}
+		}
+		return pod
+	}
+
+	tests := []struct {
+		desc       string
+		pod        *v1.Pod
+		namespaces []string
+		lister     NamespaceLister
+		expect     map[string]bool
+	}{
+		{
+			desc:   "default namespace",
+			pod:    newPod("a", ""),
+			expect: map[string]bool{"a": true, "b": false, "c": false},
+		},
+		{
+			desc:       "explicit namespaces",
+			pod:        newPod("a", ""),
+			namespaces: []string{"b", "c"},
+			expect:     map[string]bool{"a": false, "b": true, "c": true},
+		},
+		{
+			desc:   "namespace selector",
+			pod:    newPod("a", `{"matchLabels":{"team":"b"}}`),
+			lister: lister,
+			expect: map[string]bool{"a": false, "b": true, "c": false},
+		},
+		{
+			desc:       "namespace selector and explicit namespaces",
+			pod:        newPod("a", `{"matchExpressions":[{"key":"team","operator":"In","values":["b"]}]}`),
+			namespaces: []string{"c"},
+			lister:     lister,
+			expect:     map[string]bool{"a": false, "b": true, "c": true},
+		},
+		{
+			desc:   "empty namespace selector",
+			pod:    newPod("a", `{}`),
+			expect: map[string]bool{"a": true, "b": true, "c": true},
+		},
+	}
+
+	for _, test := range tests {
+		term := &v1.PodAffinityTerm{
+			LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			Namespaces:    test.namespaces,
+		}
+		s, err := ForTerm(test.pod, AffinityTerm, 0, term, test.lister)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.desc, err)
+			continue
+		}
+
+		for namespace, expect := range test.expect {
+			pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Labels:    map[string]string{"app": "web"},
+			}}
+			if got := s.Matches(PodLabels(pod)); got != expect {
+				t.Errorf("%s: expect pod in namespace %s matched %v; got %v", test.desc, namespace, expect, got)
+			}
+		}
+	}
+}
+
+func TestForTermErrors(t *testing.T) {
+	term := &v1.PodAffinityTerm{
+		LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+	}
+
+	for _, annotation := range []string{`{"affinity-0":{"matchLabels":{"team":"b"}}}`, `not json`} {
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "a",
+			Annotations: map[string]string{constants.NamespaceSelectorAnnotation: annotation},
+		}}
+		if _, err := ForTerm(pod, AffinityTerm, 0, term, nil); err == nil {
+			t.Errorf("expect error for annotation %q without namespace lister", annotation)
+		}
+	}
+}
+
+func TestForTermPerTerm(t *testing.T) {
+	lister := namespaceLister{
+		"a": labels.Set{"team": "a"},
+		"b": labels.Set{"team": "b"},
+	}
+
+	// the second anti-affinity term applies to all namespaces, the first one
+	// and the affinity term with the same index to the namespace of the pod
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   "a",
+		Annotations: map[string]string{constants.NamespaceSelectorAnnotation: `{"anti-affinity-1":{}}`},
+	}}
+	term := &v1.PodAffinityTerm{
+		LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+	}
+
+	tests := []struct {
+		kind   TermKind
+		i      int
+		expect map[string]bool
+	}{
+		{AntiAffinityTerm, 0, map[string]bool{"a": true, "b": false}},
+		{AntiAffinityTerm, 1, map[string]bool{"a": true, "b": true}},
+		{AffinityTerm, 1, map[string]bool{"a": true, "b": false}},
+	}
+
+	for _, test := range tests {
+		s, err := ForTerm(pod, test.kind, test.i, term, lister)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", TermKey(test.kind, test.i), err)
+			continue
+		}
+
+		for namespace, expect := range test.expect {
+			other := &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Labels:    map[string]string{"app": "web"},
+			}}
+			if got := s.Matches(PodLabels(other)); got != expect {
+				t.Errorf("%s: expect pod in namespace %s matched %v; got %v",
+					TermKey(test.kind, test.i), namespace, expect, got)
+			}
+		}
+	}
+}
+
+func TestInNamespacesWithoutNamespace(t *testing.T) {
+	s := InNamespaces("a")
+	if s.Matches(labels.Set{"app": "web"}) {
+		t.Errorf("expect labels without namespace not matched")
+	}
+	if !s.Matches(&namespacedLabels{Set: labels.Set{"app": "web"}, namespace: "a"}) {
+		t.Errorf("expect labels in namespace a matched")
+	}
+}
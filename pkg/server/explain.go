@@ -0,0 +1,143 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/k8s/cache"
+	"k8s.io/api/core/v1"
+)
+
+// ExplainArgs is the request of the explain endpoint. Either Pod, or the
+// Namespace and Name of a cached pod, is required. All the cached nodes are
+// filtered if NodeNames is empty.
+type ExplainArgs struct {
+	Pod       *v1.Pod  `json:"pod,omitempty"`
+	Namespace string   `json:"namespace,omitempty"`
+	Name      string   `json:"name,omitempty"`
+	NodeNames []string `json:"nodeNames,omitempty"`
+}
+
+// ExplainResult is the response of the explain endpoint
+type ExplainResult struct {
+	// Pod is the namespace/name of the explained pod
+	Pod string `json:"pod"`
+
+	// NodeNames are the nodes passing all filters, it is empty if a filter
+	// fails with an error
+	NodeNames []string `json:"nodeNames"`
+
+	// Error is the error of the failing filter
+	Error string `json:"error,omitempty"`
+
+	// Hosts maps the nodes to the physical hosts used by the filters
+	Hosts map[string]string `json:"hosts"`
+
+	// Filters records the decision of every filter in order
+	Filters []algorithm.FilterExplanation `json:"filters"`
+}
+
+// ExplainHandler serves a dry run of the filters, explaining why a pod can or
+// cannot be scheduled to nodes.
+type ExplainHandler struct {
+	Filter     algorithm.Filter
+	PodGetter  algorithm.PodGetter
+	NodeLister k8scache.NodeLister
+	HostCache  algorithm.HostCache
+}
+
+// Routes returns the debug routes
+func (h *ExplainHandler) Routes() []Route {
+	return []Route{
+		{Method: http.MethodPost, Path: "explain", Handler: h.processExplain},
+	}
+}
+
+func (h *ExplainHandler) processExplain(w http.ResponseWriter, r *http.Request) {
+	log.Printf("process explain %s", r.URL.Path)
+
+	defer r.Body.Close()
+
+	var args ExplainArgs
+	if err := decodePodArgs(r.Body, &args, func() *v1.Pod { return args.Pod }); err != nil {
+		log.Printf("[ERROR] decode error: %s", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pod := args.Pod
+	if pod == nil {
+		if args.Name == "" {
+			http.Error(w, "pod or name is required", http.StatusBadRequest)
+			return
+		}
+
+		var err error
+		if pod, err = h.PodGetter.GetPod(args.Namespace, args.Name); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if pod == nil {
+			http.Error(w, fmt.Sprintf("pod %s/%s not found", args.Namespace, args.Name),
+				http.StatusNotFound)
+			return
+		}
+	}
+
+	nodeNames := args.NodeNames
+	if len(nodeNames) == 0 {
+		nodes, err := h.NodeLister.ListNode()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, node := range nodes {
+			nodeNames = append(nodeNames, node.Name)
+		}
+	}
+
+	resp := ExplainResult{
+		Pod:     pod.Namespace + "/" + pod.Name,
+		Hosts:   make(map[string]string),
+		Filters: algorithm.Explain(h.Filter, pod, nodeNames),
+	}
+
+	for _, node := range nodeNames {
+		resp.Hosts[node] = h.HostCache.GetHost(node)
+	}
+
+	resp.NodeNames = nodeNames
+	if n := len(resp.Filters); n > 0 {
+		if last := resp.Filters[n-1]; last.Error != "" {
+			resp.NodeNames = nil
+			resp.Error = last.Error
+		} else {
+			resp.NodeNames = last.After
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(&resp); err != nil {
+		log.Printf("[ERROR] encode response %s", err)
+	}
+}
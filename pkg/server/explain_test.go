@@ -0,0 +1,248 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm/fake"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm/filters"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type podGetter []*v1.Pod
+
+func (g podGetter) GetPod(namespace, name string) (*v1.Pod, error) {
+	for _, pod := range g {
+		if pod.Namespace == namespace && pod.Name == name {
+			return pod, nil
+		}
+	}
+	return nil, nil
+}
+
+type nodeLister []string
+
+func (l nodeLister) ListNode() ([]*v1.Node, error) {
+	result := []*v1.Node{}
+	for _, name := range l {
+		result = append(result, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}})
+	}
+	return result, nil
+}
+
+// errorFilter passes the first node and fails with an error
+type errorFilter struct{}
+
+func (errorFilter) Filter(pod *v1.Pod, nodes []string) ([]string, algorithm.FailedNodes, error) {
+	return nodes[:1], nil, errors.New("filter failed")
+}
+
+// matchedPodsErrorFilter passes all nodes and fails to list its matched pods
+type matchedPodsErrorFilter struct{}
+
+func (matchedPodsErrorFilter) Filter(pod *v1.Pod, nodes []string) ([]string, algorithm.FailedNodes, error) {
+	return nodes, nil, nil
+}
+
+func (matchedPodsErrorFilter) Name() string {
+	return "matchedPodsError"
+}
+
+func (matchedPodsErrorFilter) MatchedPods(pod *v1.Pod) ([]*v1.Pod, error) {
+	return nil, errors.New("list failed")
+}
+
+func TestExplain(t *testing.T) {
+	pending := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "web-1",
+			Labels:    map[string]string{"app": "web"},
+		},
+		Spec: v1.PodSpec{
+			Affinity: &v1.Affinity{
+				PodAntiAffinity: &v1.PodAntiAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{
+						{
+							LabelSelector: &metav1.LabelSelector{
+								MatchLabels: map[string]string{"app": "web"},
+							},
+							TopologyKey: filters.HostTopologyKey,
+						},
+					},
+				},
+			},
+		},
+	}
+	running := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "web-0",
+			Labels:    map[string]string{"app": "web"},
+		},
+		Spec: v1.PodSpec{
+			NodeName: "node1",
+		},
+	}
+	pods := []*v1.Pod{pending, running}
+
+	podLister := fake.NewPodLister(pods)
+	nodeCache := fake.NodeCache(map[string]string{
+		"node1": "esx-01",
+		"node2": "esx-01",
+		"node3": "esx-02",
+	})
+
+	explain := &ExplainHandler{
+		Filter: algorithm.Filters{
+			filters.NewPodAffinity(podLister, nil, nodeCache, nil),
+			filters.NewPodAntiAffinity(podLister, nil, nodeCache, nil),
+		},
+		PodGetter:  podGetter(pods),
+		NodeLister: nodeLister{"node1", "node2", "node3"},
+		HostCache:  nodeCache,
+	}
+
+	router := NewRouter("/scheduler")
+	router.Register("debug", explain.Routes()...)
+
+	post := func(args ExplainArgs) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(args)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/scheduler/debug/explain", bytes.NewReader(body)))
+		return w
+	}
+
+	w := post(ExplainArgs{Namespace: "default", Name: "web-1"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expect status %d; got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+
+	var result ExplainResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Pod != "default/web-1" {
+		t.Errorf("expect pod default/web-1; got %s", result.Pod)
+	}
+	if expect := []string{"node3"}; !reflect.DeepEqual(result.NodeNames, expect) {
+		t.Errorf("expect nodeNames %s; got %s", expect, result.NodeNames)
+	}
+	if result.Hosts["node2"] != "esx-01" {
+		t.Errorf("expect node2 on esx-01; got %q", result.Hosts["node2"])
+	}
+	if len(result.Filters) != 2 {
+		t.Fatalf("expect 2 filters; got %+v", result.Filters)
+	}
+
+	anti := result.Filters[1]
+	if anti.Name != "podAntiAffinity" {
+		t.Errorf("expect filter podAntiAffinity; got %s", anti.Name)
+	}
+	if expect := []string{"node1", "node2", "node3"}; !reflect.DeepEqual(anti.Before, expect) {
+		t.Errorf("expect before %s; got %s", expect, anti.Before)
+	}
+	if expect := []string{"default/web-0 on node1"}; !reflect.DeepEqual(anti.MatchedPods, expect) {
+		t.Errorf("expect matched pods %s; got %s", expect, anti.MatchedPods)
+	}
+	if _, ok := anti.FailedNodes["node2"]; !ok {
+		t.Errorf("expect node2 failed; got %v", anti.FailedNodes)
+	}
+
+	if w := post(ExplainArgs{Namespace: "default", Name: "web-2"}); w.Code != http.StatusNotFound {
+		t.Errorf("expect status %d for unknown pod; got %d", http.StatusNotFound, w.Code)
+	}
+	if w := post(ExplainArgs{}); w.Code != http.StatusBadRequest {
+		t.Errorf("expect status %d without pod; got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestExplainFilterError(t *testing.T) {
+	explain := &ExplainHandler{
+		Filter:     algorithm.Filters{errorFilter{}},
+		NodeLister: nodeLister{"node1", "node2"},
+		HostCache:  fake.NodeCache(map[string]string{"node1": "esx-01", "node2": "esx-02"}),
+	}
+
+	router := NewRouter("/scheduler")
+	router.Register("debug", explain.Routes()...)
+
+	body, _ := json.Marshal(ExplainArgs{Pod: &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-1"}}})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/scheduler/debug/explain", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expect status %d; got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+
+	var result ExplainResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.NodeNames) != 0 {
+		t.Errorf("expect no nodeNames after a filter error; got %s", result.NodeNames)
+	}
+	if result.Error != "filter failed" {
+		t.Errorf("expect error %q; got %q", "filter failed", result.Error)
+	}
+	if len(result.Filters) != 1 || result.Filters[0].Name != "server.errorFilter" {
+		t.Errorf("expect filter server.errorFilter; got %+v", result.Filters)
+	}
+}
+
+func TestExplainMatchedPodsError(t *testing.T) {
+	explain := &ExplainHandler{
+		Filter:     algorithm.Filters{matchedPodsErrorFilter{}},
+		NodeLister: nodeLister{"node1", "node2"},
+		HostCache:  fake.NodeCache(map[string]string{"node1": "esx-01", "node2": "esx-02"}),
+	}
+
+	router := NewRouter("/scheduler")
+	router.Register("debug", explain.Routes()...)
+
+	body, _ := json.Marshal(ExplainArgs{Pod: &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-1"}}})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/scheduler/debug/explain", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expect status %d; got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+
+	var result ExplainResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+
+	if expect := []string{"node1", "node2"}; !reflect.DeepEqual(result.NodeNames, expect) {
+		t.Errorf("expect nodeNames %s; got %s", expect, result.NodeNames)
+	}
+	if result.Error != "" {
+		t.Errorf("expect no error; got %q", result.Error)
+	}
+	if len(result.Filters) != 1 || result.Filters[0].MatchedPodsError != "list failed" {
+		t.Errorf("expect matched pods error %q; got %+v", "list failed", result.Filters)
+	}
+}
@@ -18,61 +18,123 @@ package server
 
 import (
 	"encoding/json"
+	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
-	"strings"
+	"time"
 
 	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/constants"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/k8s/binder"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/metrics"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api/v1"
 )
 
-// SchedExtenderHandler implements http.Handler as a scheduler extender
+// SchedExtenderHandler implements the http handlers of a scheduler extender
 type SchedExtenderHandler struct {
-	Filter algorithm.Filter
-}
+	Filter      algorithm.Filter
+	Prioritizer algorithm.Prioritizer
+	Binder      binder.Binder
+	Preemptor   algorithm.Preemptor
 
-// ServeHTTP implements http.Handler
-func (s *SchedExtenderHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	log.Printf("processing %s %q", r.Method, r.URL.Path)
+	// NodeListFilter creates the Filter for requests carrying full Node
+	// objects, i.e. kube-scheduler is not configured with nodeCacheCapable.
+	// Filter is used if it is nil.
+	NodeListFilter func(nodes []v1.Node) algorithm.Filter
 
-	if strings.Contains(r.URL.Path, "filter") { // /scheduler/filter
-		s.processFilter(w, r)
-	} else if strings.Contains(r.URL.Path, "prioritize") { // /scheduler/prioritize
-		s.processPrioritize(w, r)
-	} else if strings.Contains(r.URL.Path, "bind") { // /scheduler/bind
-		s.processBind(w, r)
-	} else {
-		http.Error(w, "Unsupported request", http.StatusNotFound)
+	// NodeListPrioritizer creates the Prioritizer for requests carrying full
+	// Node objects, like NodeListFilter. Prioritizer is used if it is nil.
+	NodeListPrioritizer func(nodes []v1.Node) algorithm.Prioritizer
+
+	// Ready reports whether the caches are ready to make decisions, it is
+	// always ready if nil.
+	Ready func() bool
+
+	// FailOpen lets filter requests pass all nodes when not ready, otherwise
+	// they are refused.
+	FailOpen bool
+}
+
+// Routes returns the routes of the scheduler extender API
+func (s *SchedExtenderHandler) Routes() []Route {
+	return []Route{
+		{Method: http.MethodPost, Path: "filter", Handler: s.processFilter},
+		{Method: http.MethodPost, Path: "prioritize", Handler: s.processPrioritize},
+		{Method: http.MethodPost, Path: "bind", Handler: s.processBind},
+		{Method: http.MethodPost, Path: "preempt", Handler: s.processPreempt},
 	}
 }
 
 func (s *SchedExtenderHandler) processFilter(w http.ResponseWriter, r *http.Request) {
 	log.Printf("process filter %s", r.URL.Path)
+	defer observeLatency("filter", time.Now())
 
-	decoder := json.NewDecoder(r.Body)
 	defer r.Body.Close()
 
 	var args schedulerapi.ExtenderArgs
-	if err := decoder.Decode(&args); err != nil {
+	if err := decodePodArgs(r.Body, &args, func() *v1.Pod { return &args.Pod }); err != nil {
 		log.Printf("[ERROR] decode error: %s", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if args.NodeNames == nil {
-		http.Error(w, "NodeNames is nil", http.StatusBadRequest)
+	nodeNames, ok := getNodeNames(&args)
+	if !ok {
+		http.Error(w, "NodeNames and Nodes are nil", http.StatusBadRequest)
 		return
 	}
 
-	nodes, err := s.Filter.Filter(&args.Pod, *args.NodeNames)
+	filter := s.Filter
+	if args.NodeNames == nil && s.NodeListFilter != nil {
+		filter = s.NodeListFilter(args.Nodes.Items)
+	}
+
+	if s.Ready != nil && !s.Ready() {
+		if !s.FailOpen {
+			log.Printf("[WARNING] refuse filter request, extender is not ready")
+			resp := schedulerapi.ExtenderFilterResult{
+				Error: "extender is not ready",
+			}
+
+			encoder := json.NewEncoder(w)
+			if err := encoder.Encode(&resp); err != nil {
+				log.Printf("[ERROR] encode response %s", err)
+			}
+			return
+		}
+
+		log.Printf("[WARNING] pass all nodes, extender is not ready")
+		filter = algorithm.Filters{}
+	}
+
+	rejectedBy := make(map[string]string)
+	nodes, failed, err := algorithm.Walk(filter, &args.Pod, nodeNames,
+		func(f algorithm.Filter, _, _ []string, failed algorithm.FailedNodes, _ error) {
+			for node := range failed {
+				rejectedBy[node] = algorithm.FilterName(f)
+			}
+		})
 	if err != nil {
 		log.Printf("[ERROR] filter error: %s", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	for node := range failed {
+		metrics.FilterRejectedNodes.Inc(rejectedBy[node])
+	}
+
 	resp := schedulerapi.ExtenderFilterResult{
-		NodeNames: &nodes,
+		FailedNodes: schedulerapi.FailedNodesMap(failed),
+	}
+
+	if args.NodeNames != nil {
+		resp.NodeNames = &nodes
+	} else {
+		resp.Nodes = filterNodeList(args.Nodes, nodes)
 	}
 
 	encoder := json.NewEncoder(w)
@@ -83,20 +145,34 @@ func (s *SchedExtenderHandler) processFilter(w http.ResponseWriter, r *http.Requ
 
 func (s *SchedExtenderHandler) processPrioritize(w http.ResponseWriter, r *http.Request) {
 	log.Printf("process prioritize %s", r.URL.Path)
+	defer observeLatency("prioritize", time.Now())
 
-	decoder := json.NewDecoder(r.Body)
 	defer r.Body.Close()
 
 	var args schedulerapi.ExtenderArgs
-	if err := decoder.Decode(&args); err != nil {
+	if err := decodePodArgs(r.Body, &args, func() *v1.Pod { return &args.Pod }); err != nil {
 		log.Printf("[ERROR] decode error: %s", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// TODO: Implement prioritize
+	nodeNames, ok := getNodeNames(&args)
+	if !ok {
+		http.Error(w, "NodeNames and Nodes are nil", http.StatusBadRequest)
+		return
+	}
 
-	var resp schedulerapi.HostPriorityList
+	prioritizer := s.Prioritizer
+	if args.NodeNames == nil && s.NodeListPrioritizer != nil {
+		prioritizer = s.NodeListPrioritizer(args.Nodes.Items)
+	}
+
+	resp, err := prioritizer.Prioritize(&args.Pod, nodeNames)
+	if err != nil {
+		log.Printf("[ERROR] prioritize error: %s", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	encoder := json.NewEncoder(w)
 	if err := encoder.Encode(&resp); err != nil {
@@ -104,6 +180,80 @@ func (s *SchedExtenderHandler) processPrioritize(w http.ResponseWriter, r *http.
 	}
 }
 
+func observeLatency(verb string, start time.Time) {
+	metrics.ExtenderLatency.Observe(metrics.SinceInSeconds(start), verb)
+}
+
+// decodePodArgs decodes body into args, where the pod returned by getPod is
+// decoded from the "pod" key. The topologySpreadConstraints of the pod spec,
+// which the vendored Pod type lacks, are carried in the topology spread
+// annotation of the pod, which they take precedence over.
+func decodePodArgs(body io.Reader, args interface{}, getPod func() *v1.Pod) error {
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, args); err != nil {
+		return err
+	}
+
+	var raw struct {
+		Pod struct {
+			Spec struct {
+				TopologySpreadConstraints json.RawMessage `json:"topologySpreadConstraints"`
+			} `json:"spec"`
+		} `json:"pod"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	constraints := raw.Pod.Spec.TopologySpreadConstraints
+	pod := getPod()
+	if pod == nil || len(constraints) == 0 || string(constraints) == "null" {
+		return nil
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	pod.Annotations[constants.TopologySpreadAnnotation] = string(constraints)
+	return nil
+}
+
+// getNodeNames returns the candidate node names from either NodeNames or
+// Nodes of args. It returns false if neither is given.
+func getNodeNames(args *schedulerapi.ExtenderArgs) ([]string, bool) {
+	if args.NodeNames != nil {
+		return *args.NodeNames, true
+	}
+
+	if args.Nodes == nil {
+		return nil, false
+	}
+
+	nodeNames := make([]string, 0, len(args.Nodes.Items))
+	for _, node := range args.Nodes.Items {
+		nodeNames = append(nodeNames, node.Name)
+	}
+	return nodeNames, true
+}
+
+// filterNodeList returns a NodeList that keeps only the named nodes of list
+func filterNodeList(list *v1.NodeList, names []string) *v1.NodeList {
+	keep := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		keep[name] = struct{}{}
+	}
+
+	result := &v1.NodeList{}
+	for _, node := range list.Items {
+		if _, ok := keep[node.Name]; ok {
+			result.Items = append(result.Items, node)
+		}
+	}
+	return result
+}
+
 func (s *SchedExtenderHandler) processBind(w http.ResponseWriter, r *http.Request) {
 	log.Printf("process binding %s", r.URL.Path)
 
@@ -118,6 +268,79 @@ func (s *SchedExtenderHandler) processBind(w http.ResponseWriter, r *http.Reques
 	}
 
 	resp := schedulerapi.ExtenderBindingResult{}
+	err := s.Binder.Bind(args.PodNamespace, args.PodName, args.PodUID, args.Node)
+	if err != nil {
+		log.Printf("[ERROR] bind error: %s", err)
+		resp.Error = err.Error()
+	}
+
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(&resp); err != nil {
+		log.Printf("[ERROR] encode response %s", err)
+	}
+}
+
+func (s *SchedExtenderHandler) processPreempt(w http.ResponseWriter, r *http.Request) {
+	log.Printf("process preemption %s", r.URL.Path)
+
+	decoder := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+
+	var args ExtenderPreemptionArgs
+	if err := decoder.Decode(&args); err != nil {
+		log.Printf("[ERROR] decode error: %s", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if args.Pod == nil {
+		http.Error(w, "Pod is nil", http.StatusBadRequest)
+		return
+	}
+
+	// Collect victims from either the full pods or the pod UIDs
+	nodeToVictims := make(map[string][]types.UID)
+	numPDBViolations := make(map[string]int)
+	if args.NodeNameToMetaVictims != nil {
+		for node, victims := range args.NodeNameToMetaVictims {
+			uids := []types.UID{}
+			for _, pod := range victims.Pods {
+				uids = append(uids, pod.UID)
+			}
+			nodeToVictims[node] = uids
+			numPDBViolations[node] = victims.NumPDBViolations
+		}
+	} else {
+		for node, victims := range args.NodeNameToVictims {
+			uids := []types.UID{}
+			for _, pod := range victims.Pods {
+				uids = append(uids, pod.UID)
+			}
+			nodeToVictims[node] = uids
+			numPDBViolations[node] = victims.NumPDBViolations
+		}
+	}
+
+	result, err := s.Preemptor.Preempt(args.Pod, nodeToVictims)
+	if err != nil {
+		log.Printf("[ERROR] preempt error: %s", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := ExtenderPreemptionResult{
+		NodeNameToMetaVictims: make(map[string]*MetaVictims),
+	}
+	for node, uids := range result {
+		victims := &MetaVictims{
+			Pods:             []*MetaPod{},
+			NumPDBViolations: numPDBViolations[node],
+		}
+		for _, uid := range uids {
+			victims.Pods = append(victims.Pods, &MetaPod{UID: uid})
+		}
+		resp.NodeNameToMetaVictims[node] = victims
+	}
 
 	encoder := json.NewEncoder(w)
 	if err := encoder.Encode(&resp); err != nil {
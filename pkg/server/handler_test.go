@@ -0,0 +1,220 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm/fake"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/constants"
+	k8scache "github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/k8s/cache"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api/v1"
+)
+
+// hostFilter passes the nodes on host
+type hostFilter struct {
+	hostCache algorithm.HostCache
+	host      string
+}
+
+func (f *hostFilter) Filter(pod *v1.Pod, nodes []string) ([]string, algorithm.FailedNodes, error) {
+	result := []string{}
+	failed := algorithm.FailedNodes{}
+	for _, node := range nodes {
+		if f.hostCache.GetHost(node) == f.host {
+			result = append(result, node)
+		} else {
+			failed[node] = "not on " + f.host
+		}
+	}
+	return result, failed, nil
+}
+
+// hostPrioritizer scores the nodes on host highest
+type hostPrioritizer struct {
+	hostCache algorithm.HostCache
+	host      string
+}
+
+func (p *hostPrioritizer) Prioritize(pod *v1.Pod, nodes []string) (schedulerapi.HostPriorityList, error) {
+	result := schedulerapi.HostPriorityList{}
+	for _, node := range nodes {
+		score := 0
+		if p.hostCache.GetHost(node) == p.host {
+			score = algorithm.MaxPriority
+		}
+		result = append(result, schedulerapi.HostPriority{Host: node, Score: score})
+	}
+	return result, nil
+}
+
+func newHostNode(name, host string) v1.Node {
+	return v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{constants.HostLabel: host},
+		},
+	}
+}
+
+func TestSchedExtenderHandler(t *testing.T) {
+	// the cache knows no hosts, they come from the node objects only
+	cached := fake.NodeCache(map[string]string{})
+	handler := &SchedExtenderHandler{
+		Filter:      &hostFilter{cached, "host2"},
+		Prioritizer: &hostPrioritizer{cached, "host2"},
+		NodeListFilter: func(nodes []v1.Node) algorithm.Filter {
+			return &hostFilter{k8scache.NewNodeListHostCache(nodes, cached), "host2"}
+		},
+		NodeListPrioritizer: func(nodes []v1.Node) algorithm.Prioritizer {
+			return &hostPrioritizer{k8scache.NewNodeListHostCache(nodes, cached), "host2"}
+		},
+	}
+	router := NewRouter("/scheduler")
+	router.Register("", handler.Routes()...)
+
+	post := func(verb string, args schedulerapi.ExtenderArgs) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(&args)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/scheduler/"+verb, bytes.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expect %s status 200; got %d: %s", verb, w.Code, w.Body.String())
+		}
+		return w
+	}
+
+	nodes := &v1.NodeList{Items: []v1.Node{
+		newHostNode("node1", "host1"),
+		newHostNode("node2", "host2"),
+		newHostNode("node3", "host2"),
+	}}
+
+	// filter with full nodes
+	var filterResult schedulerapi.ExtenderFilterResult
+	json.NewDecoder(post("filter", schedulerapi.ExtenderArgs{Nodes: nodes}).Body).Decode(&filterResult)
+	if filterResult.NodeNames != nil {
+		t.Errorf("expect no NodeNames with full nodes; got %v", *filterResult.NodeNames)
+	}
+	if filterResult.Nodes == nil {
+		t.Fatal("expect Nodes with full nodes")
+	}
+	var names []string
+	for _, node := range filterResult.Nodes.Items {
+		names = append(names, node.Name)
+		if node.Labels[constants.HostLabel] != "host2" {
+			t.Errorf("expect %s returned whole; got labels %v", node.Name, node.Labels)
+		}
+	}
+	if !reflect.DeepEqual(names, []string{"node2", "node3"}) {
+		t.Errorf("expect nodes [node2 node3]; got %v", names)
+	}
+	if _, ok := filterResult.FailedNodes["node1"]; !ok || len(filterResult.FailedNodes) != 1 {
+		t.Errorf("expect node1 failed; got %v", filterResult.FailedNodes)
+	}
+
+	// filter with node names, hosts come from the cache
+	filterResult = schedulerapi.ExtenderFilterResult{}
+	json.NewDecoder(post("filter", schedulerapi.ExtenderArgs{NodeNames: &[]string{"node1", "node2"}}).Body).
+		Decode(&filterResult)
+	if filterResult.Nodes != nil || filterResult.NodeNames == nil || len(*filterResult.NodeNames) != 0 {
+		t.Errorf("expect no NodeNames passing without hosts in cache; got %+v", filterResult)
+	}
+
+	// prioritize with full nodes
+	var priorities schedulerapi.HostPriorityList
+	json.NewDecoder(post("prioritize", schedulerapi.ExtenderArgs{Nodes: nodes}).Body).Decode(&priorities)
+	expect := schedulerapi.HostPriorityList{
+		{Host: "node1", Score: 0},
+		{Host: "node2", Score: algorithm.MaxPriority},
+		{Host: "node3", Score: algorithm.MaxPriority},
+	}
+	if !reflect.DeepEqual(priorities, expect) {
+		t.Errorf("expect priorities %v; got %v", expect, priorities)
+	}
+
+	// prioritize with node names
+	priorities = nil
+	json.NewDecoder(post("prioritize", schedulerapi.ExtenderArgs{NodeNames: &[]string{"node2"}}).Body).
+		Decode(&priorities)
+	expect = schedulerapi.HostPriorityList{{Host: "node2", Score: 0}}
+	if !reflect.DeepEqual(priorities, expect) {
+		t.Errorf("expect priorities %v; got %v", expect, priorities)
+	}
+}
+
+func TestGetNodeNames(t *testing.T) {
+	tests := []struct {
+		desc   string
+		args   schedulerapi.ExtenderArgs
+		expect []string
+		ok     bool
+	}{
+		{"node names", schedulerapi.ExtenderArgs{NodeNames: &[]string{"node1"}}, []string{"node1"}, true},
+		{"nodes", schedulerapi.ExtenderArgs{Nodes: &v1.NodeList{Items: []v1.Node{newHostNode("node2", "host1")}}},
+			[]string{"node2"}, true},
+		{"neither", schedulerapi.ExtenderArgs{}, nil, false},
+	}
+	for _, test := range tests {
+		names, ok := getNodeNames(&test.args)
+		if ok != test.ok || !reflect.DeepEqual(names, test.expect) {
+			t.Errorf("[%s] expect %v %v; got %v %v", test.desc, test.expect, test.ok, names, ok)
+		}
+	}
+}
+
+func TestDecodePodArgsSpreadConstraints(t *testing.T) {
+	spec := `[{"maxSkew":1,"topologyKey":"alpha.cna.vmware.com/host","whenUnsatisfiable":"DoNotSchedule"}]`
+	annotation := `[{"maxSkew":2,"topologyKey":"alpha.cna.vmware.com/host","whenUnsatisfiable":"DoNotSchedule"}]`
+
+	tests := []struct {
+		desc   string
+		body   string
+		expect string
+	}{
+		{"none", `{"pod":{"metadata":{"name":"web-0"}},"nodenames":["node1"]}`, ""},
+		{"spec", `{"pod":{"spec":{"topologySpreadConstraints":` + spec + `}}}`, spec},
+		{"annotation", `{"pod":{"metadata":{"annotations":{"` + constants.TopologySpreadAnnotation + `":` +
+			jsonQuote(annotation) + `}}}}`, annotation},
+		{"spec over annotation", `{"pod":{"metadata":{"annotations":{"` + constants.TopologySpreadAnnotation + `":` +
+			jsonQuote(annotation) + `}},"spec":{"topologySpreadConstraints":` + spec + `}}}`, spec},
+	}
+
+	for _, test := range tests {
+		var args schedulerapi.ExtenderArgs
+		if err := decodePodArgs(strings.NewReader(test.body), &args, func() *v1.Pod { return &args.Pod }); err != nil {
+			t.Errorf("[%s] unexpected error: %s", test.desc, err)
+			continue
+		}
+		if got := args.Pod.Annotations[constants.TopologySpreadAnnotation]; got != test.expect {
+			t.Errorf("[%s] expect constraints %s; got %s", test.desc, test.expect, got)
+		}
+	}
+}
+
+func jsonQuote(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
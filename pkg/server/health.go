@@ -0,0 +1,83 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Check reports whether a component is ready
+type Check struct {
+	Name  string
+	Ready func() bool
+
+	// Reason optionally tells why the component is not ready
+	Reason func() string
+}
+
+// Health serves the liveness and readiness of the extender
+type Health struct {
+	checks []Check
+}
+
+// NewHealth creates a Health that is ready when all checks are ready
+func NewHealth(checks ...Check) *Health {
+	return &Health{checks: checks}
+}
+
+// Ready returns true if all checks are ready
+func (h *Health) Ready() bool {
+	return len(h.notReady()) == 0
+}
+
+func (h *Health) notReady() []string {
+	var result []string
+	for _, check := range h.checks {
+		if !check.Ready() {
+			if check.Reason != nil {
+				result = append(result, fmt.Sprintf("%s (%s)", check.Name, check.Reason()))
+			} else {
+				result = append(result, check.Name)
+			}
+		}
+	}
+	return result
+}
+
+// Routes returns the health routes, /healthz for liveness and /readyz for
+// readiness.
+func (h *Health) Routes() []Route {
+	return []Route{
+		{Method: http.MethodGet, Path: "/healthz", Handler: h.processHealthz},
+		{Method: http.MethodGet, Path: "/readyz", Handler: h.processReadyz},
+	}
+}
+
+func (h *Health) processHealthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+func (h *Health) processReadyz(w http.ResponseWriter, r *http.Request) {
+	if notReady := h.notReady(); len(notReady) > 0 {
+		http.Error(w, "not ready: "+strings.Join(notReady, ", "), http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
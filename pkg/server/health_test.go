@@ -0,0 +1,68 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	k8sReady := false
+	loginErr := "invalid password"
+	health := NewHealth(
+		Check{Name: "kubernetes", Ready: func() bool { return k8sReady }},
+		Check{Name: "vsphere", Ready: func() bool { return true }},
+		Check{Name: "vsphere-login", Ready: func() bool { return loginErr == "" },
+			Reason: func() string { return loginErr }},
+	)
+
+	router := NewRouter("/scheduler")
+	for _, route := range health.Routes() {
+		router.Handle(route)
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	if w := get("/healthz"); w.Code != http.StatusOK {
+		t.Errorf("expect /healthz status %d; got %d", http.StatusOK, w.Code)
+	}
+	if w := get("/readyz"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expect /readyz status %d; got %d", http.StatusServiceUnavailable, w.Code)
+	} else if body := strings.TrimSpace(w.Body.String()); body != "not ready: kubernetes, vsphere-login (invalid password)" {
+		t.Errorf("unexpected /readyz body %q", body)
+	}
+	if health.Ready() {
+		t.Errorf("expect not ready")
+	}
+
+	k8sReady = true
+	loginErr = ""
+
+	if w := get("/readyz"); w.Code != http.StatusOK {
+		t.Errorf("expect /readyz status %d; got %d", http.StatusOK, w.Code)
+	}
+	if !health.Ready() {
+		t.Errorf("expect ready")
+	}
+}
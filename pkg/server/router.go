@@ -0,0 +1,90 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"log"
+	"net/http"
+	"path"
+)
+
+// Route is an endpoint served by Router
+type Route struct {
+	// Method is the only HTTP method accepted by the route
+	Method string
+
+	// Path is the path of the route relative to the API version
+	Path string
+
+	Handler http.HandlerFunc
+}
+
+// Router dispatches requests to routes by exact path under a URL prefix. Each
+// API version is served at <prefix>/<version>/<path>, so several versions can
+// be served side by side.
+type Router struct {
+	prefix string
+	routes map[string]Route
+}
+
+// NewRouter creates a Router serving routes under prefix
+func NewRouter(prefix string) *Router {
+	return &Router{
+		prefix: path.Join("/", prefix),
+		routes: make(map[string]Route),
+	}
+}
+
+// Register adds routes of an API version. An empty version serves routes
+// directly under the prefix.
+func (router *Router) Register(version string, routes ...Route) {
+	for _, route := range routes {
+		p := path.Join(router.prefix, version, route.Path)
+		if _, ok := router.routes[p]; ok {
+			log.Panicf("router: duplicated route %s", p)
+		}
+		router.routes[p] = route
+	}
+}
+
+// Handle adds a route at an absolute path, which is not under the prefix
+func (router *Router) Handle(route Route) {
+	p := path.Join("/", route.Path)
+	if _, ok := router.routes[p]; ok {
+		log.Panicf("router: duplicated route %s", p)
+	}
+	router.routes[p] = route
+}
+
+// ServeHTTP implements http.Handler
+func (router *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("processing %s %q", r.Method, r.URL.Path)
+
+	route, ok := router.routes[r.URL.Path]
+	if !ok {
+		http.Error(w, "Unsupported request", http.StatusNotFound)
+		return
+	}
+
+	if r.Method != route.Method {
+		w.Header().Set("Allow", route.Method)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	route.Handler(w, r)
+}
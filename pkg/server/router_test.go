@@ -0,0 +1,67 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	var called []string
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			called = append(called, name)
+		}
+	}
+
+	router := NewRouter("/scheduler")
+	router.Register("v1", Route{Method: http.MethodPost, Path: "filter", Handler: handler("v1-filter")})
+	router.Register("v2", Route{Method: http.MethodPost, Path: "filter", Handler: handler("v2-filter")})
+	router.Register("", Route{Method: http.MethodPost, Path: "filter", Handler: handler("filter")})
+
+	tests := []struct {
+		method string
+		path   string
+		code   int
+		called string
+	}{
+		{http.MethodPost, "/scheduler/v1/filter", http.StatusOK, "v1-filter"},
+		{http.MethodPost, "/scheduler/v2/filter", http.StatusOK, "v2-filter"},
+		{http.MethodPost, "/scheduler/filter", http.StatusOK, "filter"},
+		{http.MethodGet, "/scheduler/v1/filter", http.StatusMethodNotAllowed, ""},
+		{http.MethodPost, "/scheduler/v1/filterbind", http.StatusNotFound, ""},
+		{http.MethodPost, "/filter", http.StatusNotFound, ""},
+	}
+
+	for _, test := range tests {
+		called = nil
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+
+		if w.Code != test.code {
+			t.Errorf("[%s %s] expect status %d; got %d", test.method, test.path, test.code, w.Code)
+		}
+		if test.called != "" && (len(called) != 1 || called[0] != test.called) {
+			t.Errorf("[%s %s] expect %s called; got %s", test.method, test.path, test.called, called)
+		}
+		if test.called == "" && len(called) != 0 {
+			t.Errorf("[%s %s] expect no handler called; got %s", test.method, test.path, called)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSOptions is the TLS configuration of the extender server
+type TLSOptions struct {
+	// CertFile and KeyFile are the serving certificate and key
+	CertFile string
+	KeyFile  string
+
+	// ClientCAFile is the CA bundle to verify client certificates, client
+	// certificates are not required if it is empty.
+	ClientCAFile string
+}
+
+// NewTLSConfig creates a tls.Config from options. The certificate, key and
+// client CA files are reloaded on handshake once they change on disk.
+func NewTLSConfig(options TLSOptions) (*tls.Config, error) {
+	r := &tlsReloader{options: options}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &r.get().Certificates[0], nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.get(), nil
+		},
+	}, nil
+}
+
+// tlsReloader keeps the tls.Config built from the files of options in sync
+// with their modification time.
+type tlsReloader struct {
+	options TLSOptions
+
+	sync.Mutex
+	modTimes []time.Time
+	config   *tls.Config
+}
+
+func (r *tlsReloader) files() []string {
+	files := []string{r.options.CertFile, r.options.KeyFile}
+	if r.options.ClientCAFile != "" {
+		files = append(files, r.options.ClientCAFile)
+	}
+	return files
+}
+
+// get returns the current tls.Config, it reloads the files if any of them has
+// changed, the previous config is kept if reloading fails.
+func (r *tlsReloader) get() *tls.Config {
+	r.Lock()
+	defer r.Unlock()
+
+	for i, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			log.Printf("[WARNING] tls: failed to stat %s: %s", file, err)
+			return r.config
+		}
+
+		if !info.ModTime().Equal(r.modTimes[i]) {
+			log.Printf("tls: %s changed, reloading", file)
+			if err := r.doReload(); err != nil {
+				log.Printf("[ERROR] tls: failed to reload: %s", err)
+			}
+			break
+		}
+	}
+
+	return r.config
+}
+
+func (r *tlsReloader) reload() error {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.doReload()
+}
+
+// doReload loads the files into config. Caller needs to own the lock.
+func (r *tlsReloader) doReload() error {
+	files := r.files()
+	modTimes := make([]time.Time, len(files))
+	for i, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	// record the modification time even if loading fails, so that broken
+	// files are not reloaded on every handshake until they change again
+	r.modTimes = modTimes
+
+	cert, err := tls.LoadX509KeyPair(r.options.CertFile, r.options.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if r.options.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(r.options.ClientCAFile)
+		if err != nil {
+			return err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("tls: no certificate found in %s", r.options.ClientCAFile)
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	r.config = config
+	return nil
+}
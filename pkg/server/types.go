@@ -0,0 +1,62 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// The preemption types below follow the wire format of the scheduler
+// extender API (k8s.io/kubernetes/pkg/scheduler/api/v1) introduced in
+// Kubernetes 1.11, which is newer than the vendored version.
+
+// ExtenderPreemptionArgs represents the arguments needed by the extender to
+// preempt pods on nodes.
+type ExtenderPreemptionArgs struct {
+	// Pod being scheduled
+	Pod *v1.Pod `json:"pod"`
+	// Victims map generated by scheduler preemption phase, populated only if
+	// the extender is not nodeCacheCapable.
+	NodeNameToVictims map[string]*Victims `json:"nodeToVictims,omitempty"`
+	// Victims map generated by scheduler preemption phase, populated only if
+	// the extender is nodeCacheCapable.
+	NodeNameToMetaVictims map[string]*MetaVictims `json:"nodeNameToMetaVictims,omitempty"`
+}
+
+// ExtenderPreemptionResult represents the result returned by preemption phase
+// of extender.
+type ExtenderPreemptionResult struct {
+	NodeNameToMetaVictims map[string]*MetaVictims `json:"nodeNameToMetaVictims,omitempty"`
+}
+
+// Victims represents the pods to be preempted on a node
+type Victims struct {
+	Pods             []*v1.Pod `json:"pods"`
+	NumPDBViolations int       `json:"numPDBViolations"`
+}
+
+// MetaVictims represents the UIDs of the pods to be preempted on a node
+type MetaVictims struct {
+	Pods             []*MetaPod `json:"pods"`
+	NumPDBViolations int        `json:"numPDBViolations"`
+}
+
+// MetaPod represents an identifier for a v1.Pod
+type MetaPod struct {
+	UID types.UID `json:"uid"`
+}
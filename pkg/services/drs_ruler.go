@@ -21,15 +21,16 @@ import (
 	"log"
 	"reflect"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/algorithm"
 	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/bridgecache"
 	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/constants"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/metrics"
 	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/selector"
 	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/vsphere"
 	"k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/tools/cache"
 )
 
@@ -39,9 +40,10 @@ import (
 // do scheduling against Kubernetes. For example, DRS doesn't migrate VM to a
 // host that breaks Kubernetes' anti-affinity rule.
 type DRSRuler struct {
-	bcache    bridgecache.Cache
-	podLister algorithm.PodLister
-	vsclient  vsphere.Vsphere
+	bcache          bridgecache.Cache
+	podLister       algorithm.PodLister
+	namespaceLister selector.NamespaceLister
+	vsclient        vsphere.Vsphere
 
 	// kubernetes pods with affinity rules
 	affinityPods     map[string]*v1.Pod
@@ -53,9 +55,11 @@ func NewDRSRuler(
 	podInformer cache.SharedIndexInformer,
 	bcache bridgecache.Cache,
 	podLister algorithm.PodLister,
+	namespaceLister selector.NamespaceLister,
 	vsclient vsphere.Vsphere) *DRSRuler {
 	drs := &DRSRuler{
 		podLister:        podLister,
+		namespaceLister:  namespaceLister,
 		bcache:           bcache,
 		vsclient:         vsclient,
 		affinityPods:     make(map[string]*v1.Pod),
@@ -90,15 +94,15 @@ func (r *DRSRuler) sync() {
 	log.Printf("actual rules: %v", actualRules)
 	log.Printf("desired rules: %v", desiredRules)
 
-	// Delete not-needed rules
+	// Delete not-needed rules, leaving alone the rules and groups not set by
+	// the plugin, e.g. the ones of the vSphere administrators
 	for uid, rule := range actualRules {
+		if !isPluginRule(rule.Name) {
+			continue
+		}
 		if _, ok := desiredRules[uid]; !ok {
 			log.Printf("delete rule: %v", rule)
-			if rule.Affinity {
-				r.vsclient.DeleteAffinityRule(rule.Name)
-			} else {
-				r.vsclient.DeleteAntiAffinityRule(rule.Name)
-			}
+			r.deleteRule(rule)
 		}
 	}
 
@@ -106,11 +110,7 @@ func (r *DRSRuler) sync() {
 	for uid, rule := range desiredRules {
 		if _, ok := actualRules[uid]; !ok {
 			log.Printf("apply rule: %s(%v)", uid, rule)
-			if rule.Affinity {
-				r.vsclient.ApplyAffinityRule(rule.Name, rule.VMs...)
-			} else {
-				r.vsclient.ApplyAntiAffinityRule(rule.Name, rule.VMs...)
-			}
+			r.applyRule(rule)
 		}
 	}
 
@@ -119,21 +119,51 @@ func (r *DRSRuler) sync() {
 		if actualRule, ok := actualRules[uid]; ok {
 			sort.Strings(desiredRule.VMs)
 			sort.Strings(actualRule.VMs)
-			if !reflect.DeepEqual(desiredRule.VMs, actualRule.VMs) {
+			if !reflect.DeepEqual(desiredRule.VMs, actualRule.VMs) ||
+				desiredRule.HostGroup != actualRule.HostGroup {
 				log.Printf("modify rule: %v", actualRule)
-				if actualRule.Affinity {
-					r.vsclient.DeleteAffinityRule(actualRule.Name)
-					r.vsclient.ApplyAffinityRule(actualRule.Name, actualRule.VMs...)
-				} else {
-					r.vsclient.DeleteAffinityRule(actualRule.Name)
-					r.vsclient.ApplyAntiAffinityRule(actualRule.Name, actualRule.VMs...)
-				}
+				r.deleteRule(actualRule)
+				r.applyRule(desiredRule)
 			}
 
 		}
 	}
 }
 
+// applyRule applies a rule to vSphere
+func (r *DRSRuler) applyRule(rule vsphere.Rule) {
+	var err error
+	if rule.HostGroup != "" {
+		err = r.vsclient.ApplyHostGroupRule(rule.Name, rule.HostGroup, rule.Affinity, rule.VMs...)
+	} else if rule.Affinity {
+		err = r.vsclient.ApplyAffinityRule(rule.Name, rule.VMs...)
+	} else {
+		err = r.vsclient.ApplyAntiAffinityRule(rule.Name, rule.VMs...)
+	}
+	r.observe("apply", err)
+}
+
+// deleteRule deletes a rule from vSphere
+func (r *DRSRuler) deleteRule(rule vsphere.Rule) {
+	var err error
+	if rule.HostGroup != "" {
+		err = r.vsclient.DeleteHostGroupRule(rule.Name)
+	} else if rule.Affinity {
+		err = r.vsclient.DeleteAffinityRule(rule.Name)
+	} else {
+		err = r.vsclient.DeleteAntiAffinityRule(rule.Name)
+	}
+	r.observe("delete", err)
+}
+
+// observe records the result of a rule operation
+func (r *DRSRuler) observe(operation string, err error) {
+	if err != nil {
+		log.Printf("[ERROR] failed to %s rule: %s", operation, err)
+	}
+	metrics.DRSRuleOperations.Inc(operation, metrics.Result(err))
+}
+
 func (r *DRSRuler) desiredRules() map[string]vsphere.Rule {
 	rules := make(map[string]vsphere.Rule)
 
@@ -143,69 +173,182 @@ func (r *DRSRuler) desiredRules() map[string]vsphere.Rule {
 	return rules
 }
 
+// calculateRules converts the required terms of pods into DRS rules. As each
+// term may be satisfied by different pods, the VMs are resolved term by term.
+// All VMs an affine pod has to share a host with end up on the same host, so
+// they form one affinity rule. An anti-affine pod only has to be apart from
+// the VMs of each term, so every term gets its own anti-affinity rule. Terms
+// on host group scope are converted into VM-to-Host rules.
 func (r *DRSRuler) calculateRules(podsWithTerm map[string]*v1.Pod, affinity bool, rules map[string]vsphere.Rule) {
 	for _, pod := range podsWithTerm {
 		var terms []v1.PodAffinityTerm
+		kind := selector.AffinityTerm
 		if affinity {
 			terms = pod.Spec.Affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution
 		} else {
 			terms = pod.Spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+			kind = selector.AntiAffinityTerm
 		}
 
-		// Get pod selector
-		var selectors selector.And
-		for _, af := range terms {
-			if af.TopologyKey != constants.HostLabel {
+		vmid := r.bcache.GetVMIDFromNode(pod.Spec.NodeName)
+		affinityVMs := map[string]struct{}{vmid: {}}
+
+		for i, term := range terms {
+			if term.TopologyKey != constants.HostLabel && term.TopologyKey != constants.HostGroupLabel {
 				continue
 			}
 
-			selector, err := metav1.LabelSelectorAsSelector(af.LabelSelector)
+			s, err := selector.ForTerm(pod, kind, i, &terms[i], r.namespaceLister)
 			if err != nil {
-				log.Printf("[WARNING] failed to get selector: %s", err)
+				log.Printf("[WARNING] invalid selector: %s", err)
 				continue
 			}
 
-			selectors = append(selectors, selector)
+			pods, err := r.podLister.ListPod(s)
+			if err != nil {
+				log.Printf("[ERROR] failed to list pods: %s", err)
+				continue
+			}
+
+			if term.TopologyKey == constants.HostGroupLabel {
+				name := fmt.Sprintf("%s-%d", r.ruleName(pod, affinity), i)
+				r.addHostGroupRules(name, affinity, vmid, pods, rules)
+				continue
+			}
+
+			if affinity {
+				r.addVMs(pods, affinityVMs)
+				continue
+			}
+
+			vmids := map[string]struct{}{vmid: {}}
+			r.addVMs(pods, vmids)
+			name := fmt.Sprintf("%s-%d", r.ruleName(pod, affinity), i)
+			r.addRule(name, affinity, vmids, rules)
 		}
 
-		pods, err := r.podLister.ListPod(selectors)
-		if err != nil {
-			log.Printf("[ERROR] failed to list pods: %s", err)
+		if affinity {
+			r.addRule(r.ruleName(pod, affinity), affinity, affinityVMs, rules)
 		}
-		if len(pods) == 0 {
-			continue
+	}
+}
+
+// addHostGroupRules adds the VM-to-Host rules of a term on host group scope.
+// An affine VM is kept with the matched VMs in its current host group, while
+// an anti-affine VM is kept out of every host group running a matched VM.
+func (r *DRSRuler) addHostGroupRules(name string, affinity bool, vmid string, pods []*v1.Pod, rules map[string]vsphere.Rule) {
+	if vmid == "" {
+		return
+	}
+
+	hostGroups := r.vsclient.HostGroups()
+	hostGroupsOf := func(vmid string) []string {
+		host, err := r.vsclient.GetHostFromVMID(vmid)
+		if err != nil || host == "" {
+			return nil
 		}
+		return bridgecache.HostGroupsOf(hostGroups, host)
+	}
+
+	vmids := make(map[string]struct{})
+	r.addVMs(pods, vmids)
+	delete(vmids, vmid)
+	delete(vmids, "")
 
+	if affinity {
+		for _, group := range hostGroupsOf(vmid) {
+			rule := vsphere.Rule{
+				Name:      name,
+				VMs:       []string{vmid},
+				Affinity:  true,
+				HostGroup: group,
+			}
+			for matched := range vmids {
+				if contains(hostGroupsOf(matched), group) {
+					rule.VMs = append(rule.VMs, matched)
+				}
+			}
+			if len(rule.VMs) > 1 {
+				rules[rule.Name] = rule
+				return
+			}
+		}
+		return
+	}
+
+	groups := make(map[string]struct{})
+	for matched := range vmids {
+		for _, group := range hostGroupsOf(matched) {
+			groups[group] = struct{}{}
+		}
+	}
+	for group := range groups {
 		rule := vsphere.Rule{
-			Name:     r.ruleName(pod, affinity),
-			Affinity: affinity,
+			Name:      fmt.Sprintf("%s-%s", name, group),
+			VMs:       []string{vmid},
+			Affinity:  false,
+			HostGroup: group,
 		}
+		rules[rule.Name] = rule
+	}
+}
 
-		vmids := make(map[string]interface{})
-		for _, matchedPod := range pods {
-			nodename := matchedPod.Spec.NodeName
-			if nodename == "" {
-				continue
-			}
-			vmid := r.bcache.GetVMIDFromNode(nodename)
-			vmids[vmid] = struct{}{}
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
 		}
-		vmid := r.bcache.GetVMIDFromNode(pod.Spec.NodeName)
-		vmids[vmid] = struct{}{}
+	}
+	return false
+}
 
-		for vmid := range vmids {
-			rule.VMs = append(rule.VMs, vmid)
+// addVMs adds the VMs running pods to vmids
+func (r *DRSRuler) addVMs(pods []*v1.Pod, vmids map[string]struct{}) {
+	for _, matchedPod := range pods {
+		nodename := matchedPod.Spec.NodeName
+		if nodename == "" {
+			continue
 		}
+		vmid := r.bcache.GetVMIDFromNode(nodename)
+		vmids[vmid] = struct{}{}
+	}
+}
 
-		rules[rule.Name] = rule
+// addRule adds a rule on vmids to rules, unless there are no VMs to keep
+// together or apart.
+func (r *DRSRuler) addRule(name string, affinity bool, vmids map[string]struct{}, rules map[string]vsphere.Rule) {
+	if len(vmids) < 2 {
+		return
+	}
+
+	rule := vsphere.Rule{
+		Name:     name,
+		Affinity: affinity,
 	}
+	for vmid := range vmids {
+		rule.VMs = append(rule.VMs, vmid)
+	}
+
+	rules[rule.Name] = rule
 }
 
+const (
+	// affinityRulePrefix and antiAffinityRulePrefix prefix the names of the
+	// rules set by the plugin
+	affinityRulePrefix     = "affi-"
+	antiAffinityRulePrefix = "anti-"
+)
+
 func (r *DRSRuler) ruleName(pod *v1.Pod, affinity bool) string {
 	if affinity {
-		return fmt.Sprintf("affi-%s", pod.UID)
+		return affinityRulePrefix + string(pod.UID)
 	}
-	return fmt.Sprintf("anti-%s", pod.UID)
+	return antiAffinityRulePrefix + string(pod.UID)
+}
+
+// isPluginRule returns true if the rule of name is set by the plugin
+func isPluginRule(name string) bool {
+	return strings.HasPrefix(name, affinityRulePrefix) || strings.HasPrefix(name, antiAffinityRulePrefix)
 }
 
 // OnAdd is handler for adding an pod object
@@ -262,23 +405,3 @@ func (r *DRSRuler) OnDelete(obj interface{}) {
 		}
 	}
 }
-
-func getSelector(terms []v1.PodAffinityTerm) selector.Selector {
-	var selectors selector.And
-
-	for _, term := range terms {
-		if term.TopologyKey != constants.HostLabel {
-			continue
-		}
-
-		selector, err := metav1.LabelSelectorAsSelector(term.LabelSelector)
-		if err != nil {
-			log.Printf("[WARNING] invalid selector: %s", err)
-			continue
-		}
-
-		selectors = append(selectors, selector)
-	}
-
-	return selectors
-}
@@ -113,8 +113,8 @@ func TestDRSRulerDesiredRules(t *testing.T) {
 			VMs:      []string{"vm0", "vm1"},
 			Affinity: true,
 		},
-		"anti-pod-uid2": vsphere.Rule{
-			Name:     "anti-pod-uid2",
+		"anti-pod-uid2-0": vsphere.Rule{
+			Name:     "anti-pod-uid2-0",
 			VMs:      []string{"vm0", "vm2"},
 			Affinity: false,
 		},
@@ -192,3 +192,285 @@ func TestDRSRulerHandler(t *testing.T) {
 		t.Errorf("expect len(affinityPods)==0; got %d", len(ruler.antiAffinityPods))
 	}
 }
+
+func TestDRSRulerDesiredRulesMultipleTerms(t *testing.T) {
+	ruler := &DRSRuler{
+		affinityPods:     make(map[string]*v1.Pod),
+		antiAffinityPods: make(map[string]*v1.Pod),
+	}
+
+	dbPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{"app": "db"},
+		},
+		Spec: v1.PodSpec{
+			NodeName: "node0",
+		},
+	}
+
+	cachePod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{"app": "cache"},
+		},
+		Spec: v1.PodSpec{
+			NodeName: "node1",
+		},
+	}
+
+	terms := []v1.PodAffinityTerm{
+		{
+			LabelSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": "db"},
+			},
+			TopologyKey: constants.HostLabel,
+		},
+		{
+			LabelSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": "cache"},
+			},
+			TopologyKey: constants.HostLabel,
+		},
+	}
+
+	affinityPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			UID: types.UID("pod-uid1"),
+		},
+		Spec: v1.PodSpec{
+			NodeName: "node2",
+			Affinity: &v1.Affinity{
+				PodAffinity: &v1.PodAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: terms,
+				},
+			},
+		},
+	}
+
+	antiAffinityPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			UID: types.UID("pod-uid2"),
+		},
+		Spec: v1.PodSpec{
+			NodeName: "node3",
+			Affinity: &v1.Affinity{
+				PodAntiAffinity: &v1.PodAntiAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: terms,
+				},
+			},
+		},
+	}
+
+	ruler.OnAdd(affinityPod)
+	ruler.OnAdd(antiAffinityPod)
+
+	ruler.podLister = fake.NewPodLister([]*v1.Pod{
+		dbPod,
+		cachePod,
+		affinityPod,
+		antiAffinityPod,
+	})
+	ruler.bcache = test.FakeBCache(map[string]string{
+		"node0": "vm0",
+		"node1": "vm1",
+		"node2": "vm2",
+		"node3": "vm3",
+	})
+
+	rules := ruler.desiredRules()
+
+	// each term is matched by a different pod; the affine VMs form one
+	// rule, the anti-affine VM is kept apart from each term separately
+	expected := map[string]vsphere.Rule{
+		"affi-pod-uid1": vsphere.Rule{
+			Name:     "affi-pod-uid1",
+			VMs:      []string{"vm0", "vm1", "vm2"},
+			Affinity: true,
+		},
+		"anti-pod-uid2-0": vsphere.Rule{
+			Name:     "anti-pod-uid2-0",
+			VMs:      []string{"vm0", "vm3"},
+			Affinity: false,
+		},
+		"anti-pod-uid2-1": vsphere.Rule{
+			Name:     "anti-pod-uid2-1",
+			VMs:      []string{"vm1", "vm3"},
+			Affinity: false,
+		},
+	}
+
+	for _, rule := range rules {
+		sort.Strings(rule.VMs)
+	}
+	if !reflect.DeepEqual(expected, rules) {
+		t.Errorf("expected desiredRules=%+v; got %+v", expected, rules)
+	}
+}
+
+// fakeHostGroupClient places vmids on hosts, and hosts into host groups
+type fakeHostGroupClient struct {
+	vsphere.Vsphere
+	hosts      map[string]string
+	hostGroups map[string][]string
+}
+
+func (c *fakeHostGroupClient) GetHostFromVMID(vmid string) (string, error) {
+	return c.hosts[vmid], nil
+}
+
+func (c *fakeHostGroupClient) HostGroups() map[string][]string {
+	return c.hostGroups
+}
+
+func TestDRSRulerDesiredRulesHostGroup(t *testing.T) {
+	ruler := &DRSRuler{
+		affinityPods:     make(map[string]*v1.Pod),
+		antiAffinityPods: make(map[string]*v1.Pod),
+	}
+
+	etcdPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{"app": "etcd"},
+		},
+		Spec: v1.PodSpec{
+			NodeName: "node1",
+		},
+	}
+
+	terms := []v1.PodAffinityTerm{
+		{
+			LabelSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": "etcd"},
+			},
+			TopologyKey: constants.HostGroupLabel,
+		},
+	}
+
+	affinityPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			UID: types.UID("pod-uid1"),
+		},
+		Spec: v1.PodSpec{
+			NodeName: "node2",
+			Affinity: &v1.Affinity{
+				PodAffinity: &v1.PodAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: terms,
+				},
+			},
+		},
+	}
+
+	antiAffinityPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			UID: types.UID("pod-uid2"),
+		},
+		Spec: v1.PodSpec{
+			NodeName: "node3",
+			Affinity: &v1.Affinity{
+				PodAntiAffinity: &v1.PodAntiAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: terms,
+				},
+			},
+		},
+	}
+
+	ruler.OnAdd(affinityPod)
+	ruler.OnAdd(antiAffinityPod)
+
+	ruler.podLister = fake.NewPodLister([]*v1.Pod{
+		etcdPod,
+		affinityPod,
+		antiAffinityPod,
+	})
+	ruler.bcache = test.FakeBCache(map[string]string{
+		"node1": "vm1",
+		"node2": "vm2",
+		"node3": "vm3",
+	})
+	ruler.vsclient = &fakeHostGroupClient{
+		hosts: map[string]string{
+			"vm1": "esx-01",
+			"vm2": "esx-02",
+			"vm3": "esx-03",
+		},
+		hostGroups: map[string][]string{
+			"rack-a": {"esx-01", "esx-02"},
+			"rack-b": {"esx-03"},
+		},
+	}
+
+	rules := ruler.desiredRules()
+
+	// the affine VM stays with vm1 in rack-a, the anti-affine VM stays out
+	// of rack-a
+	expected := map[string]vsphere.Rule{
+		"affi-pod-uid1-0": vsphere.Rule{
+			Name:      "affi-pod-uid1-0",
+			VMs:       []string{"vm1", "vm2"},
+			Affinity:  true,
+			HostGroup: "rack-a",
+		},
+		"anti-pod-uid2-0-rack-a": vsphere.Rule{
+			Name:      "anti-pod-uid2-0-rack-a",
+			VMs:       []string{"vm3"},
+			Affinity:  false,
+			HostGroup: "rack-a",
+		},
+	}
+
+	for _, rule := range rules {
+		sort.Strings(rule.VMs)
+	}
+	if !reflect.DeepEqual(expected, rules) {
+		t.Errorf("expected desiredRules=%+v; got %+v", expected, rules)
+	}
+}
+
+// fakeRuleClient serves rules and records the deleted ones
+type fakeRuleClient struct {
+	vsphere.Vsphere
+	rules   map[string]vsphere.Rule
+	deleted []string
+}
+
+func (c *fakeRuleClient) Rules() map[string]vsphere.Rule {
+	return c.rules
+}
+
+func (c *fakeRuleClient) DeleteAffinityRule(name string) error {
+	c.deleted = append(c.deleted, name)
+	return nil
+}
+
+func (c *fakeRuleClient) DeleteAntiAffinityRule(name string) error {
+	c.deleted = append(c.deleted, name)
+	return nil
+}
+
+func (c *fakeRuleClient) DeleteHostGroupRule(name string) error {
+	c.deleted = append(c.deleted, name)
+	return nil
+}
+
+func TestDRSRulerSyncKeepsForeignRules(t *testing.T) {
+	client := &fakeRuleClient{
+		rules: map[string]vsphere.Rule{
+			"affi-pod-uid1-0":        {Name: "affi-pod-uid1-0", VMs: []string{"vm1", "vm2"}, Affinity: true},
+			"anti-pod-uid2-0-rack-a": {Name: "anti-pod-uid2-0-rack-a", VMs: []string{"vm3"}, HostGroup: "rack-a"},
+			"keep-db-apart":          {Name: "keep-db-apart", VMs: []string{"vm4", "vm5"}},
+			"db-on-rack-b":           {Name: "db-on-rack-b", VMs: []string{"vm4"}, Affinity: true, HostGroup: "rack-b"},
+		},
+	}
+	ruler := &DRSRuler{
+		vsclient:         client,
+		affinityPods:     make(map[string]*v1.Pod),
+		antiAffinityPods: make(map[string]*v1.Pod),
+	}
+
+	ruler.sync()
+
+	sort.Strings(client.deleted)
+	expected := []string{"affi-pod-uid1-0", "anti-pod-uid2-0-rack-a"}
+	if !reflect.DeepEqual(expected, client.deleted) {
+		t.Errorf("expected deleted rules %v; got %v", expected, client.deleted)
+	}
+}
@@ -23,3 +23,13 @@ type FakeBCache map[string]string
 func (c FakeBCache) GetVMIDFromNode(name string) string {
 	return c[name]
 }
+
+// GetNodeFromVMID returns the kubernetes node name from vmid
+func (c FakeBCache) GetNodeFromVMID(vmid string) string {
+	for name, id := range c {
+		if id == vmid {
+			return name
+		}
+	}
+	return ""
+}
@@ -22,19 +22,26 @@ import (
 	"fmt"
 	"log"
 	"sync"
+	"time"
 
 	"github.com/vmware/govmomi"
 	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/property"
-	"github.com/vmware/govmomi/view"
-	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/types"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/metrics"
 )
 
 // FIXME: there is a small window of race condition in this implementation
 // where the cache of rules is not yet update-to-date, where a delete request
 // is coming in, the rule won't be deleted. But retry will work.
 
+const (
+	// reconfigureTimeout bounds a reconfiguration of a cluster, including the
+	// wait for its task, so that a dropped connection or a stuck task does
+	// not block the rules forever
+	reconfigureTimeout = 5 * time.Minute
+)
+
 var (
 	// ErrAffinityRuleDupKey is raised when the name of the affinity rule
 	// conflicts with another one in system that has already been enabled.
@@ -45,132 +52,210 @@ var (
 // should be assigned with a unique name, if name is duplicated, client Will
 // not accept the new rule.
 type affinityClient struct {
-	client  *govmomi.Client
-	ctx     context.Context
+	session     *session
+	ctx         context.Context
+	clusterName string
+
+	// cluster is looked up once logged in, guarded by rulesLock
 	cluster types.ManagedObjectReference
 
-	rules     map[int32]*types.ClusterRuleInfo
-	ruleKey   map[string]int32
-	rrules    map[string]Rule
-	rulesLock sync.RWMutex
+	// synced is set while the rules are watched, guarded by rulesLock
+	synced bool
+
+	rules      map[int32]*types.ClusterRuleInfo
+	ruleKey    map[string]int32
+	rrules     map[string]Rule
+	hostGroups map[string][]string
+	rulesLock  sync.RWMutex
+
+	// reconfigureCluster applies a spec to the cluster and waits for the
+	// task, within reconfigureTimeout
+	reconfigureCluster func(ctx context.Context, client *govmomi.Client, ref types.ManagedObjectReference,
+		spec *types.ClusterConfigSpecEx) error
+	reconfigureTimeout time.Duration
+
+	// names of the hosts of the cluster, and the hosts of the host groups,
+	// which are only touched by the watch
+	hostNames     map[types.ManagedObjectReference]string
+	hostGroupRefs map[string][]types.ManagedObjectReference
 }
 
-// Rule represents a VM-to-VM affinity/anti-affinity rule
+// Rule represents a VM-to-VM affinity/anti-affinity rule, or a VM-to-Host
+// rule if HostGroup is set.
 type Rule struct {
 	Name     string
 	VMs      []string
 	Affinity bool
+
+	// HostGroup is the DRS host group the VMs must run on if Affinity is
+	// true, or must not run on otherwise.
+	HostGroup string
 }
 
-func newAffinityClient(clusterName string) *affinityClient {
-	ctx := context.Background()
-	vsclient, err := NewClient(ctx)
-	if err != nil {
-		log.Fatal(err)
+func newAffinityClient(session *session, clusterName string) *affinityClient {
+	return &affinityClient{
+		session:     session,
+		ctx:         context.Background(),
+		clusterName: clusterName,
+		hostNames:   make(map[types.ManagedObjectReference]string),
+
+		reconfigureCluster: reconfigureCluster,
+		reconfigureTimeout: reconfigureTimeout,
 	}
+}
 
-	clt := &affinityClient{
-		client: vsclient,
-		ctx:    ctx,
+// Rules returns a copy of the rules of the cluster by name
+func (c *affinityClient) Rules() map[string]Rule {
+	c.rulesLock.RLock()
+	defer c.rulesLock.RUnlock()
+
+	result := make(map[string]Rule, len(c.rrules))
+	for name, rule := range c.rrules {
+		result[name] = rule
 	}
+	return result
+}
 
-	// Init cluster object
-	m := view.NewManager(vsclient.Client)
+func (c *affinityClient) HostGroups() map[string][]string {
+	c.rulesLock.RLock()
+	defer c.rulesLock.RUnlock()
+	return c.hostGroups
+}
 
-	v, err := m.CreateContainerView(ctx, vsclient.ServiceContent.RootFolder, []string{"ClusterComputeResource"}, true)
+// HasSynced returns true while the rules are watched
+func (c *affinityClient) HasSynced() bool {
+	c.rulesLock.RLock()
+	defer c.rulesLock.RUnlock()
+	return c.synced
+}
+
+// filter watches the rules of the cluster, every update carries all of them,
+// and the names of its hosts
+func (c *affinityClient) filter(ctx context.Context, client *govmomi.Client) (types.PropertyFilterSpec, func(), error) {
+	cluster, err := c.session.Cluster(ctx, client, c.clusterName)
 	if err != nil {
-		log.Fatal(err)
+		return types.PropertyFilterSpec{}, nil, err
 	}
 
-	defer v.Destroy(ctx)
+	c.rulesLock.Lock()
+	c.cluster = cluster
+	c.rulesLock.Unlock()
 
-	var clusters []mo.ClusterComputeResource
-	err = v.Retrieve(ctx, []string{"ClusterComputeResource"}, []string{"name"}, &clusters)
-	if err != nil {
-		log.Fatal(err)
+	filter := new(property.WaitFilter)
+	filter.Add(cluster.Reference(), "ClusterComputeResource", []string{"configurationEx"},
+		&types.TraversalSpec{Type: "ClusterComputeResource", Path: "host"})
+	filter.Spec.PropSet = append(filter.Spec.PropSet, types.PropertySpec{
+		Type:    "HostSystem",
+		PathSet: []string{"name"},
+	})
+
+	return filter.Spec, func() {}, nil
+}
+
+// update keeps the key to rules in sync
+func (c *affinityClient) update(client *govmomi.Client, updates []types.ObjectUpdate, list bool) {
+	if list {
+		c.hostNames = make(map[types.ManagedObjectReference]string)
 	}
 
-	for i := range clusters {
-		if clusters[i].Name == clusterName {
-			clt.cluster = clusters[i].Reference()
-			break
+	for _, update := range updates {
+		if update.Obj.Type == "HostSystem" {
+			c.updateHost(update)
+			continue
 		}
-	}
 
-	if clt.cluster.Type == "" {
-		log.Printf("[WARNING] cannot find cluster named %s", clusterName)
-	}
+		switch update.Kind {
+		case types.ObjectUpdateKindModify:
+			fallthrough
+		case types.ObjectUpdateKindEnter:
+			rules := make(map[int32]*types.ClusterRuleInfo)
+			ruleKey := make(map[string]int32)
+			rrules := make(map[string]Rule)
+			hostGroupRefs := make(map[string][]types.ManagedObjectReference)
+			vmGroups := make(map[string][]string)
+
+			for _, cs := range update.ChangeSet {
+				config := cs.Val.(types.ClusterConfigInfoEx)
+				for _, group := range config.Group {
+					switch group := group.(type) {
+					case *types.ClusterHostGroup:
+						hostGroupRefs[group.Name] = group.Host
+					case *types.ClusterVmGroup:
+						vms := []string{}
+						for _, vm := range group.Vm {
+							vms = append(vms, vm.String())
+						}
+						vmGroups[group.Name] = vms
+					}
+				}
 
-	return clt
-}
+				for _, rule := range config.Rule {
 
-func (c *affinityClient) Rules() map[string]Rule {
-	return c.rrules
-}
+					info := rule.GetClusterRuleInfo()
+					rules[info.Key] = info
+					ruleKey[info.Name] = info.Key
 
-// Run runs in background keep the key to rules in sync
-func (c *affinityClient) Run(stopCh <-chan struct{}) error {
-	ctx := context.Background()
-	filter := new(property.WaitFilter)
-	filter.Add(c.cluster.Reference(), "ClusterComputeResource", []string{"configurationEx"})
-
-	err := property.WaitForUpdates(ctx, c.client.PropertyCollector(), filter, func(updates []types.ObjectUpdate) bool {
-		for _, update := range updates {
-			switch update.Kind {
-			case types.ObjectUpdateKindModify:
-				fallthrough
-			case types.ObjectUpdateKindEnter:
-				rules := make(map[int32]*types.ClusterRuleInfo)
-				ruleKey := make(map[string]int32)
-				rrules := make(map[string]Rule)
-
-				for _, cs := range update.ChangeSet {
-					config := cs.Val.(types.ClusterConfigInfoEx)
-					for _, rule := range config.Rule {
-
-						info := rule.GetClusterRuleInfo()
-						rules[info.Key] = info
-						ruleKey[info.Name] = info.Key
-
-						theRule := Rule{
-							Name: info.Name,
+					theRule := Rule{
+						Name: info.Name,
+					}
+					switch rule.(type) {
+					case *types.ClusterAffinityRuleSpec:
+						theRule.Affinity = true
+						for _, vm := range rule.(*types.ClusterAffinityRuleSpec).Vm {
+							theRule.VMs = append(theRule.VMs, vm.String())
+						}
+					case *types.ClusterAntiAffinityRuleSpec:
+						theRule.Affinity = false
+						for _, vm := range rule.(*types.ClusterAntiAffinityRuleSpec).Vm {
+							theRule.VMs = append(theRule.VMs, vm.String())
 						}
-						switch rule.(type) {
-						case *types.ClusterAffinityRuleSpec:
-							theRule.Affinity = true
-							for _, vm := range rule.(*types.ClusterAffinityRuleSpec).Vm {
-								theRule.VMs = append(theRule.VMs, vm.String())
-							}
-						case *types.ClusterAntiAffinityRuleSpec:
-							theRule.Affinity = false
-							for _, vm := range rule.(*types.ClusterAntiAffinityRuleSpec).Vm {
-								theRule.VMs = append(theRule.VMs, vm.String())
-							}
+					case *types.ClusterVmHostRuleInfo:
+						vmHostRule := rule.(*types.ClusterVmHostRuleInfo)
+						theRule.Affinity = vmHostRule.AffineHostGroupName != ""
+						theRule.HostGroup = vmHostRule.AffineHostGroupName
+						if !theRule.Affinity {
+							theRule.HostGroup = vmHostRule.AntiAffineHostGroupName
 						}
-						rrules[info.Name] = theRule
+						theRule.VMs = vmGroups[vmHostRule.VmGroupName]
 					}
+					rrules[info.Name] = theRule
 				}
-				c.rulesLock.Lock()
-				c.rules = rules
-				c.ruleKey = ruleKey
-				c.rrules = rrules
-				c.rulesLock.Unlock()
-			case types.ObjectUpdateKindLeave:
 			}
+			c.rulesLock.Lock()
+			c.rules = rules
+			c.ruleKey = ruleKey
+			c.rrules = rrules
+			c.synced = true
+			c.rulesLock.Unlock()
+			c.hostGroupRefs = hostGroupRefs
+		case types.ObjectUpdateKindLeave:
 		}
+	}
 
-		log.Println("vsphere-affinity-client: updated rrules", c.rrules)
-
-		select {
-		case <-stopCh:
-			return true
-		default:
+	// The hosts and the groups come in any order, and the hosts are renamed
+	// without the groups changing
+	hostGroups := make(map[string][]string)
+	for group, refs := range c.hostGroupRefs {
+		hosts := []string{}
+		for _, ref := range refs {
+			if name := c.hostNames[ref]; name != "" {
+				hosts = append(hosts, name)
+			}
 		}
+		hostGroups[group] = hosts
+	}
+	c.rulesLock.Lock()
+	c.hostGroups = hostGroups
+	c.rulesLock.Unlock()
 
-		return false
-	})
+	log.Println("vsphere-affinity-client: updated rrules", c.Rules())
+}
 
-	return err
+// stop marks the rules stale until the watch is back
+func (c *affinityClient) stop() {
+	c.rulesLock.Lock()
+	c.synced = false
+	c.rulesLock.Unlock()
 }
 
 func (c *affinityClient) ApplyAffinityRule(name string, vms ...string) error {
@@ -188,8 +273,6 @@ func (c *affinityClient) ApplyAffinityRule(name string, vms ...string) error {
 		morefs[i].FromString(vms[i])
 	}
 
-	cluster := object.NewClusterComputeResource(c.client.Client, c.cluster.Reference())
-
 	spec := &types.ClusterConfigSpecEx{
 		RulesSpec: []types.ClusterRuleSpec{
 			types.ClusterRuleSpec{
@@ -207,12 +290,7 @@ func (c *affinityClient) ApplyAffinityRule(name string, vms ...string) error {
 		},
 	}
 
-	task, err := cluster.Reconfigure(c.ctx, spec, true)
-	if err != nil {
-		return err
-	}
-
-	return task.Wait(c.ctx)
+	return c.reconfigure("apply", spec)
 }
 
 func (c *client) ApplyAntiAffinityRule(name string, vms ...string) error {
@@ -230,8 +308,6 @@ func (c *client) ApplyAntiAffinityRule(name string, vms ...string) error {
 		morefs[i].FromString(vms[i])
 	}
 
-	cluster := object.NewClusterComputeResource(c.client.Client, c.cluster.Reference())
-
 	spec := &types.ClusterConfigSpecEx{
 		RulesSpec: []types.ClusterRuleSpec{
 			types.ClusterRuleSpec{
@@ -249,12 +325,85 @@ func (c *client) ApplyAntiAffinityRule(name string, vms ...string) error {
 		},
 	}
 
-	task, err := cluster.Reconfigure(c.ctx, spec, true)
-	if err != nil {
+	return c.reconfigure("apply", spec)
+}
+
+func (c *affinityClient) ApplyHostGroupRule(name, hostGroup string, affinity bool, vms ...string) error {
+	log.Printf("vsphere: apply host group rule %s on vms %s, host group %s, affinity %v",
+		name, vms, hostGroup, affinity)
+
+	c.rulesLock.RLock()
+	if _, ok := c.ruleKey[name]; ok {
+		c.rulesLock.RUnlock()
+		return ErrAffinityRuleDupKey
+	}
+	c.rulesLock.RUnlock()
+
+	morefs := make([]types.ManagedObjectReference, len(vms))
+	for i := range vms {
+		morefs[i].FromString(vms[i])
+	}
+
+	// The VMs are put into a VM group named after the rule
+	info := &types.ClusterVmHostRuleInfo{
+		ClusterRuleInfo: types.ClusterRuleInfo{
+			Name:      name,
+			Enabled:   addressOfBool(true),
+			Mandatory: addressOfBool(true),
+		},
+		VmGroupName: name,
+	}
+	if affinity {
+		info.AffineHostGroupName = hostGroup
+	} else {
+		info.AntiAffineHostGroupName = hostGroup
+	}
+
+	spec := &types.ClusterConfigSpecEx{
+		GroupSpec: []types.ClusterGroupSpec{
+			types.ClusterGroupSpec{
+				ArrayUpdateSpec: types.ArrayUpdateSpec{
+					Operation: types.ArrayUpdateOperationAdd,
+				},
+				Info: &types.ClusterVmGroup{
+					ClusterGroupInfo: types.ClusterGroupInfo{
+						Name: name,
+					},
+					Vm: morefs,
+				},
+			},
+		},
+		RulesSpec: []types.ClusterRuleSpec{
+			types.ClusterRuleSpec{
+				ArrayUpdateSpec: types.ArrayUpdateSpec{
+					Operation: types.ArrayUpdateOperationAdd,
+				},
+				Info: info,
+			},
+		},
+	}
+
+	return c.reconfigure("apply", spec)
+}
+
+func (c *affinityClient) DeleteHostGroupRule(name string) error {
+	if err := c.deleteRule(name); err != nil {
 		return err
 	}
 
-	return task.Wait(c.ctx)
+	// Remove the VM group of the rule as well
+	spec := &types.ClusterConfigSpecEx{
+		GroupSpec: []types.ClusterGroupSpec{
+			types.ClusterGroupSpec{
+				ArrayUpdateSpec: types.ArrayUpdateSpec{
+					Operation: types.ArrayUpdateOperationRemove,
+					RemoveKey: name,
+				},
+			},
+		},
+	}
+
+	return c.reconfigure("delete", spec)
 }
 
 func (c *affinityClient) DeleteAffinityRule(name string) error {
@@ -279,8 +428,6 @@ func (c *affinityClient) deleteRule(name string) error {
 	// sync with all the rules and the key mapping to the rules
 	log.Printf("vsphere: delete affinity rule with key %d", key)
 
-	cluster := object.NewClusterComputeResource(c.client.Client, c.cluster.Reference())
-
 	spec := &types.ClusterConfigSpecEx{
 		RulesSpec: []types.ClusterRuleSpec{
 			types.ClusterRuleSpec{
@@ -292,12 +439,65 @@ func (c *affinityClient) deleteRule(name string) error {
 		},
 	}
 
-	task, err := cluster.Reconfigure(c.ctx, spec, true)
+	return c.reconfigure("delete", spec)
+}
+
+// reconfigure applies spec to the cluster and waits for the task to finish
+func (c *affinityClient) reconfigure(operation string, spec *types.ClusterConfigSpecEx) error {
+	start := time.Now()
+	defer func() {
+		metrics.VSphereTaskLatency.Observe(metrics.SinceInSeconds(start), operation)
+	}()
+
+	client := c.session.Client()
+	if client == nil {
+		return errNotLoggedIn
+	}
+
+	c.rulesLock.RLock()
+	ref := c.cluster
+	c.rulesLock.RUnlock()
+	if ref.Type == "" {
+		return fmt.Errorf("cluster %s is not found yet", c.clusterName)
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.reconfigureTimeout)
+	defer cancel()
+
+	err := c.reconfigureCluster(ctx, client, ref, spec)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		// The connection may have dropped silently
+		c.session.Restart()
+		return fmt.Errorf("%s affinity rule timed out after %s: %v", operation, c.reconfigureTimeout, err)
+	}
+	return err
+}
+
+// reconfigureCluster applies spec to the cluster ref and waits for the task
+func reconfigureCluster(ctx context.Context, client *govmomi.Client, ref types.ManagedObjectReference,
+	spec *types.ClusterConfigSpecEx) error {
+	cluster := object.NewClusterComputeResource(client.Client, ref)
+
+	task, err := cluster.Reconfigure(ctx, spec, true)
 	if err != nil {
 		return err
 	}
 
-	return task.Wait(c.ctx)
+	return task.Wait(ctx)
+}
+
+// updateHost keeps the name of a host of the cluster
+func (c *affinityClient) updateHost(update types.ObjectUpdate) {
+	if update.Kind == types.ObjectUpdateKindLeave {
+		delete(c.hostNames, update.Obj)
+		return
+	}
+
+	for _, cs := range update.ChangeSet {
+		if name, ok := cs.Val.(string); ok && cs.Name == "name" {
+			c.hostNames[update.Obj] = name
+		}
+	}
 }
 
 func addressOfBool(v bool) *bool {
@@ -17,20 +17,28 @@ limitations under the License.
 package vsphere
 
 import (
+	"context"
 	"fmt"
+	"net/url"
+	"reflect"
 	"testing"
 	"time"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/vim25/types"
 )
 
 func exampleAffinityRuleClient(t *testing.T) {
-	client := newAffinityClient("cluster1")
-	stopCh := make(chan struct{})
-	var err error
-
-	go func() {
-		_ = client.Run(stopCh)
-	}()
+	endpoint, credentials, err := parseEndpoint(*urlFlag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := newSession(endpoint, *insecureFlag, credentials)
+	client := newAffinityClient(session, "cluster1")
+	session.Watch("rules", client)
+	session.Start()
 
+	stopCh := make(chan struct{})
 	go func() {
 		time.Sleep(5 * time.Second)
 		close(stopCh)
@@ -44,4 +52,133 @@ func exampleAffinityRuleClient(t *testing.T) {
 
 	err = client.DeleteAffinityRule("affinity-1")
 	fmt.Println(err)
+
+	session.Stop()
+}
+
+func TestAffinityClientHostGroups(t *testing.T) {
+	c := newAffinityClient(nil, "cluster1")
+	cluster := types.ManagedObjectReference{Type: "ClusterComputeResource", Value: "domain-c1"}
+	hostName := func(kind types.ObjectUpdateKind, value, name string) types.ObjectUpdate {
+		return types.ObjectUpdate{Kind: kind, Obj: hostRef(value), ChangeSet: []types.PropertyChange{
+			{Name: "name", Val: name},
+		}}
+	}
+
+	// the groups come before the hosts
+	c.update(nil, []types.ObjectUpdate{
+		{Kind: types.ObjectUpdateKindEnter, Obj: cluster, ChangeSet: []types.PropertyChange{
+			{Name: "configurationEx", Val: types.ClusterConfigInfoEx{
+				Group: []types.BaseClusterGroupInfo{
+					&types.ClusterHostGroup{
+						ClusterGroupInfo: types.ClusterGroupInfo{Name: "group1"},
+						Host:             []types.ManagedObjectReference{hostRef("host-1"), hostRef("host-2")},
+					},
+				},
+			}},
+		}},
+		hostName(types.ObjectUpdateKindEnter, "host-1", "esx1"),
+		hostName(types.ObjectUpdateKindEnter, "host-2", "esx2"),
+	}, true)
+
+	expect := map[string][]string{"group1": {"esx1", "esx2"}}
+	if groups := c.HostGroups(); !reflect.DeepEqual(groups, expect) {
+		t.Errorf("expect host groups %v; got %v", expect, groups)
+	}
+
+	// a host is renamed, another one leaves
+	c.update(nil, []types.ObjectUpdate{
+		hostName(types.ObjectUpdateKindModify, "host-1", "esx3"),
+		{Kind: types.ObjectUpdateKindLeave, Obj: hostRef("host-2")},
+	}, false)
+
+	expect = map[string][]string{"group1": {"esx3"}}
+	if groups := c.HostGroups(); !reflect.DeepEqual(groups, expect) {
+		t.Errorf("expect host groups %v; got %v", expect, groups)
+	}
+}
+
+func TestAffinityClientReconfigureTimeout(t *testing.T) {
+	s := newSession(&url.URL{Host: "vc1"}, false, &urlCredentials{})
+	s.client = &govmomi.Client{}
+	s.setErr(nil)
+	_, lost, _ := s.Wait(nil)
+
+	c := newAffinityClient(s, "cluster1")
+	c.cluster = types.ManagedObjectReference{Type: "ClusterComputeResource", Value: "domain-c1"}
+	c.reconfigureTimeout = 10 * time.Millisecond
+	// the task never completes
+	c.reconfigureCluster = func(ctx context.Context, client *govmomi.Client, ref types.ManagedObjectReference,
+		spec *types.ClusterConfigSpecEx) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- c.reconfigure("create", &types.ClusterConfigSpecEx{})
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("expect a timeout error")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("expect the reconfiguration to time out")
+	}
+
+	// the watches start over, and the session is checked
+	select {
+	case <-lost:
+	default:
+		t.Errorf("expect the watches to be stopped")
+	}
+	select {
+	case <-s.wake:
+	default:
+		t.Errorf("expect the session to be woken up")
+	}
+}
+
+func TestAffinityClientRules(t *testing.T) {
+	c := newAffinityClient(nil, "cluster1")
+	cluster := types.ManagedObjectReference{Type: "ClusterComputeResource", Value: "domain-c1"}
+	update := func(names ...string) {
+		var config types.ClusterConfigInfoEx
+		for i, name := range names {
+			config.Rule = append(config.Rule, &types.ClusterAffinityRuleSpec{
+				ClusterRuleInfo: types.ClusterRuleInfo{Key: int32(i), Name: name},
+			})
+		}
+		c.update(nil, []types.ObjectUpdate{
+			{Kind: types.ObjectUpdateKindModify, Obj: cluster, ChangeSet: []types.PropertyChange{
+				{Name: "configurationEx", Val: config},
+			}},
+		}, false)
+	}
+
+	// the rules are read while the watch updates them
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			update(fmt.Sprintf("affi-%d", i))
+		}
+		close(done)
+	}()
+	for i := 0; i < 100; i++ {
+		c.Rules()
+	}
+	<-done
+
+	rules := c.Rules()
+	if _, ok := rules["affi-99"]; !ok || len(rules) != 1 {
+		t.Errorf("expect rule affi-99; got %v", rules)
+	}
+
+	// the rules returned are a copy
+	delete(rules, "affi-99")
+	if _, ok := c.Rules()["affi-99"]; !ok {
+		t.Errorf("expect the rules of the client unchanged")
+	}
 }
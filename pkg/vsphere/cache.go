@@ -19,6 +19,8 @@ package vsphere
 import (
 	"context"
 	"log"
+	"net"
+	"strings"
 	"sync"
 
 	"github.com/vmware/govmomi"
@@ -26,33 +28,61 @@ import (
 	"github.com/vmware/govmomi/view"
 	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/types"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/metrics"
 )
 
 type cachedQuerier struct {
-	client *govmomi.Client
+	// root returns the inventory object whose VMs and hosts are watched
+	root func(context.Context, *govmomi.Client) (types.ManagedObjectReference, error)
 
 	sync.Mutex
-	hostnameToVMID map[string]string
 	vmidToHostname map[string]string
 	vmidToHost     map[string]*mo.HostSystem
 
-	// internal cache
-	hostCache map[types.ManagedObjectReference]*mo.HostSystem
-}
+	// identities of the VMs: guest hostnames, by full and short name, BIOS
+	// UUIDs, both in lower case, and guest IP addresses
+	hostnames *vmIndex
+	uuids     *vmIndex
+	ips       *vmIndex
 
-// newCachedQuerier creates a cached querier
-func newCachedQuerier(client *govmomi.Client, stopCh <-chan struct{}) Querier {
-	c := &cachedQuerier{
-		client:         client,
-		hostnameToVMID: make(map[string]string),
-		vmidToHostname: make(map[string]string),
-		vmidToHost:     make(map[string]*mo.HostSystem),
-		hostCache:      make(map[types.ManagedObjectReference]*mo.HostSystem),
-	}
+	// datastores the VMs are stored on
+	vmidToDatastores map[string][]types.ManagedObjectReference
+	datastoreToVMIDs map[types.ManagedObjectReference]map[string]struct{}
+
+	// hosts and datastore names, as watched along with the VMs
+	hostCache      map[types.ManagedObjectReference]*mo.HostSystem
+	datastoreNames map[types.ManagedObjectReference]string
+
+	// runtime state of the ESX servers
+	hostStates map[types.ManagedObjectReference]*HostState
 
-	go c.Run(stopCh)
+	// recent tasks of the ESX servers, and their states
+	hostTasks map[types.ManagedObjectReference][]types.ManagedObjectReference
+	tasks     map[types.ManagedObjectReference]*mo.Task
 
-	return c
+	// synced is set once the first batch of updates is received
+	synced bool
+}
+
+// newCachedQuerier creates a cached querier of the VMs and hosts under root,
+// such as the root folder or a cluster. It is kept up to date once added to
+// a session with Watch.
+func newCachedQuerier(root func(context.Context, *govmomi.Client) (types.ManagedObjectReference, error)) *cachedQuerier {
+	return &cachedQuerier{
+		root:             root,
+		vmidToHostname:   make(map[string]string),
+		vmidToHost:       make(map[string]*mo.HostSystem),
+		hostnames:        newVMIndex(),
+		uuids:            newVMIndex(),
+		ips:              newVMIndex(),
+		vmidToDatastores: make(map[string][]types.ManagedObjectReference),
+		datastoreToVMIDs: make(map[types.ManagedObjectReference]map[string]struct{}),
+		hostCache:        make(map[types.ManagedObjectReference]*mo.HostSystem),
+		datastoreNames:   make(map[types.ManagedObjectReference]string),
+		hostStates:       make(map[types.ManagedObjectReference]*HostState),
+		hostTasks:        make(map[types.ManagedObjectReference][]types.ManagedObjectReference),
+		tasks:            make(map[types.ManagedObjectReference]*mo.Task),
+	}
 }
 
 func (c *cachedQuerier) GetHostnameFromVMID(vmid string) string {
@@ -61,10 +91,44 @@ func (c *cachedQuerier) GetHostnameFromVMID(vmid string) string {
 	return c.vmidToHostname[vmid]
 }
 
-func (c *cachedQuerier) GetVMIDFromHostname(hostname string) string {
+func (c *cachedQuerier) GetVMIDsFromHostname(hostname string) []string {
+	c.Lock()
+	defer c.Unlock()
+	return c.hostnames.lookup(strings.ToLower(hostname))
+}
+
+func (c *cachedQuerier) GetUUIDFromVMID(vmid string) string {
 	c.Lock()
 	defer c.Unlock()
-	return c.hostnameToVMID[hostname]
+
+	if uuids := c.uuids.get(vmid); len(uuids) > 0 {
+		return uuids[0]
+	}
+	return ""
+}
+
+func (c *cachedQuerier) GetVMIDsFromUUID(uuid string) []string {
+	c.Lock()
+	defer c.Unlock()
+	return c.uuids.lookup(strings.ToLower(uuid))
+}
+
+func (c *cachedQuerier) GetIPsFromVMID(vmid string) []string {
+	c.Lock()
+	defer c.Unlock()
+	return c.ips.get(vmid)
+}
+
+func (c *cachedQuerier) GetVMIDsFromIP(ip string) []string {
+	c.Lock()
+	defer c.Unlock()
+	return c.ips.lookup(ip)
+}
+
+func (c *cachedQuerier) HasSynced() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.synced
 }
 
 func (c *cachedQuerier) GetHostFromVMID(vmid string) (string, error) {
@@ -77,77 +141,342 @@ func (c *cachedQuerier) GetHostFromVMID(vmid string) (string, error) {
 	return "", nil
 }
 
-func (c *cachedQuerier) Run(stopCh <-chan struct{}) {
-	// Create view of VirtualMachine objects
-	m := view.NewManager(c.client.Client)
-	ctx := context.Background()
+func (c *cachedQuerier) GetDatastoresFromVMID(vmid string) []string {
+	c.Lock()
+	defer c.Unlock()
+
+	var result []string
+	for _, ref := range c.vmidToDatastores[vmid] {
+		if name := c.datastoreNames[ref]; name != "" {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+func (c *cachedQuerier) GetVMIDsFromDatastore(datastore string) []string {
+	c.Lock()
+	defer c.Unlock()
+
+	result := []string{}
+	for ref, name := range c.datastoreNames {
+		if name != datastore {
+			continue
+		}
+		for vmid := range c.datastoreToVMIDs[ref] {
+			result = append(result, vmid)
+		}
+	}
+	return result
+}
+
+func (c *cachedQuerier) GetHostState(host string) (HostState, bool) {
+	c.Lock()
+	defer c.Unlock()
 
-	v, err := m.CreateContainerView(ctx, c.client.ServiceContent.RootFolder, []string{"VirtualMachine"}, true)
+	for ref, state := range c.hostStates {
+		if h, ok := c.hostCache[ref]; ok && h.Name == host {
+			return *state, true
+		}
+	}
+	return HostState{}, false
+}
+
+// filter watches the VMs and hosts under root, the datastores of the VMs, and
+// the recent tasks of the hosts
+func (c *cachedQuerier) filter(ctx context.Context, client *govmomi.Client) (types.PropertyFilterSpec, func(), error) {
+	root, err := c.root(ctx, client)
+	if err != nil {
+		return types.PropertyFilterSpec{}, nil, err
+	}
+
+	// Create view of VirtualMachine and HostSystem objects
+	m := view.NewManager(client.Client)
+
+	v, err := m.CreateContainerView(ctx, root, []string{"VirtualMachine", "HostSystem"}, true)
 	if err != nil {
-		log.Fatal(err)
+		return types.PropertyFilterSpec{}, nil, err
 	}
 
-	defer v.Destroy(ctx)
+	traversal := v.TraversalSpec()
+	traversal.SelectSet = []types.BaseSelectionSpec{
+		&types.TraversalSpec{Type: "HostSystem", Path: "recentTask"},
+		&types.TraversalSpec{Type: "VirtualMachine", Path: "datastore"},
+	}
 
 	filter := new(property.WaitFilter)
-	filter.Add(v.Reference(), "VirtualMachine", []string{"runtime.host", "summary.guest.hostName"}, v.TraversalSpec())
-
-	property.WaitForUpdates(ctx, c.client.PropertyCollector(), filter, func(updates []types.ObjectUpdate) bool {
-		c.Lock()
-		defer c.Unlock()
-
-		for _, update := range updates {
-			// FIXME: Can I assume PropertyChangeOp is always assign?
-
-			switch update.Kind {
-			case types.ObjectUpdateKindModify:
-				fallthrough
-			case types.ObjectUpdateKindEnter:
-				for _, cs := range update.ChangeSet {
-					log.Printf("vsphere: update %s %s", update.Obj.String(), cs.Name)
-					if cs.Name == "summary.guest.hostName" && cs.Val != nil {
-						hostname := cs.Val.(string)
-						log.Printf("vsphere: cache update, vmid<=>hostname, %s<=>%s", update.Obj.String(), hostname)
-						c.vmidToHostname[update.Obj.String()] = hostname
-						c.hostnameToVMID[hostname] = update.Obj.String()
-					} else if cs.Name == "runtime.host" && cs.Val != nil {
-						moref := cs.Val.(types.ManagedObjectReference)
-						c.vmidToHost[update.Obj.String()] = c.getHost(moref)
+	filter.Add(v.Reference(), "VirtualMachine", []string{"runtime.host", "summary.guest.hostName", "datastore",
+		"config.uuid", "guest.net"}, traversal)
+	filter.Spec.PropSet = append(filter.Spec.PropSet, types.PropertySpec{
+		Type: "HostSystem",
+		PathSet: []string{"name", "runtime.connectionState", "runtime.powerState", "runtime.standbyMode",
+			"runtime.inMaintenanceMode", "recentTask"},
+	}, types.PropertySpec{
+		Type:    "Datastore",
+		PathSet: []string{"name"},
+	}, types.PropertySpec{
+		Type:    "Task",
+		PathSet: []string{"info.descriptionId", "info.state"},
+	})
+
+	return filter.Spec, func() { v.Destroy(context.Background()) }, nil
+}
+
+// update applies a batch of updates, the cache is rebuilt from the first one,
+// which lists all the VMs and hosts.
+func (c *cachedQuerier) update(client *govmomi.Client, updates []types.ObjectUpdate, list bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	if list {
+		c.reset()
+	}
+
+	// The states of the hosts and of their tasks come in any order
+	defer c.updateMaintenance()
+
+	for _, update := range updates {
+		metrics.QuerierUpdates.Inc(string(update.Kind))
+
+		// FIXME: Can I assume PropertyChangeOp is always assign?
+
+		switch update.Obj.Type {
+		case "HostSystem":
+			c.updateHost(update)
+			continue
+		case "Task":
+			c.updateTask(update)
+			continue
+		case "Datastore":
+			c.updateDatastore(update)
+			continue
+		}
+
+		switch update.Kind {
+		case types.ObjectUpdateKindModify:
+			fallthrough
+		case types.ObjectUpdateKindEnter:
+			for _, cs := range update.ChangeSet {
+				log.Printf("vsphere: update %s %s", update.Obj.String(), cs.Name)
+				if cs.Name == "summary.guest.hostName" && cs.Val != nil {
+					hostname := cs.Val.(string)
+					log.Printf("vsphere: cache update, vmid<=>hostname, %s<=>%s", update.Obj.String(), hostname)
+					c.vmidToHostname[update.Obj.String()] = hostname
+					c.hostnames.set(update.Obj.String(), HostnameKeys(hostname))
+				} else if cs.Name == "config.uuid" && cs.Val != nil {
+					uuid := strings.ToLower(cs.Val.(string))
+					log.Printf("vsphere: cache update, vmid=>uuid, %s=>%s", update.Obj.String(), uuid)
+					c.uuids.set(update.Obj.String(), []string{uuid})
+				} else if cs.Name == "guest.net" {
+					var ips []string
+					if nics, ok := cs.Val.(types.ArrayOfGuestNicInfo); ok {
+						for _, nic := range nics.GuestNicInfo {
+							ips = append(ips, nic.IpAddress...)
+						}
 					}
+					log.Printf("vsphere: cache update, vmid=>ips, %s=>%v", update.Obj.String(), ips)
+					c.ips.set(update.Obj.String(), ips)
+				} else if cs.Name == "runtime.host" && cs.Val != nil {
+					moref := cs.Val.(types.ManagedObjectReference)
+					c.vmidToHost[update.Obj.String()] = c.getHost(moref)
+				} else if cs.Name == "datastore" && cs.Val != nil {
+					morefs := cs.Val.(types.ArrayOfManagedObjectReference).ManagedObjectReference
+					c.setDatastores(update.Obj.String(), morefs)
 				}
-			case types.ObjectUpdateKindLeave:
-				log.Printf("vsphere: delete %s", update.Obj.String())
-				if hostname, ok := c.vmidToHostname[update.Obj.String()]; ok {
-					delete(c.vmidToHostname, update.Obj.String())
-					delete(c.hostnameToVMID, hostname)
-				}
-				delete(c.vmidToHost, update.Obj.String())
 			}
+		case types.ObjectUpdateKindLeave:
+			log.Printf("vsphere: delete %s", update.Obj.String())
+			delete(c.vmidToHostname, update.Obj.String())
+			c.hostnames.set(update.Obj.String(), nil)
+			c.uuids.set(update.Obj.String(), nil)
+			c.ips.set(update.Obj.String(), nil)
+			delete(c.vmidToHost, update.Obj.String())
+			c.setDatastores(update.Obj.String(), nil)
 		}
+	}
 
-		select {
-		case <-stopCh:
-			return true
-		default:
-		}
+	c.synced = true
+}
 
-		return false // keep waiting
-	})
+// stop marks the cache stale until the watch is back
+func (c *cachedQuerier) stop() {
+	c.Lock()
+	defer c.Unlock()
+	c.synced = false
+}
+
+// reset clears the cache before a full list, dropping the VMs and hosts that
+// went away during an outage. Caller needs to own the lock.
+func (c *cachedQuerier) reset() {
+	c.vmidToHostname = make(map[string]string)
+	c.hostnames = newVMIndex()
+	c.uuids = newVMIndex()
+	c.ips = newVMIndex()
+	c.vmidToHost = make(map[string]*mo.HostSystem)
+	c.vmidToDatastores = make(map[string][]types.ManagedObjectReference)
+	c.datastoreToVMIDs = make(map[types.ManagedObjectReference]map[string]struct{})
+	c.hostCache = make(map[types.ManagedObjectReference]*mo.HostSystem)
+	c.datastoreNames = make(map[types.ManagedObjectReference]string)
+	c.hostStates = make(map[types.ManagedObjectReference]*HostState)
+	c.hostTasks = make(map[types.ManagedObjectReference][]types.ManagedObjectReference)
+	c.tasks = make(map[types.ManagedObjectReference]*mo.Task)
 }
 
+// HostnameKeys returns the keys a VM is found by its hostname, the hostname
+// and its short name in lower case, so that FQDNs match short names.
+func HostnameKeys(hostname string) []string {
+	if hostname == "" {
+		return nil
+	}
+
+	hostname = strings.ToLower(hostname)
+	keys := []string{hostname}
+	if i := strings.Index(hostname, "."); i > 0 && net.ParseIP(hostname) == nil {
+		keys = append(keys, hostname[:i])
+	}
+	return keys
+}
+
+// getHost returns the cached HostSystem of ref, whose name is set once the
+// host is listed, possibly later in the same batch. Caller needs to own the
+// lock.
 func (c *cachedQuerier) getHost(ref types.ManagedObjectReference) *mo.HostSystem {
-	if host, ok := c.hostCache[ref]; ok {
-		return host
+	host, ok := c.hostCache[ref]
+	if !ok {
+		host = &mo.HostSystem{}
+		host.Self = ref
+		c.hostCache[ref] = host
 	}
+	return host
+}
 
-	pc := property.DefaultCollector(c.client.Client)
-	dst := &mo.HostSystem{}
-	err := pc.RetrieveOne(context.Background(), ref, []string{"name"}, dst)
-	if err != nil {
-		log.Printf("vsphere: failed to retrieve hostsystem info")
-		return dst
+// updateHost applies an update of a HostSystem object. Caller needs to own
+// the lock.
+func (c *cachedQuerier) updateHost(update types.ObjectUpdate) {
+	ref := update.Obj
+	if update.Kind == types.ObjectUpdateKindLeave {
+		log.Printf("vsphere: delete %s", ref.String())
+		delete(c.hostStates, ref)
+		delete(c.hostTasks, ref)
+		return
+	}
+
+	// The VMs share the cached HostSystem, so the name is updated in place
+	host := c.getHost(ref)
+
+	state, ok := c.hostStates[ref]
+	if !ok {
+		state = &HostState{}
+		c.hostStates[ref] = state
+	}
+
+	for _, cs := range update.ChangeSet {
+		switch cs.Name {
+		case "name":
+			if name, ok := cs.Val.(string); ok {
+				host.Name = name
+			}
+		case "runtime.connectionState":
+			state.ConnectionState, _ = cs.Val.(types.HostSystemConnectionState)
+		case "runtime.powerState":
+			state.PowerState, _ = cs.Val.(types.HostSystemPowerState)
+		case "runtime.standbyMode":
+			state.StandbyMode, _ = cs.Val.(string)
+		case "runtime.inMaintenanceMode":
+			state.InMaintenanceMode, _ = cs.Val.(bool)
+		case "recentTask":
+			var tasks []types.ManagedObjectReference
+			if refs, ok := cs.Val.(types.ArrayOfManagedObjectReference); ok {
+				tasks = refs.ManagedObjectReference
+			}
+			c.hostTasks[ref] = tasks
+		}
 	}
 
-	c.hostCache[ref] = dst
-	return dst
+	log.Printf("vsphere: cache update, host %s state %+v", host.Name, *state)
+}
+
+// updateTask applies an update of a recent task of a host. Caller needs to own
+// the lock.
+func (c *cachedQuerier) updateTask(update types.ObjectUpdate) {
+	ref := update.Obj
+	if update.Kind == types.ObjectUpdateKindLeave {
+		delete(c.tasks, ref)
+		return
+	}
+
+	task, ok := c.tasks[ref]
+	if !ok {
+		task = &mo.Task{}
+		task.Self = ref
+		c.tasks[ref] = task
+	}
+
+	for _, cs := range update.ChangeSet {
+		switch cs.Name {
+		case "info.descriptionId":
+			task.Info.DescriptionId, _ = cs.Val.(string)
+		case "info.state":
+			task.Info.State, _ = cs.Val.(types.TaskInfoState)
+		}
+	}
+}
+
+// updateMaintenance sets whether the hosts are entering maintenance mode from
+// the states of their recent tasks. Caller needs to own the lock.
+func (c *cachedQuerier) updateMaintenance() {
+	for ref, state := range c.hostStates {
+		var tasks []mo.Task
+		for _, task := range c.hostTasks[ref] {
+			if t, ok := c.tasks[task]; ok {
+				tasks = append(tasks, *t)
+			}
+		}
+
+		entering := enteringMaintenanceMode(tasks)
+		if entering != state.EnteringMaintenanceMode {
+			state.EnteringMaintenanceMode = entering
+			log.Printf("vsphere: cache update, host %s state %+v", c.hostCache[ref].Name, *state)
+		}
+	}
+}
+
+// setDatastores replaces the datastores of a VM. Caller needs to own the lock.
+func (c *cachedQuerier) setDatastores(vmid string, refs []types.ManagedObjectReference) {
+	for _, ref := range c.vmidToDatastores[vmid] {
+		delete(c.datastoreToVMIDs[ref], vmid)
+		if len(c.datastoreToVMIDs[ref]) == 0 {
+			delete(c.datastoreToVMIDs, ref)
+		}
+	}
+	delete(c.vmidToDatastores, vmid)
+
+	if len(refs) == 0 {
+		return
+	}
+
+	log.Printf("vsphere: cache update, vmid=>datastores, %s=>%v", vmid, refs)
+	c.vmidToDatastores[vmid] = refs
+	for _, ref := range refs {
+		if _, ok := c.datastoreToVMIDs[ref]; !ok {
+			c.datastoreToVMIDs[ref] = make(map[string]struct{})
+		}
+		c.datastoreToVMIDs[ref][vmid] = struct{}{}
+	}
+}
+
+// updateDatastore keeps the name of a datastore of the VMs. Caller needs to
+// own the lock.
+func (c *cachedQuerier) updateDatastore(update types.ObjectUpdate) {
+	if update.Kind == types.ObjectUpdateKindLeave {
+		delete(c.datastoreNames, update.Obj)
+		return
+	}
+
+	for _, cs := range update.ChangeSet {
+		if name, ok := cs.Val.(string); ok && cs.Name == "name" {
+			log.Printf("vsphere: cache update, datastore %s=>%s", update.Obj.String(), name)
+			c.datastoreNames[update.Obj] = name
+		}
+	}
 }
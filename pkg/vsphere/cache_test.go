@@ -0,0 +1,158 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func hostRef(value string) types.ManagedObjectReference {
+	return types.ManagedObjectReference{Type: "HostSystem", Value: value}
+}
+
+func taskRef(value string) types.ManagedObjectReference {
+	return types.ManagedObjectReference{Type: "Task", Value: value}
+}
+
+func taskUpdate(kind types.ObjectUpdateKind, value string, changes ...types.PropertyChange) types.ObjectUpdate {
+	return types.ObjectUpdate{Kind: kind, Obj: taskRef(value), ChangeSet: changes}
+}
+
+func TestCachedQuerierEnteringMaintenanceMode(t *testing.T) {
+	c := newCachedQuerier(nil)
+
+	running := []types.PropertyChange{
+		{Name: "info.descriptionId", Val: enterMaintenanceModeTask},
+		{Name: "info.state", Val: types.TaskInfoStateRunning},
+	}
+	recentTasks := func(values ...string) types.PropertyChange {
+		var refs []types.ManagedObjectReference
+		for _, value := range values {
+			refs = append(refs, taskRef(value))
+		}
+		return types.PropertyChange{Name: "recentTask", Val: types.ArrayOfManagedObjectReference{ManagedObjectReference: refs}}
+	}
+
+	tests := []struct {
+		name    string
+		updates []types.ObjectUpdate
+		list    bool
+		expect  bool
+	}{
+		{
+			name: "task before its host",
+			updates: []types.ObjectUpdate{
+				taskUpdate(types.ObjectUpdateKindEnter, "task-1", running...),
+				{Kind: types.ObjectUpdateKindEnter, Obj: hostRef("host-1"), ChangeSet: []types.PropertyChange{
+					{Name: "name", Val: "esx1"}, recentTasks("task-1"),
+				}},
+			},
+			list:   true,
+			expect: true,
+		},
+		{
+			name: "task completed",
+			updates: []types.ObjectUpdate{
+				taskUpdate(types.ObjectUpdateKindModify, "task-1",
+					types.PropertyChange{Name: "info.state", Val: types.TaskInfoStateSuccess}),
+			},
+		},
+		{
+			name: "another task queued",
+			updates: []types.ObjectUpdate{
+				taskUpdate(types.ObjectUpdateKindEnter, "task-2", running...),
+				{Kind: types.ObjectUpdateKindModify, Obj: hostRef("host-1"), ChangeSet: []types.PropertyChange{
+					recentTasks("task-1", "task-2"),
+				}},
+			},
+			expect: true,
+		},
+		{
+			name: "task left the recent tasks",
+			updates: []types.ObjectUpdate{
+				{Kind: types.ObjectUpdateKindModify, Obj: hostRef("host-1"), ChangeSet: []types.PropertyChange{
+					recentTasks("task-1"),
+				}},
+				taskUpdate(types.ObjectUpdateKindLeave, "task-2"),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		c.update(nil, test.updates, test.list)
+		state, ok := c.GetHostState("esx1")
+		if !ok {
+			t.Errorf("%s: expect the state of esx1", test.name)
+			continue
+		}
+		if state.EnteringMaintenanceMode != test.expect {
+			t.Errorf("%s: expect entering maintenance mode %t; got %t", test.name, test.expect,
+				state.EnteringMaintenanceMode)
+		}
+	}
+}
+
+func TestCachedQuerierNames(t *testing.T) {
+	c := newCachedQuerier(nil)
+	vm := types.ManagedObjectReference{Type: "VirtualMachine", Value: "vm-1"}
+	datastore := types.ManagedObjectReference{Type: "Datastore", Value: "datastore-1"}
+
+	// the VM comes before its host and its datastore
+	c.update(nil, []types.ObjectUpdate{
+		{Kind: types.ObjectUpdateKindEnter, Obj: vm, ChangeSet: []types.PropertyChange{
+			{Name: "runtime.host", Val: hostRef("host-1")},
+			{Name: "datastore", Val: types.ArrayOfManagedObjectReference{
+				ManagedObjectReference: []types.ManagedObjectReference{datastore},
+			}},
+		}},
+		{Kind: types.ObjectUpdateKindEnter, Obj: hostRef("host-1"), ChangeSet: []types.PropertyChange{
+			{Name: "name", Val: "esx1"},
+		}},
+		{Kind: types.ObjectUpdateKindEnter, Obj: datastore, ChangeSet: []types.PropertyChange{
+			{Name: "name", Val: "ds1"},
+		}},
+	}, true)
+
+	if host, _ := c.GetHostFromVMID(vm.String()); host != "esx1" {
+		t.Errorf("expect host esx1; got %q", host)
+	}
+	if datastores := c.GetDatastoresFromVMID(vm.String()); len(datastores) != 1 || datastores[0] != "ds1" {
+		t.Errorf("expect datastores [ds1]; got %v", datastores)
+	}
+
+	// renamed
+	c.update(nil, []types.ObjectUpdate{
+		{Kind: types.ObjectUpdateKindModify, Obj: hostRef("host-1"), ChangeSet: []types.PropertyChange{
+			{Name: "name", Val: "esx2"},
+		}},
+		{Kind: types.ObjectUpdateKindModify, Obj: datastore, ChangeSet: []types.PropertyChange{
+			{Name: "name", Val: "ds2"},
+		}},
+	}, false)
+
+	if host, _ := c.GetHostFromVMID(vm.String()); host != "esx2" {
+		t.Errorf("expect host esx2; got %q", host)
+	}
+	if vmids := c.GetVMIDsFromDatastore("ds2"); len(vmids) != 1 || vmids[0] != vm.String() {
+		t.Errorf("expect VMs [%s] on ds2; got %v", vm.String(), vmids)
+	}
+	if vmids := c.GetVMIDsFromDatastore("ds1"); len(vmids) != 0 {
+		t.Errorf("expect no VM on ds1; got %v", vmids)
+	}
+}
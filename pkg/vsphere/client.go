@@ -18,77 +18,87 @@ package vsphere
 
 import (
 	"context"
+	"flag"
 	"log"
 
 	"github.com/vmware/govmomi"
-	"github.com/vmware/govmomi/view"
-	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
 )
 
 type client struct {
-	client *govmomi.Client
-	ctx    context.Context
-	stopCh chan struct{}
+	// session is shared by the clients of all the clusters of a vCenter
+	session *session
 
 	*affinityClient // affinity rules
 	Querier         // cached or nocache
 }
 
-// NewCachedClient creates a cached vsphere client.
-func NewCachedClient(clusterName string) Vsphere {
-	ctx := context.Background()
-	vsclient, err := NewClient(ctx)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	stopCh := make(chan struct{})
-
-	clt := &client{
-		client:         vsclient,
-		ctx:            ctx,
-		stopCh:         stopCh,
-		affinityClient: newAffinityClient(clusterName),
-		Querier:        newCachedQuerier(vsclient, stopCh),
-	}
-
-	// Init cluster object
-	m := view.NewManager(vsclient.Client)
+// NewCachedClient creates a cached vsphere client of the vCenter given by the
+// -url flag. Credentials override the ones in the URL if not nil.
+func NewCachedClient(clusterName string, credentials Credentials) Vsphere {
+	flag.Parse()
 
-	v, err := m.CreateContainerView(ctx, vsclient.ServiceContent.RootFolder, []string{"ClusterComputeResource"}, true)
+	endpoint, urlCredentials, err := parseEndpoint(*urlFlag)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if credentials == nil {
+		credentials = urlCredentials
+	}
 
-	defer v.Destroy(ctx)
+	s := newSession(endpoint, *insecureFlag, credentials)
+	clt := newCachedClient(s, clusterName, false)
+	s.Start()
 
-	var clusters []mo.ClusterComputeResource
-	err = v.Retrieve(ctx, []string{"ClusterComputeResource"}, []string{"name"}, &clusters)
-	if err != nil {
-		log.Fatal(err)
-	}
+	return clt
+}
 
-	for i := range clusters {
-		if clusters[i].Name == clusterName {
-			clt.cluster = clusters[i].Reference()
-			break
+// newCachedClient creates a cached vsphere client of a cluster, watched with
+// session, which needs to be started afterwards. If scoped is true, only the
+// VMs and hosts of the cluster are watched, otherwise all of them in vCenter.
+func newCachedClient(s *session, clusterName string, scoped bool) *client {
+	root := func(ctx context.Context, vsclient *govmomi.Client) (types.ManagedObjectReference, error) {
+		if scoped {
+			return s.Cluster(ctx, vsclient, clusterName)
 		}
+		return vsclient.ServiceContent.RootFolder, nil
 	}
 
-	if clt.cluster.Type == "" {
-		log.Printf("[WARNING] cannot find cluster named %s", clusterName)
+	querier := newCachedQuerier(root)
+	clt := &client{
+		session:        s,
+		affinityClient: newAffinityClient(s, clusterName),
+		Querier:        querier,
 	}
 
-	go clt.affinityClient.Run(stopCh)
+	// The watches of the clusters sharing the session need distinct names
+	prefix := ""
+	if scoped {
+		prefix = clusterName + "/"
+	}
+	s.Watch(prefix+"inventory", querier)
+	s.Watch(prefix+"rules", clt.affinityClient)
 
 	return clt
 }
 
+// Client returns the govmomi client, nil before logging in
 func (c *client) Client() *govmomi.Client {
-	return c.client
+	return c.session.Client()
+}
+
+// HasSynced returns true while both the inventory and the rules are watched
+func (c *client) HasSynced() bool {
+	return c.Querier.HasSynced() && c.affinityClient.HasSynced()
+}
+
+// LoginError returns why the client is not logged in, nil if it is
+func (c *client) LoginError() error {
+	return c.session.Err()
 }
 
+// Logout stops the watches and signs off the session, which stops the other
+// clusters of the vCenter too.
 func (c *client) Logout() {
-	close(c.stopCh)
-	c.client.Logout(c.ctx)
+	c.session.Stop()
 }
@@ -0,0 +1,212 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/types"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/metrics"
+)
+
+const (
+	// collectorMaxWait is the longest a WaitForUpdatesEx call waits for
+	// updates, so that the call returns even when nothing changes
+	collectorMaxWait = 60 * time.Second
+
+	// collectorTimeout bounds a WaitForUpdatesEx call on the client side, in
+	// case the connection drops silently and vCenter never answers
+	collectorTimeout = collectorMaxWait + 30*time.Second
+)
+
+// watcher is a cache of vSphere objects kept up to date by the property
+// collector of a session
+type watcher interface {
+	// filter returns the spec of the objects to watch, and a function
+	// destroying what the spec needs, such as container views
+	filter(ctx context.Context, client *govmomi.Client) (types.PropertyFilterSpec, func(), error)
+
+	// update applies a batch of updates. The first batch after the filter is
+	// created lists all the objects, possibly none, and list is true so that
+	// the cache is rebuilt.
+	update(client *govmomi.Client, updates []types.ObjectUpdate, list bool)
+
+	// stop tells that the updates stopped, e.g. because the session is lost,
+	// and the cache is stale until the next list
+	stop()
+}
+
+// watch is a watcher added to a session
+type watch struct {
+	name string
+	watcher
+
+	// ref is the filter in the property collector, empty until created
+	ref     types.ManagedObjectReference
+	cleanup func()
+	listed  bool
+}
+
+// Watch adds a watcher to the property collector of the session. Watchers are
+// added before the session starts.
+func (s *session) Watch(name string, w watcher) {
+	s.watches = append(s.watches, &watch{name: name, watcher: w})
+}
+
+// collect creates the filters of all the watches in one property collector,
+// and hands out its updates until the session stops, fails or logs in again,
+// or the collector fails, e.g. because the connection dropped. A watch whose
+// filter cannot be created, like one of a missing cluster, is retried with
+// backoff without disturbing the others.
+func (s *session) collect(client *govmomi.Client, lost <-chan struct{}) (bool, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.stopCh:
+		case <-lost:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+
+	pc, err := property.DefaultCollector(client.Client).Create(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	// The filters go away with the collector, which is destroyed with the
+	// background context since ctx may be cancelled
+	defer pc.Destroy(context.Background())
+	defer s.stopWatches()
+
+	var b backoff
+	var retry time.Time
+	updated := false
+	for version := ""; ; {
+		req := types.WaitForUpdatesEx{
+			This:    pc.Reference(),
+			Version: version,
+		}
+
+		if !time.Now().Before(retry) {
+			if s.createFilters(ctx, client, pc) {
+				retry = time.Now().Add(b.failure())
+			} else {
+				b.reset()
+				retry = time.Time{}
+			}
+		}
+		wait := collectorMaxWait
+		if !retry.IsZero() {
+			if until := time.Until(retry); until < wait {
+				wait = until
+			}
+		}
+		maxWait := int32(wait/time.Second) + 1
+		req.Options = &types.WaitOptions{MaxWaitSeconds: &maxWait}
+
+		waitCtx, waitCancel := context.WithTimeout(ctx, collectorTimeout)
+		res, err := methods.WaitForUpdatesEx(waitCtx, client.Client, &req)
+		waitCancel()
+		if err != nil {
+			return updated, err
+		}
+		updated = true
+
+		if res.Returnval != nil {
+			version = res.Returnval.Version
+		}
+		s.dispatch(client, res.Returnval)
+	}
+}
+
+// createFilters creates the filters of the watches that have none yet, it
+// returns true if any of them failed.
+func (s *session) createFilters(ctx context.Context, client *govmomi.Client, pc *property.Collector) bool {
+	failed := false
+	for _, w := range s.watches {
+		if w.ref.Value != "" {
+			continue
+		}
+
+		if err := w.create(ctx, client, pc); err != nil {
+			log.Printf("[ERROR] vsphere: %s watch failed: %v", w.name, err)
+			metrics.VSphereWatchRestarts.Inc(w.name)
+			failed = true
+		}
+	}
+	return failed
+}
+
+func (w *watch) create(ctx context.Context, client *govmomi.Client, pc *property.Collector) error {
+	spec, cleanup, err := w.filter(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	res, err := methods.CreateFilter(ctx, client.Client, &types.CreateFilter{
+		This: pc.Reference(),
+		Spec: spec,
+	})
+	if err != nil {
+		cleanup()
+		return err
+	}
+
+	w.ref, w.cleanup, w.listed = res.Returnval, cleanup, false
+	return nil
+}
+
+// dispatch hands the updates of a WaitForUpdatesEx call to their watches, set
+// is nil if the call timed out. The filters created before the call have got
+// all their objects by then, so the ones without any are listed empty.
+func (s *session) dispatch(client *govmomi.Client, set *types.UpdateSet) {
+	if set != nil {
+		for _, fs := range set.FilterSet {
+			for _, w := range s.watches {
+				if w.ref == fs.Filter {
+					w.update(client, fs.ObjectSet, !w.listed)
+					w.listed = true
+				}
+			}
+		}
+	}
+
+	for _, w := range s.watches {
+		if w.ref.Value != "" && !w.listed {
+			w.update(client, nil, true)
+			w.listed = true
+		}
+	}
+}
+
+// stopWatches forgets the filters once the collector is gone
+func (s *session) stopWatches() {
+	for _, w := range s.watches {
+		if w.cleanup != nil {
+			w.cleanup()
+		}
+		w.ref, w.cleanup, w.listed = types.ManagedObjectReference{}, nil, false
+		w.stop()
+	}
+}
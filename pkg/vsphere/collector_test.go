@@ -0,0 +1,123 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// fakeWatcher records the updates it gets
+type fakeWatcher struct {
+	updates []int
+	lists   int
+	stopped bool
+}
+
+func (w *fakeWatcher) filter(context.Context, *govmomi.Client) (types.PropertyFilterSpec, func(), error) {
+	return types.PropertyFilterSpec{}, func() {}, nil
+}
+
+func (w *fakeWatcher) update(client *govmomi.Client, updates []types.ObjectUpdate, list bool) {
+	if list {
+		w.lists++
+		w.updates = nil
+	}
+	w.updates = append(w.updates, len(updates))
+	w.stopped = false
+}
+
+func (w *fakeWatcher) stop() {
+	w.stopped = true
+}
+
+func filterRef(value string) types.ManagedObjectReference {
+	return types.ManagedObjectReference{Type: "PropertyFilter", Value: value}
+}
+
+func TestDispatch(t *testing.T) {
+	s := newSession(&url.URL{Host: "vc1"}, false, &urlCredentials{})
+	inventory, rules, pending := &fakeWatcher{}, &fakeWatcher{}, &fakeWatcher{}
+	s.Watch("inventory", inventory)
+	s.Watch("rules", rules)
+	s.Watch("pending", pending)
+
+	cleanups := 0
+	for i, ref := range []string{"filter-1", "filter-2"} {
+		s.watches[i].ref = filterRef(ref)
+		s.watches[i].cleanup = func() { cleanups++ }
+	}
+
+	// first batch: the rules have no objects
+	s.dispatch(nil, &types.UpdateSet{
+		FilterSet: []types.PropertyFilterUpdate{
+			{Filter: filterRef("filter-1"), ObjectSet: make([]types.ObjectUpdate, 3)},
+		},
+	})
+	// timed out
+	s.dispatch(nil, nil)
+	s.dispatch(nil, &types.UpdateSet{
+		FilterSet: []types.PropertyFilterUpdate{
+			{Filter: filterRef("filter-1"), ObjectSet: make([]types.ObjectUpdate, 1)},
+			{Filter: filterRef("filter-2"), ObjectSet: make([]types.ObjectUpdate, 2)},
+		},
+	})
+
+	tests := []struct {
+		name    string
+		watcher *fakeWatcher
+		lists   int
+		updates []int
+	}{
+		{"inventory", inventory, 1, []int{3, 1}},
+		{"rules", rules, 1, []int{0, 2}},
+		{"pending", pending, 0, nil},
+	}
+	for _, test := range tests {
+		if test.watcher.lists != test.lists {
+			t.Errorf("%s: expect %d lists; got %d", test.name, test.lists, test.watcher.lists)
+		}
+		if len(test.watcher.updates) != len(test.updates) {
+			t.Errorf("%s: expect updates %v; got %v", test.name, test.updates, test.watcher.updates)
+			continue
+		}
+		for i := range test.updates {
+			if test.watcher.updates[i] != test.updates[i] {
+				t.Errorf("%s: expect updates %v; got %v", test.name, test.updates, test.watcher.updates)
+				break
+			}
+		}
+	}
+
+	// the collector is gone, all of them start over with a list
+	s.stopWatches()
+	if cleanups != 2 {
+		t.Errorf("expect 2 cleanups; got %d", cleanups)
+	}
+	for _, w := range s.watches {
+		if !w.watcher.(*fakeWatcher).stopped {
+			t.Errorf("%s: expect stopped", w.name)
+		}
+		if w.ref.Value != "" || w.listed {
+			t.Errorf("%s: expect the filter to be forgotten", w.name)
+		}
+	}
+}
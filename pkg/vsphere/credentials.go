@@ -0,0 +1,147 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const (
+	// UsernameKey is the file name in a credentials directory, or the key in
+	// a credentials Secret, holding the user name
+	UsernameKey = "username"
+
+	// PasswordKey is the file name in a credentials directory, or the key in
+	// a credentials Secret, holding the password
+	PasswordKey = "password"
+)
+
+// Credentials provides the user name and password to log in to vCenter
+type Credentials interface {
+	// Get returns the current credentials, they may change over time. Nil
+	// is returned if no login is needed.
+	Get() (*url.Userinfo, error)
+}
+
+// NewCredentials returns the credentials read from the files in dir, or from
+// the Secret "<namespace>/<name>". It returns nil if neither is given.
+func NewCredentials(dir, secret string, secrets corev1.SecretsGetter) (Credentials, error) {
+	switch {
+	case dir != "" && secret != "":
+		return nil, fmt.Errorf("credentials are given by both directory %s and secret %s", dir, secret)
+	case dir != "":
+		return NewFileCredentials(dir), nil
+	case secret != "":
+		parts := strings.Split(secret, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid credentials secret %q, expect <namespace>/<name>", secret)
+		}
+		return NewSecretCredentials(secrets, parts[0], parts[1]), nil
+	}
+	return nil, nil
+}
+
+// urlCredentials are the fixed credentials of the vCenter URL
+type urlCredentials struct {
+	user *url.Userinfo
+}
+
+func (c *urlCredentials) Get() (*url.Userinfo, error) {
+	return c.user, nil
+}
+
+type fileCredentials struct {
+	dir string
+}
+
+// NewFileCredentials creates the Credentials read from the files username and
+// password in dir, like a mounted Secret. The files are read on every Get, so
+// they can be rotated.
+func NewFileCredentials(dir string) Credentials {
+	return &fileCredentials{dir: dir}
+}
+
+func (c *fileCredentials) Get() (*url.Userinfo, error) {
+	username, err := ioutil.ReadFile(filepath.Join(c.dir, UsernameKey))
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := ioutil.ReadFile(filepath.Join(c.dir, PasswordKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return userinfo(username, password), nil
+}
+
+type secretCredentials struct {
+	secrets   corev1.SecretsGetter
+	namespace string
+	name      string
+}
+
+// NewSecretCredentials creates the Credentials read from the keys username and
+// password of a Kubernetes Secret. The Secret is read on every Get, so it can
+// be rotated.
+func NewSecretCredentials(secrets corev1.SecretsGetter, namespace, name string) Credentials {
+	return &secretCredentials{
+		secrets:   secrets,
+		namespace: namespace,
+		name:      name,
+	}
+}
+
+func (c *secretCredentials) Get() (*url.Userinfo, error) {
+	secret, err := c.secrets.Secrets(c.namespace).Get(c.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	username, ok := secret.Data[UsernameKey]
+	if !ok {
+		return nil, fmt.Errorf("secret %s/%s has no key %s", c.namespace, c.name, UsernameKey)
+	}
+
+	password, ok := secret.Data[PasswordKey]
+	if !ok {
+		return nil, fmt.Errorf("secret %s/%s has no key %s", c.namespace, c.name, PasswordKey)
+	}
+
+	return userinfo(username, password), nil
+}
+
+// userinfo trims the trailing newlines files usually end with
+func userinfo(username, password []byte) *url.Userinfo {
+	return url.UserPassword(strings.TrimRight(string(username), "\r\n"),
+		strings.TrimRight(string(password), "\r\n"))
+}
+
+// sameUser returns true if a and b are the same credentials
+func sameUser(a, b *url.Userinfo) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.String() == b.String()
+}
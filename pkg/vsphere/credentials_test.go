@@ -0,0 +1,132 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// fakeSecrets serves the Secrets by "<namespace>/<name>"
+type fakeSecrets map[string]*v1.Secret
+
+func (f fakeSecrets) Secrets(namespace string) corev1.SecretInterface {
+	return &fakeSecretInterface{secrets: f, namespace: namespace}
+}
+
+type fakeSecretInterface struct {
+	corev1.SecretInterface
+	secrets   fakeSecrets
+	namespace string
+}
+
+func (f *fakeSecretInterface) Get(name string, options metav1.GetOptions) (*v1.Secret, error) {
+	if secret, ok := f.secrets[f.namespace+"/"+name]; ok {
+		return secret, nil
+	}
+	return nil, errors.New("not found")
+}
+
+func TestFileCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vsphere-credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	credentials := NewFileCredentials(dir)
+	if _, err := credentials.Get(); err == nil {
+		t.Errorf("expect error without files")
+	}
+
+	write := func(username, password string) {
+		ioutil.WriteFile(filepath.Join(dir, UsernameKey), []byte(username), 0600)
+		ioutil.WriteFile(filepath.Join(dir, PasswordKey), []byte(password), 0600)
+	}
+
+	write("admin\n", "secret\n")
+	user, err := credentials.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameUser(user, url.UserPassword("admin", "secret")) {
+		t.Errorf("expect admin:secret; got %v", user)
+	}
+
+	// rotated
+	write("admin", "rotated")
+	if user, _ = credentials.Get(); !sameUser(user, url.UserPassword("admin", "rotated")) {
+		t.Errorf("expect admin:rotated; got %v", user)
+	}
+}
+
+func TestSecretCredentials(t *testing.T) {
+	secrets := fakeSecrets{
+		"kube-system/vsphere": &v1.Secret{
+			Data: map[string][]byte{UsernameKey: []byte("admin"), PasswordKey: []byte("secret")},
+		},
+		"kube-system/broken": &v1.Secret{
+			Data: map[string][]byte{UsernameKey: []byte("admin")},
+		},
+	}
+
+	tests := []struct {
+		desc      string
+		secret    string
+		expect    *url.Userinfo
+		expectErr bool
+	}{
+		{desc: "valid secret", secret: "kube-system/vsphere", expect: url.UserPassword("admin", "secret")},
+		{desc: "missing password", secret: "kube-system/broken", expectErr: true},
+		{desc: "missing secret", secret: "default/vsphere", expectErr: true},
+	}
+
+	for _, test := range tests {
+		credentials, err := NewCredentials("", test.secret, secrets)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		user, err := credentials.Get()
+		if (err != nil) != test.expectErr {
+			t.Errorf("[%s] unexpected error: %v", test.desc, err)
+		}
+		if !test.expectErr && !sameUser(user, test.expect) {
+			t.Errorf("[%s] expect %v; got %v", test.desc, test.expect, user)
+		}
+	}
+}
+
+func TestNewCredentials(t *testing.T) {
+	if credentials, err := NewCredentials("", "", nil); credentials != nil || err != nil {
+		t.Errorf("expect no credentials; got %v, %v", credentials, err)
+	}
+	if _, err := NewCredentials("/etc/vsphere", "kube-system/vsphere", nil); err == nil {
+		t.Errorf("expect error with both directory and secret")
+	}
+	if _, err := NewCredentials("", "vsphere", nil); err == nil {
+		t.Errorf("expect error with secret without namespace")
+	}
+}
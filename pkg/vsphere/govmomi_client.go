@@ -116,3 +116,19 @@ func NewClient(ctx context.Context) (*govmomi.Client, error) {
 	// Connect and log in to ESX or vCenter
 	return govmomi.NewClient(ctx, u, *insecureFlag)
 }
+
+// parseEndpoint splits rawURL into the vCenter endpoint and the credentials in
+// it, which can be overridden by environment variables.
+func parseEndpoint(rawURL string) (*url.URL, Credentials, error) {
+	u, err := soap.ParseURL(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Override username and/or password as required
+	processOverride(u)
+
+	credentials := &urlCredentials{user: u.User}
+	u.User = nil
+	return u, credentials, nil
+}
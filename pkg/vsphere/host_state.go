@@ -0,0 +1,77 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"fmt"
+
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// enterMaintenanceModeTask is the description id of the tasks putting a host
+// into maintenance mode, which evacuate its VMs.
+const enterMaintenanceModeTask = "HostSystem.enterMaintenanceMode"
+
+// HostState is the runtime state of an ESX server
+type HostState struct {
+	ConnectionState   types.HostSystemConnectionState
+	PowerState        types.HostSystemPowerState
+	StandbyMode       string
+	InMaintenanceMode bool
+
+	// EnteringMaintenanceMode is set while a task putting the host into
+	// maintenance mode is queued or running.
+	EnteringMaintenanceMode bool
+}
+
+// Unavailable returns why no new VM workload should be placed on the host, or
+// an empty string if the host is fine. The reason reads after "host X is",
+// e.g. "in maintenance mode" or "poweredOff".
+func (s *HostState) Unavailable() string {
+	switch {
+	case s.InMaintenanceMode:
+		return "in maintenance mode"
+	case s.EnteringMaintenanceMode:
+		return "entering maintenance mode"
+	case s.ConnectionState != "" && s.ConnectionState != types.HostSystemConnectionStateConnected:
+		return string(s.ConnectionState)
+	case s.PowerState == types.HostSystemPowerStateUnknown:
+		return "in an unknown power state"
+	case s.PowerState != "" && s.PowerState != types.HostSystemPowerStatePoweredOn:
+		return string(s.PowerState)
+	case s.StandbyMode == string(types.HostStandbyModeIn):
+		return "in standby mode"
+	case s.StandbyMode != "" && s.StandbyMode != string(types.HostStandbyModeNone):
+		return fmt.Sprintf("%s standby mode", s.StandbyMode)
+	}
+	return ""
+}
+
+// enteringMaintenanceMode returns true if any of tasks is putting a host into
+// maintenance mode.
+func enteringMaintenanceMode(tasks []mo.Task) bool {
+	for _, task := range tasks {
+		if task.Info.DescriptionId != enterMaintenanceModeTask {
+			continue
+		}
+		if task.Info.State == types.TaskInfoStateQueued || task.Info.State == types.TaskInfoStateRunning {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestHostStateUnavailable(t *testing.T) {
+	healthy := HostState{
+		ConnectionState: types.HostSystemConnectionStateConnected,
+		PowerState:      types.HostSystemPowerStatePoweredOn,
+		StandbyMode:     string(types.HostStandbyModeNone),
+	}
+
+	tests := []struct {
+		desc   string
+		update func(s *HostState)
+		expect string
+	}{
+		{"healthy host", func(s *HostState) {}, ""},
+		{"in maintenance", func(s *HostState) { s.InMaintenanceMode = true }, "in maintenance mode"},
+		{"entering maintenance", func(s *HostState) { s.EnteringMaintenanceMode = true }, "entering maintenance mode"},
+		{"not responding", func(s *HostState) { s.ConnectionState = types.HostSystemConnectionStateNotResponding }, "notResponding"},
+		{"disconnected", func(s *HostState) { s.ConnectionState = types.HostSystemConnectionStateDisconnected }, "disconnected"},
+		{"powered off", func(s *HostState) { s.PowerState = types.HostSystemPowerStatePoweredOff }, "poweredOff"},
+		{"power state unknown", func(s *HostState) { s.PowerState = types.HostSystemPowerStateUnknown }, "in an unknown power state"},
+		{"in standby", func(s *HostState) { s.StandbyMode = string(types.HostStandbyModeIn) }, "in standby mode"},
+		{"entering standby", func(s *HostState) { s.StandbyMode = string(types.HostStandbyModeEntering) }, "entering standby mode"},
+		{"state not loaded yet", func(s *HostState) { *s = HostState{} }, ""},
+	}
+
+	for _, test := range tests {
+		state := healthy
+		test.update(&state)
+		if reason := state.Unavailable(); reason != test.expect {
+			t.Errorf("[%s] expect %q; got %q", test.desc, test.expect, reason)
+		}
+	}
+}
+
+func TestEnteringMaintenanceMode(t *testing.T) {
+	task := func(descriptionID string, state types.TaskInfoState) mo.Task {
+		return mo.Task{Info: types.TaskInfo{DescriptionId: descriptionID, State: state}}
+	}
+
+	tests := []struct {
+		desc   string
+		tasks  []mo.Task
+		expect bool
+	}{
+		{"no tasks", nil, false},
+		{"running", []mo.Task{task(enterMaintenanceModeTask, types.TaskInfoStateRunning)}, true},
+		{"queued", []mo.Task{task(enterMaintenanceModeTask, types.TaskInfoStateQueued)}, true},
+		{"cancelled", []mo.Task{task(enterMaintenanceModeTask, types.TaskInfoStateError)}, false},
+		{"other task", []mo.Task{task("VirtualMachine.migrate", types.TaskInfoStateRunning)}, false},
+	}
+
+	for _, test := range tests {
+		if result := enteringMaintenanceMode(test.tasks); result != test.expect {
+			t.Errorf("[%s] expect %v; got %v", test.desc, test.expect, result)
+		}
+	}
+}
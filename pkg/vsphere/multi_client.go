@@ -0,0 +1,404 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/vmware/govmomi"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// member is the client of one cluster, named "<vcenter>/<cluster>"
+type member struct {
+	name string
+	Vsphere
+}
+
+// multiClient routes the requests of several clusters, possibly in several
+// vCenters, to the client of the cluster holding the VM. VMIDs are prefixed
+// with the name of their cluster, e.g. "vc1/cluster1/VirtualMachine:vm-1",
+// since VMIDs are only unique within a vCenter.
+type multiClient struct {
+	members []member
+
+	// unplaced keeps the VMs of the rules that cannot be set in their
+	// clusters, like the only VM of a cluster in an affinity rule, so that
+	// the rules are still reported as applied.
+	unplaced     map[string]Rule
+	unplacedLock sync.Mutex
+}
+
+// NewMultiClient creates a cached vsphere client for every cluster in config,
+// the credentials Secrets are read by secrets. A single cluster gets a plain
+// client, without prefixed VMIDs.
+func NewMultiClient(config *Config, secrets corev1.SecretsGetter) (Vsphere, error) {
+	var members []member
+	var sessions []*session
+	for i := range config.VCenters {
+		vc := &config.VCenters[i]
+		endpoint, credentials, err := vc.endpoint(secrets)
+		if err != nil {
+			return nil, fmt.Errorf("vcenter %s: %v", vc.Name, err)
+		}
+
+		// The clusters of a vCenter share its session
+		s := newSession(endpoint, vc.Insecure, credentials)
+		sessions = append(sessions, s)
+
+		for _, cluster := range vc.Clusters {
+			log.Printf("vsphere: add cluster %s of vcenter %s", cluster, vc.Name)
+			members = append(members, member{
+				name:    vc.Name + "/" + cluster,
+				Vsphere: newCachedClient(s, cluster, true),
+			})
+		}
+	}
+
+	for _, s := range sessions {
+		s.Start()
+	}
+
+	if len(members) == 1 {
+		return members[0].Vsphere, nil
+	}
+
+	return newMultiClient(members...), nil
+}
+
+func newMultiClient(members ...member) *multiClient {
+	return &multiClient{
+		members:  members,
+		unplaced: make(map[string]Rule),
+	}
+}
+
+// qualify prefixes the VMID of a member with its name
+func (m *member) qualify(vmid string) string {
+	if vmid == "" {
+		return ""
+	}
+	return m.name + "/" + vmid
+}
+
+// route returns the member holding a VMID and the VMID within the member,
+// member is nil if the VMID belongs to none of them.
+func (c *multiClient) route(vmid string) (*member, string) {
+	i, id := c.routeIndex(vmid)
+	if i < 0 {
+		return nil, ""
+	}
+	return &c.members[i], id
+}
+
+// routeIndex is like route, but returns the index of the member, or -1
+func (c *multiClient) routeIndex(vmid string) (int, string) {
+	for i := range c.members {
+		if prefix := c.members[i].name + "/"; strings.HasPrefix(vmid, prefix) {
+			return i, strings.TrimPrefix(vmid, prefix)
+		}
+	}
+	return -1, ""
+}
+
+func (c *multiClient) GetHostFromVMID(vmid string) (string, error) {
+	m, id := c.route(vmid)
+	if m == nil {
+		return "", nil
+	}
+	return m.GetHostFromVMID(id)
+}
+
+func (c *multiClient) GetHostnameFromVMID(vmid string) string {
+	m, id := c.route(vmid)
+	if m == nil {
+		return ""
+	}
+	return m.GetHostnameFromVMID(id)
+}
+
+// GetVMIDsFromHostname gets the VMIDs with a hostname in all clusters
+func (c *multiClient) GetVMIDsFromHostname(hostname string) []string {
+	return c.collect(func(m *member) []string {
+		return m.GetVMIDsFromHostname(hostname)
+	})
+}
+
+func (c *multiClient) GetUUIDFromVMID(vmid string) string {
+	m, id := c.route(vmid)
+	if m == nil {
+		return ""
+	}
+	return m.GetUUIDFromVMID(id)
+}
+
+// GetVMIDsFromUUID gets the VMIDs with a BIOS UUID in all clusters
+func (c *multiClient) GetVMIDsFromUUID(uuid string) []string {
+	return c.collect(func(m *member) []string {
+		return m.GetVMIDsFromUUID(uuid)
+	})
+}
+
+func (c *multiClient) GetIPsFromVMID(vmid string) []string {
+	m, id := c.route(vmid)
+	if m == nil {
+		return nil
+	}
+	return m.GetIPsFromVMID(id)
+}
+
+// GetVMIDsFromIP gets the VMIDs with a guest IP address in all clusters
+func (c *multiClient) GetVMIDsFromIP(ip string) []string {
+	return c.collect(func(m *member) []string {
+		return m.GetVMIDsFromIP(ip)
+	})
+}
+
+// collect returns the VMIDs found by f in all clusters
+func (c *multiClient) collect(f func(m *member) []string) []string {
+	result := []string{}
+	for i := range c.members {
+		for _, vmid := range f(&c.members[i]) {
+			result = append(result, c.members[i].qualify(vmid))
+		}
+	}
+	return result
+}
+
+func (c *multiClient) GetDatastoresFromVMID(vmid string) []string {
+	m, id := c.route(vmid)
+	if m == nil {
+		return nil
+	}
+	return m.GetDatastoresFromVMID(id)
+}
+
+// GetVMIDsFromDatastore gets the VMIDs on a datastore in all clusters, the
+// datastores of different vCenters are told apart by name only.
+func (c *multiClient) GetVMIDsFromDatastore(datastore string) []string {
+	return c.collect(func(m *member) []string {
+		return m.GetVMIDsFromDatastore(datastore)
+	})
+}
+
+func (c *multiClient) GetHostState(host string) (HostState, bool) {
+	for i := range c.members {
+		if state, ok := c.members[i].GetHostState(host); ok {
+			return state, true
+		}
+	}
+	return HostState{}, false
+}
+
+func (c *multiClient) HasSynced() bool {
+	for i := range c.members {
+		if !c.members[i].HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// Rules merges the rules of the same name in all clusters
+func (c *multiClient) Rules() map[string]Rule {
+	result := make(map[string]Rule)
+	for i := range c.members {
+		for name, rule := range c.members[i].Rules() {
+			merged, ok := result[name]
+			if !ok {
+				merged = rule
+				merged.VMs = nil
+			}
+			for _, vmid := range rule.VMs {
+				merged.VMs = append(merged.VMs, c.members[i].qualify(vmid))
+			}
+			result[name] = merged
+		}
+	}
+
+	c.unplacedLock.Lock()
+	defer c.unplacedLock.Unlock()
+	for name, rule := range c.unplaced {
+		merged, ok := result[name]
+		if !ok {
+			merged = rule
+			merged.VMs = nil
+		}
+		merged.VMs = append(merged.VMs, rule.VMs...)
+		result[name] = merged
+	}
+
+	return result
+}
+
+// HostGroups merges the host groups of all clusters
+func (c *multiClient) HostGroups() map[string][]string {
+	result := make(map[string][]string)
+	for i := range c.members {
+		for name, hosts := range c.members[i].HostGroups() {
+			result[name] = append(result[name], hosts...)
+		}
+	}
+	return result
+}
+
+// ApplyAffinityRule sets the rule in every cluster holding at least two of
+// the VMs. VMs in different clusters cannot share a host anyway.
+func (c *multiClient) ApplyAffinityRule(name string, vms ...string) error {
+	return c.applyRule(Rule{Name: name, VMs: vms, Affinity: true}, 2,
+		func(m *member, vms []string) error {
+			return m.ApplyAffinityRule(name, vms...)
+		})
+}
+
+// ApplyAntiAffinityRule sets the rule in every cluster holding at least two
+// of the VMs, VMs in different clusters never share a host.
+func (c *multiClient) ApplyAntiAffinityRule(name string, vms ...string) error {
+	return c.applyRule(Rule{Name: name, VMs: vms}, 2,
+		func(m *member, vms []string) error {
+			return m.ApplyAntiAffinityRule(name, vms...)
+		})
+}
+
+// ApplyHostGroupRule sets the rule in the clusters having the host group, for
+// the VMs in those clusters.
+func (c *multiClient) ApplyHostGroupRule(name, hostGroup string, affinity bool, vms ...string) error {
+	return c.applyRule(Rule{Name: name, VMs: vms, Affinity: affinity, HostGroup: hostGroup}, 1,
+		func(m *member, vms []string) error {
+			if _, ok := m.HostGroups()[hostGroup]; !ok {
+				return errUnplaced
+			}
+			return m.ApplyHostGroupRule(name, hostGroup, affinity, vms...)
+		})
+}
+
+func (c *multiClient) DeleteAffinityRule(name string) error {
+	return c.deleteRule(name, func(m *member) error {
+		return m.DeleteAffinityRule(name)
+	})
+}
+
+func (c *multiClient) DeleteAntiAffinityRule(name string) error {
+	return c.deleteRule(name, func(m *member) error {
+		return m.DeleteAntiAffinityRule(name)
+	})
+}
+
+func (c *multiClient) DeleteHostGroupRule(name string) error {
+	return c.deleteRule(name, func(m *member) error {
+		return m.DeleteHostGroupRule(name)
+	})
+}
+
+// LoginError returns the login errors of all clusters
+func (c *multiClient) LoginError() error {
+	var errs []error
+	for i := range c.members {
+		if err := c.members[i].LoginError(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", c.members[i].name, err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func (c *multiClient) Logout() {
+	for i := range c.members {
+		c.members[i].Logout()
+	}
+}
+
+// Client returns the govmomi client of the first cluster
+func (c *multiClient) Client() *govmomi.Client {
+	return c.members[0].Client()
+}
+
+// errUnplaced tells applyRule that a member cannot take the rule
+var errUnplaced = errors.New("rule cannot be set in the cluster")
+
+// applyRule groups the VMs of rule by cluster, and applies the rule to every
+// group of at least minVMs VMs. The other VMs are kept as unplaced.
+func (c *multiClient) applyRule(rule Rule, minVMs int, apply func(m *member, vms []string) error) error {
+	groups := make([][]string, len(c.members))
+	unplaced := rule
+	unplaced.VMs = nil
+
+	for _, vmid := range rule.VMs {
+		i, id := c.routeIndex(vmid)
+		if i < 0 {
+			log.Printf("[WARNING] vsphere: vm %s of rule %s is in no known cluster", vmid, rule.Name)
+			unplaced.VMs = append(unplaced.VMs, vmid)
+			continue
+		}
+		groups[i] = append(groups[i], id)
+	}
+
+	var errs []error
+	for i, vms := range groups {
+		if len(vms) == 0 {
+			continue
+		}
+
+		m := &c.members[i]
+		err := errUnplaced
+		if len(vms) >= minVMs {
+			err = apply(m, vms)
+		}
+		if err == errUnplaced {
+			for _, vmid := range vms {
+				unplaced.VMs = append(unplaced.VMs, m.qualify(vmid))
+			}
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	c.unplacedLock.Lock()
+	defer c.unplacedLock.Unlock()
+	if len(unplaced.VMs) > 0 {
+		c.unplaced[rule.Name] = unplaced
+	} else {
+		delete(c.unplaced, rule.Name)
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// deleteRule deletes a rule from every cluster having it
+func (c *multiClient) deleteRule(name string, del func(m *member) error) error {
+	c.unplacedLock.Lock()
+	delete(c.unplaced, name)
+	c.unplacedLock.Unlock()
+
+	var errs []error
+	for i := range c.members {
+		if _, ok := c.members[i].Rules()[name]; !ok {
+			continue
+		}
+		if err := del(&c.members[i]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
@@ -0,0 +1,187 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// fakeVsphere is a cluster with VMs and the rules set on them
+type fakeVsphere struct {
+	Vsphere
+	hostnames  map[string]string // vmid to hostname
+	hosts      map[string]string // vmid to ESX host
+	hostGroups map[string][]string
+	rules      map[string]Rule
+}
+
+func (f *fakeVsphere) GetHostFromVMID(vmid string) (string, error) {
+	return f.hosts[vmid], nil
+}
+
+func (f *fakeVsphere) GetHostnameFromVMID(vmid string) string {
+	return f.hostnames[vmid]
+}
+
+func (f *fakeVsphere) GetVMIDsFromHostname(hostname string) []string {
+	var result []string
+	for vmid, h := range f.hostnames {
+		if h == hostname {
+			result = append(result, vmid)
+		}
+	}
+	return result
+}
+
+func (f *fakeVsphere) Rules() map[string]Rule {
+	return f.rules
+}
+
+func (f *fakeVsphere) HostGroups() map[string][]string {
+	return f.hostGroups
+}
+
+func (f *fakeVsphere) ApplyAffinityRule(name string, vms ...string) error {
+	f.rules[name] = Rule{Name: name, VMs: vms, Affinity: true}
+	return nil
+}
+
+func (f *fakeVsphere) ApplyAntiAffinityRule(name string, vms ...string) error {
+	f.rules[name] = Rule{Name: name, VMs: vms}
+	return nil
+}
+
+func (f *fakeVsphere) ApplyHostGroupRule(name, hostGroup string, affinity bool, vms ...string) error {
+	f.rules[name] = Rule{Name: name, VMs: vms, Affinity: affinity, HostGroup: hostGroup}
+	return nil
+}
+
+func (f *fakeVsphere) DeleteAntiAffinityRule(name string) error {
+	delete(f.rules, name)
+	return nil
+}
+
+func (f *fakeVsphere) DeleteHostGroupRule(name string) error {
+	delete(f.rules, name)
+	return nil
+}
+
+func newFakeMultiClient() (*multiClient, *fakeVsphere, *fakeVsphere) {
+	a := &fakeVsphere{
+		hostnames:  map[string]string{"VirtualMachine:vm-1": "node1", "VirtualMachine:vm-2": "node2"},
+		hosts:      map[string]string{"VirtualMachine:vm-1": "esx-a1", "VirtualMachine:vm-2": "esx-a2"},
+		hostGroups: map[string][]string{"rack-a": {"esx-a1"}},
+		rules:      map[string]Rule{},
+	}
+	b := &fakeVsphere{
+		// the same VMID in another vCenter
+		hostnames: map[string]string{"VirtualMachine:vm-1": "node3"},
+		hosts:     map[string]string{"VirtualMachine:vm-1": "esx-b1"},
+		rules:     map[string]Rule{},
+	}
+
+	return newMultiClient(member{"vc1/a", a}, member{"vc2/b", b}), a, b
+}
+
+func TestMultiClientRouting(t *testing.T) {
+	c, _, _ := newFakeMultiClient()
+
+	for hostname, expect := range map[string]string{
+		"node1": "vc1/a/VirtualMachine:vm-1",
+		"node3": "vc2/b/VirtualMachine:vm-1",
+		"node4": "",
+	} {
+		vmid := ""
+		if vmids := c.GetVMIDsFromHostname(hostname); len(vmids) == 1 {
+			vmid = vmids[0]
+		} else if len(vmids) > 1 {
+			t.Errorf("expect one vmid of %s; got %v", hostname, vmids)
+		}
+		if vmid != expect {
+			t.Errorf("expect vmid of %s %q; got %q", hostname, expect, vmid)
+		}
+		if vmid != "" && c.GetHostnameFromVMID(vmid) != hostname {
+			t.Errorf("expect hostname of %s %q; got %q", vmid, hostname, c.GetHostnameFromVMID(vmid))
+		}
+	}
+
+	if host, _ := c.GetHostFromVMID("vc2/b/VirtualMachine:vm-1"); host != "esx-b1" {
+		t.Errorf("expect host esx-b1; got %q", host)
+	}
+	if host, _ := c.GetHostFromVMID("VirtualMachine:vm-1"); host != "" {
+		t.Errorf("expect no host of an unqualified vmid; got %q", host)
+	}
+}
+
+func TestMultiClientRules(t *testing.T) {
+	c, a, b := newFakeMultiClient()
+
+	vms := []string{"vc1/a/VirtualMachine:vm-1", "vc1/a/VirtualMachine:vm-2", "vc2/b/VirtualMachine:vm-1"}
+	if err := c.ApplyAntiAffinityRule("anti-1", vms...); err != nil {
+		t.Fatal(err)
+	}
+
+	// The rule is set in cluster a only, b holds a single VM
+	if expect := []string{"VirtualMachine:vm-1", "VirtualMachine:vm-2"}; !reflect.DeepEqual(a.rules["anti-1"].VMs, expect) {
+		t.Errorf("expect rule in cluster a on %v; got %v", expect, a.rules["anti-1"])
+	}
+	if _, ok := b.rules["anti-1"]; ok {
+		t.Errorf("expect no rule in cluster b; got %v", b.rules["anti-1"])
+	}
+
+	// but it is reported with all of its VMs
+	rule := c.Rules()["anti-1"]
+	sort.Strings(rule.VMs)
+	if !reflect.DeepEqual(rule.VMs, vms) {
+		t.Errorf("expect rule on %v; got %v", vms, rule)
+	}
+
+	if err := c.DeleteAntiAffinityRule("anti-1"); err != nil {
+		t.Fatal(err)
+	}
+	if rules := c.Rules(); len(rules) != 0 {
+		t.Errorf("expect no rules; got %v", rules)
+	}
+}
+
+func TestMultiClientHostGroupRule(t *testing.T) {
+	c, a, b := newFakeMultiClient()
+
+	if groups := c.HostGroups(); !reflect.DeepEqual(groups, map[string][]string{"rack-a": {"esx-a1"}}) {
+		t.Errorf("unexpected host groups %v", groups)
+	}
+
+	vms := []string{"vc1/a/VirtualMachine:vm-2", "vc2/b/VirtualMachine:vm-1"}
+	if err := c.ApplyHostGroupRule("anti-2", "rack-a", false, vms...); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := Rule{Name: "anti-2", VMs: []string{"VirtualMachine:vm-2"}, HostGroup: "rack-a"}
+	if !reflect.DeepEqual(a.rules["anti-2"], expect) {
+		t.Errorf("expect rule %v in cluster a; got %v", expect, a.rules["anti-2"])
+	}
+	if len(b.rules) != 0 {
+		t.Errorf("expect no rules in cluster b without the host group; got %v", b.rules)
+	}
+
+	rule := c.Rules()["anti-2"]
+	if !reflect.DeepEqual(rule.VMs, vms) || rule.HostGroup != "rack-a" {
+		t.Errorf("expect rule on %v; got %v", vms, rule)
+	}
+}
@@ -0,0 +1,413 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/view"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/metrics"
+)
+
+const (
+	// sessionCheckInterval is how often the session is checked, and the
+	// credentials are read again to pick up rotated ones
+	sessionCheckInterval = 30 * time.Second
+
+	// sessionCheckTimeout bounds a check, so that a connection dropped
+	// silently fails it instead of hanging
+	sessionCheckTimeout = time.Minute
+)
+
+// errNotLoggedIn is returned by requests while there is no session
+var errNotLoggedIn = errors.New("not logged in to vCenter")
+
+// session keeps a govmomi client logged in to vCenter with the current
+// credentials. It logs in again when the credentials change or the session
+// expires, retries with backoff when vCenter cannot be reached, and keeps the
+// error for health checks instead of exiting. The periodic check also keeps
+// the session alive.
+//
+// There is one session per vCenter, shared by the caches and the rule clients
+// of all its clusters. The caches are watchers of a single property collector,
+// see Watch.
+type session struct {
+	endpoint    *url.URL
+	insecure    bool
+	credentials Credentials
+
+	lock   sync.Mutex
+	client *govmomi.Client
+	user   *url.Userinfo
+	err    error
+
+	// rotationErr is why the new credentials do not work, while the session
+	// keeps the old ones
+	rotationErr error
+
+	// ready is closed while the session is fine, and replaced by an open
+	// channel when it fails
+	ready chan struct{}
+
+	// lost is closed when the session fails or logs in again, which ends the
+	// watches of the current client, and replaced by an open channel once
+	// the session is fine again
+	lost chan struct{}
+
+	// wake triggers a check of the session
+	wake chan struct{}
+
+	// clusters caches the references of the clusters by name, it is cleared
+	// when logging in again
+	clusters map[string]types.ManagedObjectReference
+
+	// watches share the property collector of the session
+	watches []*watch
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// newSession creates a session to the vCenter at endpoint, which has no user
+// information, logged in with credentials.
+func newSession(endpoint *url.URL, insecure bool, credentials Credentials) *session {
+	return &session{
+		endpoint:    endpoint,
+		insecure:    insecure,
+		credentials: credentials,
+		err:         errNotLoggedIn,
+		ready:       make(chan struct{}),
+		lost:        make(chan struct{}),
+		wake:        make(chan struct{}, 1),
+		clusters:    make(map[string]types.ManagedObjectReference),
+		stopCh:      make(chan struct{}),
+	}
+}
+
+// Start logs in, and runs the watches until Stop is called
+func (s *session) Start() {
+	go s.Run(s.stopCh)
+	go supervise("collector", s, s.stopCh, s.collect)
+}
+
+// Stop stops the watches and signs off the session. The clients sharing the
+// session may all call it.
+func (s *session) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+		s.Logout()
+	})
+}
+
+// Run logs in, and keeps checking the session until stopCh is closed
+func (s *session) Run(stopCh <-chan struct{}) {
+	var b backoff
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), sessionCheckTimeout)
+		err := s.check(ctx)
+		cancel()
+		s.setErr(err)
+
+		interval := sessionCheckInterval
+		if err != nil {
+			interval = b.failure()
+		} else {
+			b.reset()
+		}
+
+		select {
+		case <-stopCh:
+			return
+		case <-s.wake:
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Lost tells the session that a request failed, possibly because the session
+// is lost, so that it is checked right away.
+func (s *session) Lost() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Restart tells the session that a request timed out, possibly because the
+// connection dropped silently, so that the watches start over and the session
+// is checked right away.
+func (s *session) Restart() {
+	s.lock.Lock()
+	s.renew()
+	s.lock.Unlock()
+	s.Lost()
+}
+
+// check logs in if there is no session yet, the credentials changed, or the
+// session expired.
+func (s *session) check(ctx context.Context) error {
+	user, err := s.credentials.Get()
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	client, current := s.client, s.user
+	s.lock.Unlock()
+
+	var rotationErr error
+	loggedIn := true
+	switch {
+	case client == nil:
+		if client, err = s.newClient(ctx, user); err != nil {
+			return err
+		}
+		log.Printf("vsphere: logged in to %s", s.endpoint.Host)
+
+	case !sameUser(current, user):
+		// The old session is kept until the new credentials work, its
+		// watchers then fail and start over with the new client
+		log.Printf("vsphere: credentials of %s changed, log in again", s.endpoint.Host)
+		rotated, err := s.newClient(ctx, user)
+		if err != nil {
+			log.Printf("[ERROR] vsphere: failed to log in to %s with the new credentials, keep the old session: %v",
+				s.endpoint.Host, err)
+			rotationErr = fmt.Errorf("failed to log in with the new credentials: %v", err)
+			user = current
+			if user != nil {
+				if loggedIn, err = s.keepAlive(ctx, client, user); err != nil {
+					return err
+				}
+			} else {
+				loggedIn = false
+			}
+			break
+		}
+
+		old := client
+		client = rotated
+		defer func() {
+			if err := old.Logout(ctx); err != nil {
+				log.Printf("[WARNING] vsphere: failed to log out of %s: %v", s.endpoint.Host, err)
+			}
+		}()
+
+	case user != nil:
+		if loggedIn, err = s.keepAlive(ctx, client, user); err != nil {
+			return err
+		}
+
+	default:
+		loggedIn = false
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.client, s.user = client, user
+	s.rotationErr = rotationErr
+	if loggedIn {
+		s.clusters = make(map[string]types.ManagedObjectReference)
+
+		// The watches of the old session start over with the new one
+		s.renew()
+	}
+
+	return nil
+}
+
+// newClient creates a client of the endpoint logged in as user
+func (s *session) newClient(ctx context.Context, user *url.Userinfo) (*govmomi.Client, error) {
+	u := *s.endpoint
+	u.User = user
+	client, err := govmomi.NewClient(ctx, &u, s.insecure)
+	if user != nil {
+		metrics.VSphereLogins.Inc(metrics.Result(err))
+	}
+	return client, err
+}
+
+// keepAlive checks the session of client, and logs in again as user if it
+// expired. It returns true if it logged in.
+func (s *session) keepAlive(ctx context.Context, client *govmomi.Client, user *url.Userinfo) (bool, error) {
+	userSession, err := client.SessionManager.UserSession(ctx)
+	if err != nil {
+		// vCenter cannot be reached
+		return false, err
+	}
+	if userSession != nil {
+		return false, nil
+	}
+
+	log.Printf("vsphere: session of %s expired, log in again", s.endpoint.Host)
+	return true, s.login(ctx, client, user)
+}
+
+func (s *session) login(ctx context.Context, client *govmomi.Client, user *url.Userinfo) error {
+	err := client.Login(ctx, user)
+	metrics.VSphereLogins.Inc(metrics.Result(err))
+	return err
+}
+
+func (s *session) setErr(err error) {
+	if err != nil {
+		log.Printf("[ERROR] vsphere: session of %s failed: %v", s.endpoint.Host, err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.err = err
+
+	if err != nil {
+		s.lose()
+	} else {
+		select {
+		case <-s.lost:
+			s.lost = make(chan struct{})
+		default:
+		}
+	}
+
+	select {
+	case <-s.ready:
+		if err != nil {
+			s.ready = make(chan struct{})
+		}
+	default:
+		if err == nil {
+			close(s.ready)
+		}
+	}
+}
+
+// renew ends the watches of the current client, and lets the next ones run.
+// Caller needs to own the lock.
+func (s *session) renew() {
+	s.lose()
+	s.lost = make(chan struct{})
+}
+
+// lose closes the lost channel of the current client, if not yet. Caller
+// needs to own the lock.
+func (s *session) lose() {
+	select {
+	case <-s.lost:
+	default:
+		close(s.lost)
+	}
+}
+
+// Err returns the error of the last check, nil if the session is fine. While
+// the session keeps the old credentials because the new ones fail, it returns
+// why they fail.
+func (s *session) Err() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	return s.rotationErr
+}
+
+// Client returns the govmomi client, nil before the first login
+func (s *session) Client() *govmomi.Client {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.client
+}
+
+// Wait waits until the session is fine, it returns the client and a channel
+// closed once the client is no longer the one of a fine session, or false if
+// stopCh is closed first.
+func (s *session) Wait(stopCh <-chan struct{}) (*govmomi.Client, <-chan struct{}, bool) {
+	s.lock.Lock()
+	ready := s.ready
+	s.lock.Unlock()
+
+	select {
+	case <-ready:
+	case <-stopCh:
+		return nil, nil, false
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.client, s.lost, true
+}
+
+// Cluster returns the reference of the cluster named name, it is looked up
+// once per login.
+func (s *session) Cluster(ctx context.Context, client *govmomi.Client, name string) (types.ManagedObjectReference, error) {
+	s.lock.Lock()
+	ref, ok := s.clusters[name]
+	s.lock.Unlock()
+	if ok {
+		return ref, nil
+	}
+
+	ref, err := findCluster(ctx, client, name)
+	if err != nil {
+		return ref, err
+	}
+
+	s.lock.Lock()
+	s.clusters[name] = ref
+	s.lock.Unlock()
+
+	return ref, nil
+}
+
+// findCluster returns the reference of the cluster named clusterName
+func findCluster(ctx context.Context, vsclient *govmomi.Client, clusterName string) (types.ManagedObjectReference, error) {
+	m := view.NewManager(vsclient.Client)
+
+	v, err := m.CreateContainerView(ctx, vsclient.ServiceContent.RootFolder, []string{"ClusterComputeResource"}, true)
+	if err != nil {
+		return types.ManagedObjectReference{}, err
+	}
+
+	defer v.Destroy(ctx)
+
+	var clusters []mo.ClusterComputeResource
+	err = v.Retrieve(ctx, []string{"ClusterComputeResource"}, []string{"name"}, &clusters)
+	if err != nil {
+		return types.ManagedObjectReference{}, err
+	}
+
+	for i := range clusters {
+		if clusters[i].Name == clusterName {
+			return clusters[i].Reference(), nil
+		}
+	}
+
+	return types.ManagedObjectReference{}, fmt.Errorf("cannot find cluster named %s", clusterName)
+}
+
+// Logout signs off the session
+func (s *session) Logout() {
+	if client := s.Client(); client != nil {
+		client.Logout(context.Background())
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"log"
+	"time"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/vsphere-affinity-scheduling-plugin/pkg/metrics"
+)
+
+const (
+	// minBackoff is the wait after the first failure of a login or a watch
+	minBackoff = time.Second
+
+	// maxBackoff is the longest wait between retries
+	maxBackoff = 2 * time.Minute
+)
+
+// backoff doubles the wait after every failure, from minBackoff up to
+// maxBackoff
+type backoff struct {
+	next time.Duration
+}
+
+// failure returns how long to wait after a failure
+func (b *backoff) failure() time.Duration {
+	switch {
+	case b.next == 0:
+		b.next = minBackoff
+	case b.next < maxBackoff:
+		b.next *= 2
+		if b.next > maxBackoff {
+			b.next = maxBackoff
+		}
+	}
+	return b.next
+}
+
+// reset starts over from minBackoff
+func (b *backoff) reset() {
+	b.next = 0
+}
+
+// supervise runs watch with the client of session until stopCh is closed.
+// When watch fails, e.g. because the session is lost or the connection drops,
+// the session is checked, and watch starts over after a backoff. Every watch
+// needs to begin with a full list of its objects, so that its cache is
+// reconciled after an outage.
+//
+// watch needs to return once lost is closed, i.e. the session failed or logged
+// in again. It returns true if it got any update before failing, which resets
+// the backoff.
+func supervise(name string, s *session, stopCh <-chan struct{},
+	watch func(client *govmomi.Client, lost <-chan struct{}) (bool, error)) {
+	var b backoff
+	for {
+		client, lost, ok := s.Wait(stopCh)
+		if !ok {
+			return
+		}
+
+		updated, err := watch(client, lost)
+
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+
+		if updated {
+			b.reset()
+		}
+		wait := b.failure()
+		log.Printf("[ERROR] vsphere: %s watch failed, restart in %s: %v", name, wait, err)
+		metrics.VSphereWatchRestarts.Inc(name)
+
+		// The session might be lost, check it right away
+		s.Lost()
+
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi"
+)
+
+func TestBackoff(t *testing.T) {
+	var b backoff
+	var waits []time.Duration
+	for i := 0; i < 10; i++ {
+		waits = append(waits, b.failure())
+	}
+
+	expect := []time.Duration{1, 2, 4, 8, 16, 32, 64, 120, 120, 120}
+	for i := range expect {
+		if waits[i] != expect[i]*time.Second {
+			t.Errorf("expect wait %d to be %ds; got %s", i, expect[i], waits[i])
+		}
+	}
+
+	b.reset()
+	if wait := b.failure(); wait != minBackoff {
+		t.Errorf("expect %s after reset; got %s", minBackoff, wait)
+	}
+}
+
+func TestSessionReady(t *testing.T) {
+	s := newSession(&url.URL{Host: "vc1"}, false, &urlCredentials{})
+	closed := make(chan struct{})
+	close(closed)
+
+	if _, _, ok := s.Wait(closed); ok {
+		t.Errorf("expect not ready before login")
+	}
+	if s.Err() != errNotLoggedIn {
+		t.Errorf("expect %v; got %v", errNotLoggedIn, s.Err())
+	}
+
+	s.setErr(nil)
+	_, lost, ok := s.Wait(nil)
+	if !ok {
+		t.Errorf("expect ready after login")
+	}
+
+	// session lost
+	s.setErr(errors.New("connection refused"))
+	select {
+	case <-lost:
+	default:
+		t.Errorf("expect the watches to be stopped after session loss")
+	}
+	if _, _, ok := s.Wait(closed); ok {
+		t.Errorf("expect not ready after session loss")
+	}
+	s.setErr(errors.New("connection refused"))
+	if _, _, ok := s.Wait(closed); ok {
+		t.Errorf("expect not ready after another failure")
+	}
+
+	s.setErr(nil)
+	_, lost, ok = s.Wait(nil)
+	if !ok {
+		t.Errorf("expect ready after logging in again")
+	}
+	select {
+	case <-lost:
+		t.Errorf("expect the watches to run after logging in again")
+	default:
+	}
+}
+
+func TestSupervise(t *testing.T) {
+	s := newSession(&url.URL{Host: "vc1"}, false, &urlCredentials{})
+	s.setErr(nil)
+
+	stopCh := make(chan struct{})
+	watches := 0
+	done := make(chan struct{})
+	go func() {
+		supervise("test", s, stopCh, func(*govmomi.Client, <-chan struct{}) (bool, error) {
+			watches++
+			if watches == 2 {
+				close(stopCh)
+				return true, nil
+			}
+			return false, errors.New("session lost")
+		})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expect supervise to stop")
+	}
+
+	if watches != 2 {
+		t.Errorf("expect the watch to start over once; got %d watches", watches)
+	}
+
+	// the failure asks the session to be checked
+	select {
+	case <-s.wake:
+	default:
+		t.Errorf("expect the session to be woken up")
+	}
+}
+
+func TestSessionRotationFailure(t *testing.T) {
+	// nothing listens on the endpoint, so logging in fails
+	credentials := &urlCredentials{}
+	s := newSession(&url.URL{Scheme: "https", Host: "127.0.0.1:1", Path: "/sdk"}, true, credentials)
+	old := &govmomi.Client{}
+	s.client = old
+	s.setErr(nil)
+
+	credentials.user = url.UserPassword("user", "rotated")
+	s.setErr(s.check(context.Background()))
+
+	if s.Err() == nil {
+		t.Errorf("expect the failed rotation reported")
+	}
+	client, lost, ok := s.Wait(nil)
+	if !ok || client != old {
+		t.Errorf("expect the old client kept")
+	}
+	select {
+	case <-lost:
+		t.Errorf("expect the watches of the old client to keep running")
+	default:
+	}
+
+	// the credentials are rolled back
+	credentials.user = nil
+	s.setErr(s.check(context.Background()))
+	if err := s.Err(); err != nil {
+		t.Errorf("expect no error once the credentials work; got %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+// vmIndex maps VMs to keys identifying them, like their IP addresses, and
+// back. Several VMs may share a key.
+type vmIndex struct {
+	keys  map[string][]string
+	vmids map[string]map[string]struct{}
+}
+
+func newVMIndex() *vmIndex {
+	return &vmIndex{
+		keys:  make(map[string][]string),
+		vmids: make(map[string]map[string]struct{}),
+	}
+}
+
+// set replaces the keys of a VM, no keys removes it
+func (x *vmIndex) set(vmid string, keys []string) {
+	for _, key := range x.keys[vmid] {
+		delete(x.vmids[key], vmid)
+		if len(x.vmids[key]) == 0 {
+			delete(x.vmids, key)
+		}
+	}
+	delete(x.keys, vmid)
+
+	for _, key := range keys {
+		if _, ok := x.vmids[key]; !ok {
+			x.vmids[key] = make(map[string]struct{})
+		}
+		if _, ok := x.vmids[key][vmid]; ok {
+			continue
+		}
+		x.vmids[key][vmid] = struct{}{}
+		x.keys[vmid] = append(x.keys[vmid], key)
+	}
+}
+
+// get returns the keys of a VM
+func (x *vmIndex) get(vmid string) []string {
+	return append([]string(nil), x.keys[vmid]...)
+}
+
+// lookup returns the VMs having a key
+func (x *vmIndex) lookup(key string) []string {
+	result := []string{}
+	for vmid := range x.vmids[key] {
+		result = append(result, vmid)
+	}
+	return result
+}
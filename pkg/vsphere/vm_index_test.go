@@ -0,0 +1,73 @@
+/*
+Copyright (c) 201８ VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestVMIndex(t *testing.T) {
+	x := newVMIndex()
+	x.set("vm-1", []string{"10.0.0.1", "10.0.0.2"})
+	x.set("vm-2", []string{"10.0.0.2", "10.0.0.2"})
+
+	vmids := x.lookup("10.0.0.2")
+	sort.Strings(vmids)
+	if !reflect.DeepEqual(vmids, []string{"vm-1", "vm-2"}) {
+		t.Errorf("expect vm-1 and vm-2 with a shared key; got %v", vmids)
+	}
+	if keys := x.get("vm-2"); !reflect.DeepEqual(keys, []string{"10.0.0.2"}) {
+		t.Errorf("expect duplicated keys once; got %v", keys)
+	}
+
+	// replaced keys
+	x.set("vm-1", []string{"10.0.0.3"})
+	if vmids := x.lookup("10.0.0.1"); len(vmids) != 0 {
+		t.Errorf("expect no vm with a replaced key; got %v", vmids)
+	}
+	if vmids := x.lookup("10.0.0.2"); !reflect.DeepEqual(vmids, []string{"vm-2"}) {
+		t.Errorf("expect vm-2; got %v", vmids)
+	}
+
+	// removed VM
+	x.set("vm-2", nil)
+	if vmids := x.lookup("10.0.0.2"); len(vmids) != 0 {
+		t.Errorf("expect no vm after removal; got %v", vmids)
+	}
+	if len(x.keys) != 1 || len(x.vmids) != 1 {
+		t.Errorf("expect only vm-1 left; got %v %v", x.keys, x.vmids)
+	}
+}
+
+func TestHostnameKeys(t *testing.T) {
+	tests := []struct {
+		hostname string
+		expect   []string
+	}{
+		{"", nil},
+		{"node1", []string{"node1"}},
+		{"Node1.Example.com", []string{"node1.example.com", "node1"}},
+		{"10.0.0.1", []string{"10.0.0.1"}},
+	}
+	for _, test := range tests {
+		if keys := HostnameKeys(test.hostname); !reflect.DeepEqual(keys, test.expect) {
+			t.Errorf("expect keys of %s %v; got %v", test.hostname, test.expect, keys)
+		}
+	}
+}
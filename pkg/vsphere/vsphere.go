@@ -36,9 +36,25 @@ type Vsphere interface {
 	// DeleteAntiAffinityRule deletes an anti-affinity rule
 	DeleteAntiAffinityRule(name string) error
 
-	// Rules returns the applied VM-to-VM affinity and anti-affinity rules
+	// ApplyHostGroupRule applies a VM-to-Host rule so that DRS will schedule a
+	// list of vms on the hosts of a host group if affinity is true, or on the
+	// other hosts otherwise
+	ApplyHostGroupRule(name, hostGroup string, affinity bool, vms ...string) error
+
+	// DeleteHostGroupRule deletes a VM-to-Host rule
+	DeleteHostGroupRule(name string) error
+
+	// Rules returns the applied VM-to-VM affinity and anti-affinity rules, and
+	// the VM-to-Host rules
 	Rules() map[string]Rule
 
+	// HostGroups returns the names of the hosts in each DRS host group
+	HostGroups() map[string][]string
+
+	// LoginError returns why the client is not logged in to vCenter, nil if
+	// it is
+	LoginError() error
+
 	// Logout signs off the session
 	Logout()
 
@@ -60,7 +76,38 @@ type Querier interface {
 	// VMID. Empty string will be returned if it isn't found.
 	GetHostnameFromVMID(hostname string) string
 
-	// GetVMIDFromHostname gets the vSphere VMID from the virtual machine's
-	// hostname. Empty string will be returned if it isn't found.
-	GetVMIDFromHostname(vmid string) string
+	// GetVMIDsFromHostname gets the VMIDs of the virtual machines with a
+	// guest hostname, either full or short, ignoring case. Hostnames may
+	// collide, so there might be several of them.
+	GetVMIDsFromHostname(hostname string) []string
+
+	// GetUUIDFromVMID gets the BIOS UUID of a virtual machine in lower case.
+	// Empty string will be returned if it isn't found.
+	GetUUIDFromVMID(vmid string) string
+
+	// GetVMIDsFromUUID gets the VMIDs of the virtual machines with a BIOS
+	// UUID, ignoring case. Cloned virtual machines may share one.
+	GetVMIDsFromUUID(uuid string) []string
+
+	// GetIPsFromVMID gets the guest IP addresses of a virtual machine.
+	GetIPsFromVMID(vmid string) []string
+
+	// GetVMIDsFromIP gets the VMIDs of the virtual machines with a guest IP
+	// address.
+	GetVMIDsFromIP(ip string) []string
+
+	// GetDatastoresFromVMID gets the names of the datastores a virtual machine
+	// is stored on.
+	GetDatastoresFromVMID(vmid string) []string
+
+	// GetVMIDsFromDatastore gets the VMIDs of the virtual machines stored on a
+	// datastore.
+	GetVMIDsFromDatastore(datastore string) []string
+
+	// GetHostState gets the runtime state of an ESX server by its hostname.
+	// False will be returned if it isn't found.
+	GetHostState(host string) (HostState, bool)
+
+	// HasSynced returns true once the inventory of vSphere has been loaded
+	HasSynced() bool
 }